@@ -1,22 +1,48 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"database/sql"
 	"flag"
 	"fmt"
 	_ "github.com/lib/pq"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"social-media/internal/handler"
 	"social-media/internal/service"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	dsn       string
-	port      int
-	jwtSecret string
+	dsn          string
+	port         int
+	jwtSecret    string
+	jobsInterval time.Duration
+
+	outboxWorkers            int
+	timelineBackfillSize     int
+	keepInteractedOnUnfollow bool
+	fanoutFollowersThreshold int
+
+	origin          string
+	federationAllow string
+
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	mailDir string
+
+	vapidPrivateKey  string
+	vapidSubject     string
+	pushAllow        string
+	generateVAPIDKey bool
 }
 
 func main() {
@@ -24,23 +50,99 @@ func main() {
 	flag.StringVar(&config.dsn, "db-dsn", "", "Database source name")
 	flag.IntVar(&config.port, "port", 6001, "Server port")
 	flag.StringVar(&config.jwtSecret, "jwt-secret", "", "JWT secret")
+	flag.DurationVar(&config.jobsInterval, "jobs-interval", time.Second*10, "Background jobs polling interval")
+	flag.IntVar(&config.outboxWorkers, "outbox-workers", 4, "Number of workers running post-commit side effects")
+	flag.IntVar(&config.timelineBackfillSize, "timeline-backfill", 20, "Latest posts of a followee added to the follower timeline")
+	flag.BoolVar(&config.keepInteractedOnUnfollow, "unfollow-keep-interacted", true, "Keep posts the user interacted with in their timeline after unfollowing")
+	flag.IntVar(&config.fanoutFollowersThreshold, "fanout-threshold", 10000, "Followers count above which posts are merged into timelines at read time (0 to always fan out)")
+	flag.StringVar(&config.origin, "origin", "", "Public URL of this instance, ActivityPub IDs are built from it (defaults to http://localhost:<port>)")
+	flag.StringVar(&config.federationAllow, "federation-allow", "", "Comma separated private networks remote instances may be reached in, like 127.0.0.0/8 to federate over loopback")
+	flag.StringVar(&config.smtp.host, "smtp-host", "", "SMTP host to send email digests through")
+	flag.IntVar(&config.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&config.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&config.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&config.smtp.sender, "smtp-sender", "Social Media <no-reply@localhost>", "Sender of the emails")
+	flag.StringVar(&config.mailDir, "mail-dir", "", "Directory to write emails to as .eml files instead of sending them, when no SMTP host is given")
+	flag.StringVar(&config.vapidPrivateKey, "vapid-private-key", "", "Base64url encoded P-256 private key signing push notifications (push notifications are disabled if empty)")
+	flag.StringVar(&config.vapidSubject, "vapid-subject", "mailto:admin@localhost", "Contact push services may reach the instance operator at, a mailto: or https: URL")
+	flag.StringVar(&config.pushAllow, "push-allow", "", "Comma separated private networks push services may be reached in, like 127.0.0.0/8 to push to a local stand-in")
+	flag.BoolVar(&config.generateVAPIDKey, "generate-vapid-key", false, "Print a new key to pass as -vapid-private-key and exit")
 	flag.Parse()
 
+	if config.generateVAPIDKey {
+		key, err := service.GenerateVAPIDKey()
+		if err != nil {
+			log.Fatalf("could not generate vapid key: %v", err)
+		}
+
+		fmt.Println(key)
+		return
+	}
+
+	if config.origin == "" {
+		config.origin = fmt.Sprintf("http://localhost:%v", config.port)
+	}
+
+	federationAllowlist, err := parseNetworks(config.federationAllow)
+	if err != nil {
+		log.Fatalf("could not parse federation allowed network: %v", err)
+	}
+
+	pushAllowlist, err := parseNetworks(config.pushAllow)
+	if err != nil {
+		log.Fatalf("could not parse push allowed network: %v", err)
+	}
+
+	var vapidKey *ecdsa.PrivateKey
+	if config.vapidPrivateKey != "" {
+		vapidKey, err = service.ParseVAPIDKey(config.vapidPrivateKey)
+		if err != nil {
+			log.Fatalf("could not parse vapid key: %v", err)
+		}
+	} else {
+		log.Println("push notifications disabled; pass a key made with -generate-vapid-key as -vapid-private-key to enable them")
+	}
+
 	db, err := sql.Open("postgres", config.dsn)
 	if err != nil {
-		log.Fatalf("could not open db connection: %w", err)
+		log.Fatalf("could not open db connection: %v", err)
 	}
 
 	defer db.Close()
 
 	if err = db.Ping(); err != nil {
-		log.Fatalf("could not ping to db: %w", err)
+		log.Fatalf("could not ping to db: %v", err)
 	}
 
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	s := service.New(db, config.jwtSecret, fmt.Sprintf("http://localhost:%v/img/avatars/", config.port))
+	s.TimelineBackfillSize = config.timelineBackfillSize
+	s.KeepInteractedOnUnfollow = config.keepInteractedOnUnfollow
+	s.FanoutFollowersThreshold = config.fanoutFollowersThreshold
+	s.Origin = strings.TrimSuffix(config.origin, "/")
+	s.FederationAllowlist = federationAllowlist
+	s.VAPIDKey = vapidKey
+	s.VAPIDSubject = config.vapidSubject
+	s.PushAllowlist = pushAllowlist
+	if config.smtp.host != "" {
+		s.Mailer = &service.SMTPMailer{
+			Host:     config.smtp.host,
+			Port:     config.smtp.port,
+			Username: config.smtp.username,
+			Password: config.smtp.password,
+			Sender:   config.smtp.sender,
+		}
+	} else if config.mailDir != "" {
+		s.Mailer = &service.FileMailer{Dir: config.mailDir, Sender: config.smtp.sender}
+	}
 	h := handler.New(s, logger)
 
+	go s.PublishScheduledPosts(config.jobsInterval)
+	go s.PruneStaleDrafts(time.Hour)
+	go s.ClosePolls(config.jobsInterval)
+	go s.ProcessOutbox(config.outboxWorkers, config.jobsInterval)
+	go s.SendDigests(time.Minute)
+
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%v", config.port),
 		Handler:           h,
@@ -49,6 +151,24 @@ func main() {
 	}
 
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		logger.Fatalf("could not listen and serve: %w", err)
+		logger.Fatalf("could not listen and serve: %v", err)
+	}
+}
+
+// parseNetworks from a comma separated list of CIDR prefixes.
+func parseNetworks(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, prefix)
 	}
+	return prefixes, nil
 }
@@ -0,0 +1,237 @@
+// Command pushstub is a stand-in push service to try Web Push locally.
+// It makes up a browser subscription, prints it to register with the API,
+// then checks the VAPID token, decrypts and prints every push it gets.
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pascaldekloe/jwt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256DH string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+type stub struct {
+	key     *ecdsa.PrivateKey
+	public  []byte
+	authKey []byte
+	origin  string
+	status  int
+}
+
+func main() {
+	var port, status int
+	flag.IntVar(&port, "port", 6010, "Server port")
+	flag.IntVar(&status, "status", http.StatusCreated, "Status code to respond pushes with, like 410 to unsubscribe")
+	flag.Parse()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalf("could not generate key: %v", err)
+	}
+
+	authKey := make([]byte, 16)
+	if _, err = rand.Read(authKey); err != nil {
+		log.Fatalf("could not generate auth secret: %v", err)
+	}
+
+	s := &stub{
+		key:     key,
+		public:  elliptic.Marshal(elliptic.P256(), key.X, key.Y),
+		authKey: authKey,
+		origin:  fmt.Sprintf("http://localhost:%d", port),
+		status:  status,
+	}
+
+	var sub subscription
+	sub.Endpoint = s.origin + "/push/" + base64.RawURLEncoding.EncodeToString(authKey[:8])
+	sub.Keys.P256DH = base64.RawURLEncoding.EncodeToString(s.public)
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(authKey)
+	b, err := json.MarshalIndent(sub, "", "  ")
+	if err != nil {
+		log.Fatalf("could not json marshal subscription: %v", err)
+	}
+
+	fmt.Printf("POST it to /api/auth_user/push_subscriptions:\n%s\n", b)
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           s,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("could not listen and serve: %v", err)
+	}
+}
+
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/push/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := s.checkVAPID(r.Header.Get("Authorization")); err != nil {
+		log.Printf("unauthorized push: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") != "aes128gcm" {
+		http.Error(w, "content encoding must be aes128gcm", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096+86))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := s.decrypt(body)
+	if err != nil {
+		log.Printf("undecryptable push: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("push (TTL %s, urgency %s): %s", r.Header.Get("TTL"), r.Header.Get("Urgency"), payload)
+	w.WriteHeader(s.status)
+}
+
+// checkVAPID token of the authorization header, as of RFC 8292.
+func (s *stub) checkVAPID(authorization string) error {
+	params, ok := cutPrefix(authorization, "vapid ")
+	if !ok {
+		return errors.New("no vapid authorization")
+	}
+
+	var token, k string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			k = value
+		}
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(k)
+	if err != nil {
+		return fmt.Errorf("could not base64 decode vapid public key: %w", err)
+	}
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), b)
+	if x == nil {
+		return errors.New("vapid public key is not an uncompressed P-256 point")
+	}
+
+	claims, err := jwt.ECDSACheck([]byte(token), &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+	if err != nil {
+		return fmt.Errorf("could not check vapid token: %w", err)
+	}
+
+	if !claims.AcceptAudience(s.origin) {
+		return fmt.Errorf("vapid token audience %q is not %q", claims.Audiences, s.origin)
+	}
+
+	if !claims.Valid(time.Now()) {
+		return errors.New("vapid token expired")
+	}
+
+	return nil
+}
+
+// decrypt the aes128gcm content coding of a single record, keyed as of RFC 8291.
+func (s *stub) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("body too short")
+	}
+
+	salt, recordSize, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	if len(body) < 21+idLen || len(body)-21-idLen > int(recordSize) {
+		return nil, errors.New("invalid content coding header")
+	}
+
+	asPublic, ciphertext := body[21:21+idLen], body[21+idLen:]
+	x, y := elliptic.Unmarshal(elliptic.P256(), asPublic)
+	if x == nil {
+		return nil, errors.New("key id is not an uncompressed P-256 point")
+	}
+
+	sharedX, _ := elliptic.P256().ScalarMult(x, y, s.key.D.FillBytes(make([]byte, 32)))
+	keyInfo := append(append([]byte("WebPush: info\x00"), s.public...), asPublic...)
+	ikm := hkdf(s.authKey, sharedX.FillBytes(make([]byte, 32)), keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not open record: %w", err)
+	}
+
+	// Strip the padding up to the last record delimiter.
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+
+	if i < 0 || plaintext[i] != 0x02 {
+		return nil, errors.New("no last record delimiter")
+	}
+
+	return plaintext[:i], nil
+}
+
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+
+	return s[len(prefix):], true
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/matryer/way"
+	"io"
+	"mime"
+	"net/http"
+	"social-media/internal/service"
+	"strings"
+	"syscall"
+)
+
+// negotiateActivity serves ActivityPub documents to clients asking for them
+// and falls back to the other handler, the web app, for everyone else.
+func negotiateActivity(activity http.HandlerFunc, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if !acceptsActivity(r) {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		activity(w, r)
+	})
+}
+
+func acceptsActivity(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+
+		if mediaType == service.ActivityContentType ||
+			(mediaType == "application/ld+json" && strings.Contains(params["profile"], "activitystreams")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) respondActivity(w http.ResponseWriter, v interface{}, contentType string) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		h.respondErr(w, fmt.Errorf("could not json marshal activity response body: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(b)
+	if err != nil && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, context.Canceled) {
+		h.logger.Println(fmt.Errorf("could not write down activity response: %w", err))
+	}
+}
+
+func (h *handler) webFinger(w http.ResponseWriter, r *http.Request) {
+	wf, err := h.svc.WebFinger(r.Context(), r.URL.Query().Get("resource"))
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	h.respondActivity(w, wf, "application/jrd+json")
+}
+
+func (h *handler) actor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	a, err := h.svc.Actor(ctx, way.Param(ctx, "username"))
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respondActivity(w, a, service.ActivityContentType)
+}
+
+func (h *handler) actorFollowers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c, err := h.svc.ActorFollowers(ctx, way.Param(ctx, "username"))
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respondActivity(w, c, service.ActivityContentType)
+}
+
+func (h *handler) actorOutbox(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	username := way.Param(ctx, "username")
+
+	var c service.OrderedCollection
+	var err error
+	if q.Has("page") || q.Has("before") {
+		c, err = h.svc.ActorOutboxPage(ctx, username, emptyStrPtr(q.Get("before")))
+	} else {
+		c, err = h.svc.ActorOutbox(ctx, username)
+	}
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respondActivity(w, c, service.ActivityContentType)
+}
+
+func (h *handler) note(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	n, err := h.svc.Note(ctx, way.Param(ctx, "post_id"))
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respondActivity(w, n, service.ActivityContentType)
+}
+
+// inbox receives activities for a local user, or for the whole instance
+// when posted to the shared inbox.
+func (h *handler) inbox(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, service.ActivityMaxBodySize))
+	if err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	err = h.svc.ReceiveActivity(ctx, way.Param(ctx, "username"), service.SignedRequest{
+		Method:     r.Method,
+		RequestURI: r.URL.RequestURI(),
+		Host:       r.Host,
+		Header:     r.Header,
+		Body:       body,
+	})
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
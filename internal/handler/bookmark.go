@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/matryer/way"
+	"net/http"
+	"social-media/internal/service"
+	"strconv"
+)
+
+type bookmarkInput struct {
+	CollectionID *string `json:"collectionID"`
+}
+
+type createBookmarkCollectionInput struct {
+	Name string `json:"name"`
+}
+
+func (h *handler) bookmarks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
+	before := emptyStrPtr(q.Get("before"))
+	collectionID := emptyStrPtr(q.Get("collection_id"))
+	bb, err := h.svc.Bookmarks(r.Context(), collectionID, last, before)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if bb == nil {
+		bb = service.Bookmarks{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:     bb,
+		EndCursor: bb.EndCursor(),
+	}, http.StatusOK)
+}
+
+func (h *handler) bookmark(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in bookmarkInput
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			h.respondErr(w, errBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	postID := way.Param(ctx, "post_id")
+	if err := h.svc.Bookmark(ctx, postID, in.CollectionID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) deleteBookmark(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID := way.Param(ctx, "post_id")
+	if err := h.svc.DeleteBookmark(ctx, postID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) bookmarkCollections(w http.ResponseWriter, r *http.Request) {
+	cc, err := h.svc.BookmarkCollections(r.Context())
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if cc == nil {
+		cc = []service.BookmarkCollection{} // non null array
+	}
+
+	h.respond(w, cc, http.StatusOK)
+}
+
+func (h *handler) createBookmarkCollection(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in createBookmarkCollectionInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	c, err := h.svc.CreateBookmarkCollection(r.Context(), in.Name)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, c, http.StatusCreated)
+}
+
+func (h *handler) deleteBookmarkCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	collectionID := way.Param(ctx, "collection_id")
+	if err := h.svc.DeleteBookmarkCollection(ctx, collectionID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -6,7 +6,6 @@ import (
 	"mime"
 	"net/http"
 	"social-media/internal/service"
-	"strconv"
 )
 
 type createCommentInput struct {
@@ -42,9 +41,8 @@ func (h *handler) comments(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	q := r.URL.Query()
 	postID := way.Param(ctx, "post_id")
-	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
-	before := emptyStrPtr(q.Get("before"))
-	cc, err := h.svc.Comments(ctx, postID, last, before)
+	sort := service.CommentsSort(q.Get("sort"))
+	cc, info, err := h.svc.Comments(ctx, postID, sort, pageArgs(q))
 	if err != nil {
 		h.respondErr(w, err)
 		return
@@ -57,8 +55,53 @@ func (h *handler) comments(w http.ResponseWriter, r *http.Request) {
 	// h.respond(w, cc, http.StatusOK)
 
 	h.respond(w, paginatedRespBody{
-		Items:     cc,
-		EndCursor: cc.EndCursor(),
+		Items:       cc,
+		StartCursor: cc.SortedStartCursor(sort),
+		EndCursor:   cc.SortedEndCursor(sort),
+		PageInfo:    &info,
+	}, http.StatusOK)
+}
+
+func (h *handler) createReply(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in createCommentInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	commentID := way.Param(ctx, "comment_id")
+	c, err := h.svc.CreateReply(ctx, commentID, in.Content)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, c, http.StatusCreated)
+}
+
+func (h *handler) replies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	commentID := way.Param(ctx, "comment_id")
+	sort := service.CommentsSort(q.Get("sort"))
+	cc, info, err := h.svc.Replies(ctx, commentID, sort, pageArgs(q))
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if cc == nil {
+		cc = service.Comments{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:       cc,
+		StartCursor: cc.SortedStartCursor(sort),
+		EndCursor:   cc.SortedEndCursor(sort),
+		PageInfo:    &info,
 	}, http.StatusOK)
 }
 
@@ -71,7 +114,11 @@ func (h *handler) commentStream(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 	postID := way.Param(ctx, "post_id")
-	cc := h.svc.CommentStream(ctx, postID)
+	ee, err := h.svc.CommentStream(ctx, postID)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
 
 	header := w.Header()
 	header.Set("Cache-Control", "no-cache")
@@ -80,8 +127,8 @@ func (h *handler) commentStream(w http.ResponseWriter, r *http.Request) {
 
 	for {
 		select {
-		case c := <-cc:
-			h.writeSSE(w, c)
+		case e := <-ee:
+			h.writeSSEEvent(w, e.Type, e.Data)
 			f.Flush()
 		case <-ctx.Done():
 			return
@@ -89,6 +136,37 @@ func (h *handler) commentStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *handler) updateComment(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in createCommentInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	commentID := way.Param(ctx, "comment_id")
+	c, err := h.svc.UpdateComment(ctx, commentID, in.Content)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, c, http.StatusOK)
+}
+
+func (h *handler) deleteComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commentID := way.Param(ctx, "comment_id")
+	if err := h.svc.DeleteComment(ctx, commentID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *handler) toggleCommentLike(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	commentID := way.Param(ctx, "comment_id")
@@ -100,3 +178,15 @@ func (h *handler) toggleCommentLike(w http.ResponseWriter, r *http.Request) {
 
 	h.respond(w, out, http.StatusOK)
 }
+
+func (h *handler) toggleCommentPin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	commentID := way.Param(ctx, "comment_id")
+	out, err := h.svc.ToggleCommentPin(ctx, commentID)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, out, http.StatusOK)
+}
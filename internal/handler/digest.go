@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"social-media/internal/service"
+)
+
+type digestFrequencyBody struct {
+	Frequency service.DigestFrequency `json:"frequency"`
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Email digest</title>
+</head>
+<body>
+{{ if .Done }}
+<p>You won't get email digests anymore.</p>
+{{ else }}
+<form method="post">
+<p>Stop getting email digests of your unread notifications?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+{{ end }}
+</body>
+</html>
+`))
+
+func (h *handler) digestFrequency(w http.ResponseWriter, r *http.Request) {
+	f, err := h.svc.DigestFrequency(r.Context())
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, digestFrequencyBody{Frequency: f}, http.StatusOK)
+}
+
+func (h *handler) updateDigestFrequency(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in digestFrequencyBody
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	if err := h.svc.UpdateDigestFrequency(r.Context(), in.Frequency); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, in, http.StatusOK)
+}
+
+// unsubscribeDigestPage asks to confirm, so link scanners following
+// the unsubscribe link of a digest don't unsubscribe the user.
+func (h *handler) unsubscribeDigestPage(w http.ResponseWriter, r *http.Request) {
+	h.writeUnsubscribePage(w, false)
+}
+
+// unsubscribeDigest handles both the confirmation form
+// and the one-click List-Unsubscribe-Post of mail clients.
+func (h *handler) unsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.UnsubscribeDigest(r.Context(), r.URL.Query().Get("token")); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.writeUnsubscribePage(w, true)
+}
+
+func (h *handler) writeUnsubscribePage(w http.ResponseWriter, done bool) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := unsubscribePage.Execute(w, map[string]bool{"Done": done}); err != nil {
+		h.logger.Println(fmt.Errorf("could not write down unsubscribe page: %w", err))
+	}
+}
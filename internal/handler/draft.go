@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/matryer/way"
+	"net/http"
+	"social-media/internal/service"
+	"strconv"
+)
+
+type draftInput struct {
+	Content     string              `json:"content"`
+	SpoilerOf   *string             `json:"spoilerOf"`
+	NSFW        bool                `json:"nsfw"`
+	QuoteID     *string             `json:"quoteID"`
+	ReplyPolicy service.ReplyPolicy `json:"replyPolicy"`
+	Visibility  service.Visibility  `json:"visibility"`
+}
+
+func (in draftInput) service() service.DraftInput {
+	return service.DraftInput{
+		Content:     in.Content,
+		SpoilerOf:   in.SpoilerOf,
+		NSFW:        in.NSFW,
+		QuoteID:     in.QuoteID,
+		ReplyPolicy: in.ReplyPolicy,
+		Visibility:  in.Visibility,
+	}
+}
+
+func (h *handler) drafts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
+	before := emptyStrPtr(q.Get("before"))
+	dd, err := h.svc.Drafts(r.Context(), last, before)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if dd == nil {
+		dd = service.Drafts{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:     dd,
+		EndCursor: dd.EndCursor(),
+	}, http.StatusOK)
+}
+
+func (h *handler) draft(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	draftID := way.Param(ctx, "draft_id")
+	d, err := h.svc.Draft(ctx, draftID)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, d, http.StatusOK)
+}
+
+func (h *handler) createDraft(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in draftInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	d, err := h.svc.CreateDraft(r.Context(), in.service())
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, d, http.StatusCreated)
+}
+
+func (h *handler) updateDraft(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in draftInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	draftID := way.Param(ctx, "draft_id")
+	d, err := h.svc.UpdateDraft(ctx, draftID, in.service())
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, d, http.StatusOK)
+}
+
+func (h *handler) deleteDraft(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	draftID := way.Param(ctx, "draft_id")
+	if err := h.svc.DeleteDraft(ctx, draftID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) publishDraft(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	draftID := way.Param(ctx, "draft_id")
+	ti, err := h.svc.PublishDraft(ctx, draftID)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, ti, http.StatusCreated)
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+	"social-media/internal/service"
+	"strconv"
+)
+
+func (h *handler) explore(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mode := service.ExploreMode(q.Get("mode"))
+	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
+	before := emptyStrPtr(q.Get("before"))
+	ep, err := h.svc.Explore(r.Context(), mode, last, before)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	pp := ep.Posts
+	if pp == nil {
+		pp = service.Posts{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:     pp,
+		EndCursor: ep.EndCursor(),
+	}, http.StatusOK)
+}
@@ -0,0 +1,378 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/matryer/way"
+	"net/http"
+	"net/url"
+	"social-media/internal/service"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// feedSize is the number of latest posts in a feed.
+const feedSize = 20
+
+// feedTitleMaxLength in characters of the entries title taken from the post content.
+const feedTitleMaxLength = 80
+
+type feedFormat string
+
+const (
+	feedAtom feedFormat = "atom"
+	feedRSS  feedFormat = "rss"
+)
+
+// feed is the format agnostic representation of a feed.
+type feed struct {
+	// origin the relative links of the entries content are resolved against.
+	origin  string
+	id      string
+	title   string
+	link    string
+	self    string
+	updated time.Time
+	entries []feedEntry
+}
+
+type feedEntry struct {
+	id          string
+	title       string
+	link        string
+	author      string
+	authorLink  string
+	summary     string
+	contentHTML string
+	published   time.Time
+	categories  []string
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string      `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Link       atomLink       `xml:"link"`
+	Author     atomAuthor     `xml:"author"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Text        string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+func (h *handler) userAtomFeed(w http.ResponseWriter, r *http.Request) {
+	h.userFeed(w, r, feedAtom)
+}
+
+func (h *handler) userRSSFeed(w http.ResponseWriter, r *http.Request) {
+	h.userFeed(w, r, feedRSS)
+}
+
+func (h *handler) tagAtomFeed(w http.ResponseWriter, r *http.Request) {
+	h.tagFeed(w, r, feedAtom)
+}
+
+func (h *handler) tagRSSFeed(w http.ResponseWriter, r *http.Request) {
+	h.tagFeed(w, r, feedRSS)
+}
+
+func (h *handler) userFeed(w http.ResponseWriter, r *http.Request, format feedFormat) {
+	ctx := r.Context()
+	username := way.Param(ctx, "username")
+	u, err := h.svc.User(ctx, username)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	pp, _, err := h.svc.Posts(ctx, u.Username, service.PageArgs{Last: feedSize})
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	origin := requestOrigin(r)
+	link := origin + "/users/" + url.PathEscape(u.Username)
+	f := feed{
+		origin: origin,
+		id:     link,
+		title:  u.Username,
+		link:   link,
+		self:   origin + r.URL.Path,
+	}
+	for _, p := range pp {
+		p.User = &u.User
+		f.entries = append(f.entries, newFeedEntry(origin, p))
+	}
+
+	h.writeFeed(w, r, f, format)
+}
+
+func (h *handler) tagFeed(w http.ResponseWriter, r *http.Request, format feedFormat) {
+	ctx := r.Context()
+	tag := strings.ToLower(way.Param(ctx, "tag"))
+	pp, _, err := h.svc.TagPosts(ctx, tag, service.PageArgs{Last: feedSize})
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	origin := requestOrigin(r)
+	f := feed{
+		origin: origin,
+		id:     origin + "/tags/" + url.PathEscape(tag),
+		title:  "#" + tag,
+		link:   origin + "/",
+		self:   origin + r.URL.Path,
+	}
+	for _, p := range pp {
+		f.entries = append(f.entries, newFeedEntry(origin, p))
+	}
+
+	h.writeFeed(w, r, f, format)
+}
+
+// newFeedEntry from a post.
+// The content of spoilers and NSFW posts is left out of the feed
+// behind a warning, so it is only read on the site.
+func newFeedEntry(origin string, p service.Post) feedEntry {
+	link := origin + "/posts/" + p.ID
+	e := feedEntry{
+		id:        link,
+		link:      link,
+		published: p.CreatedAt,
+	}
+	if p.User != nil {
+		e.author = p.User.Username
+		e.authorLink = origin + "/users/" + url.PathEscape(p.User.Username)
+	}
+
+	for _, ent := range p.Entities {
+		if ent.Type == "hashtag" {
+			e.categories = append(e.categories, strings.ToLower(ent.Value))
+		}
+	}
+
+	if p.NSFW {
+		e.categories = append(e.categories, "nsfw")
+	}
+
+	switch {
+	case p.SpoilerOf != nil:
+		e.title = "Spoiler of " + *p.SpoilerOf
+		e.summary = "This post contains spoilers of " + *p.SpoilerOf + "."
+	case p.NSFW:
+		e.title = "NSFW post"
+		e.summary = "This post is marked as NSFW."
+	default:
+		e.title = feedTitle(p.Content)
+		e.contentHTML = p.ContentHTML
+	}
+
+	return e
+}
+
+// feedTitle is the first line of the content, shortened.
+func feedTitle(content string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if utf8.RuneCountInString(title) <= feedTitleMaxLength {
+		return title
+	}
+
+	return string([]rune(title)[:feedTitleMaxLength-1]) + "…"
+}
+
+// writeFeed encodes the feed in the given format.
+// Clients sending the ETag of an unchanged feed in If-None-Match
+// get a 304 Not Modified with no body.
+func (h *handler) writeFeed(w http.ResponseWriter, r *http.Request, f feed, format feedFormat) {
+	f.updated = time.Unix(0, 0).UTC()
+	for _, e := range f.entries {
+		if e.published.After(f.updated) {
+			f.updated = e.published
+		}
+	}
+
+	var v interface{}
+	var contentType string
+	switch format {
+	case feedRSS:
+		v, contentType = f.rss(), "application/rss+xml; charset=utf-8"
+	default:
+		v, contentType = f.atom(), "application/atom+xml; charset=utf-8"
+	}
+
+	b, err := xml.Marshal(v)
+	if err != nil {
+		h.respondErr(w, fmt.Errorf("could not xml marshal feed: %w", err))
+		return
+	}
+
+	b = append([]byte(xml.Header), b...)
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", f.updated.Format(http.TimeFormat))
+	header.Set("Cache-Control", "public, max-age=300")
+
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(b); err != nil {
+		h.logger.Println(fmt.Errorf("could not write down feed: %w", err))
+	}
+}
+
+func (f feed) atom() atomFeed {
+	out := atomFeed{
+		Base:    f.origin + "/",
+		ID:      f.id,
+		Title:   f.title,
+		Updated: f.updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "alternate", Type: "text/html", Href: f.link},
+			{Rel: "self", Type: "application/atom+xml", Href: f.self},
+		},
+		Entries: []atomEntry{},
+	}
+	for _, e := range f.entries {
+		entry := atomEntry{
+			ID:        e.id,
+			Title:     e.title,
+			Updated:   e.published.Format(time.RFC3339),
+			Published: e.published.Format(time.RFC3339),
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: e.link},
+			Author:    atomAuthor{Name: e.author, URI: e.authorLink},
+		}
+		if e.summary != "" {
+			entry.Summary = &atomText{Type: "text", Text: e.summary}
+		}
+		if e.contentHTML != "" {
+			entry.Content = &atomText{Type: "html", Text: e.contentHTML}
+		}
+		for _, c := range e.categories {
+			entry.Categories = append(entry.Categories, atomCategory{Term: c})
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return out
+}
+
+func (f feed) rss() rssFeed {
+	out := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.title,
+			Link:          f.link,
+			Description:   "Latest posts of " + f.title,
+			LastBuildDate: f.updated.Format(time.RFC1123Z),
+			Items:         []rssItem{},
+		},
+	}
+	for _, e := range f.entries {
+		description := e.contentHTML
+		if description == "" {
+			description = e.summary
+		}
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       e.title,
+			Link:        e.link,
+			GUID:        rssGUID{IsPermaLink: true, Text: e.link},
+			PubDate:     e.published.Format(time.RFC1123Z),
+			Description: description,
+			Categories:  e.categories,
+		})
+	}
+	return out
+}
+
+// etagMatch reports whether the If-None-Match header value matches the etag.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOrigin is the scheme and host the request was made to,
+// honoring the X-Forwarded-Proto header of a reverse proxy.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
@@ -27,23 +27,81 @@ func New(s *service.Service, logger *log.Logger) http.Handler {
 	api.HandleFunc(http.MethodGet, "/users/:username/followers", h.followers)
 	api.HandleFunc(http.MethodGet, "/users/:username/followees", h.followees)
 	api.HandleFunc(http.MethodPut, "/auth_user/avatar", h.updateAvatar)
+	api.HandleFunc(http.MethodGet, "/auth_user/scheduled_posts", h.scheduledPosts)
+	api.HandleFunc(http.MethodPatch, "/auth_user/scheduled_posts/:post_id", h.updateScheduledPost)
+	api.HandleFunc(http.MethodDelete, "/auth_user/scheduled_posts/:post_id", h.cancelScheduledPost)
+	api.HandleFunc(http.MethodGet, "/auth_user/drafts", h.drafts)
+	api.HandleFunc(http.MethodPost, "/auth_user/drafts", h.createDraft)
+	api.HandleFunc(http.MethodGet, "/auth_user/drafts/:draft_id", h.draft)
+	api.HandleFunc(http.MethodPut, "/auth_user/drafts/:draft_id", h.updateDraft)
+	api.HandleFunc(http.MethodDelete, "/auth_user/drafts/:draft_id", h.deleteDraft)
+	api.HandleFunc(http.MethodPost, "/auth_user/drafts/:draft_id/publish", h.publishDraft)
+	api.HandleFunc(http.MethodGet, "/auth_user/bookmarks", h.bookmarks)
+	api.HandleFunc(http.MethodPut, "/auth_user/bookmarks/:post_id", h.bookmark)
+	api.HandleFunc(http.MethodDelete, "/auth_user/bookmarks/:post_id", h.deleteBookmark)
+	api.HandleFunc(http.MethodGet, "/auth_user/bookmark_collections", h.bookmarkCollections)
+	api.HandleFunc(http.MethodPost, "/auth_user/bookmark_collections", h.createBookmarkCollection)
+	api.HandleFunc(http.MethodDelete, "/auth_user/bookmark_collections/:collection_id", h.deleteBookmarkCollection)
+	api.HandleFunc(http.MethodGet, "/users/:username/lists", h.lists)
+	api.HandleFunc(http.MethodPost, "/lists", h.createList)
+	api.HandleFunc(http.MethodGet, "/lists/:list_id", h.list)
+	api.HandleFunc(http.MethodDelete, "/lists/:list_id", h.deleteList)
+	api.HandleFunc(http.MethodGet, "/lists/:list_id/members", h.listMembers)
+	api.HandleFunc(http.MethodPut, "/lists/:list_id/members/:username", h.addListMember)
+	api.HandleFunc(http.MethodDelete, "/lists/:list_id/members/:username", h.removeListMember)
+	api.HandleFunc(http.MethodGet, "/lists/:list_id/timeline", h.listTimeline)
 	api.HandleFunc(http.MethodPost, "/timeline", h.createTimelineItem)
 	api.HandleFunc(http.MethodPost, "/posts/:post_id/toggle_like", h.togglePostLike)
 	api.HandleFunc(http.MethodGet, "/users/:username/posts", h.posts)
 	api.HandleFunc(http.MethodGet, "/posts/:post_id", h.post)
+	api.HandleFunc(http.MethodPatch, "/posts/:post_id", h.updatePost)
 	api.HandleFunc(http.MethodGet, "/timeline", h.timeline)
+	api.HandleFunc(http.MethodGet, "/explore", h.explore)
+	api.HandleFunc(http.MethodGet, "/tags/:tag/posts", h.tagPosts)
 	api.HandleFunc(http.MethodPost, "/posts/:post_id/comments", h.createComment)
 	api.HandleFunc(http.MethodGet, "/posts/:post_id/comments", h.comments)
+	api.HandleFunc(http.MethodPatch, "/comments/:comment_id", h.updateComment)
+	api.HandleFunc(http.MethodDelete, "/comments/:comment_id", h.deleteComment)
 	api.HandleFunc(http.MethodPost, "/comments/:comment_id/toggle_like", h.toggleCommentLike)
+	api.HandleFunc(http.MethodPost, "/comments/:comment_id/toggle_pin", h.toggleCommentPin)
+	api.HandleFunc(http.MethodPost, "/comments/:comment_id/replies", h.createReply)
+	api.HandleFunc(http.MethodGet, "/comments/:comment_id/replies", h.replies)
 	api.HandleFunc(http.MethodGet, "/notifications", h.notifications)
 	api.HandleFunc(http.MethodPost, "/notifications/:notification_id/mark_as_read", h.markNotificationAsRead)
 	api.HandleFunc(http.MethodPost, "/mark_notifications_as_read", h.markNotificationsAsRead)
 	api.HandleFunc(http.MethodGet, "/has_unread_notifications", h.hasUnreadNotifications)
+	api.HandleFunc(http.MethodGet, "/auth_user/notification_settings", h.notificationSettings)
+	api.HandleFunc(http.MethodPut, "/auth_user/notification_settings", h.updateNotificationSettings)
+	api.HandleFunc(http.MethodGet, "/auth_user/email_digest", h.digestFrequency)
+	api.HandleFunc(http.MethodPut, "/auth_user/email_digest", h.updateDigestFrequency)
+	api.HandleFunc(http.MethodGet, "/vapid_public_key", h.vapidPublicKey)
+	api.HandleFunc(http.MethodGet, "/auth_user/push_subscriptions", h.pushSubscriptions)
+	api.HandleFunc(http.MethodPost, "/auth_user/push_subscriptions", h.createPushSubscription)
+	api.HandleFunc(http.MethodDelete, "/auth_user/push_subscriptions/:subscription_id", h.deletePushSubscription)
 	api.HandleFunc(http.MethodPost, "/posts/:post_id/toggle_subscription", h.togglePostSubscription)
+	api.HandleFunc(http.MethodPost, "/posts/:post_id/toggle_repost", h.toggleRepost)
+	api.HandleFunc(http.MethodPost, "/posts/:post_id/poll/votes", h.votePoll)
+	api.HandleFunc(http.MethodGet, "/admin/outbox", h.stuckOutboxJobs)
+	api.HandleFunc(http.MethodPost, "/admin/outbox/:job_id/retry", h.retryOutboxJob)
+
+	static := withoutCache(h.staticHandler())
 
 	r := way.NewRouter()
 	r.Handle("*", "/api...", http.StripPrefix("/api", h.withAuth(api)))
-	r.Handle(http.MethodGet, "/...", withoutCache(h.staticHandler()))
+	r.HandleFunc(http.MethodGet, "/email_digest/unsubscribe", h.unsubscribeDigestPage)
+	r.HandleFunc(http.MethodPost, "/email_digest/unsubscribe", h.unsubscribeDigest)
+	r.HandleFunc(http.MethodGet, "/.well-known/webfinger", h.webFinger)
+	r.Handle(http.MethodGet, "/users/:username", negotiateActivity(h.actor, static))
+	r.Handle(http.MethodGet, "/users/:username/followers", negotiateActivity(h.actorFollowers, static))
+	r.HandleFunc(http.MethodGet, "/users/:username/outbox", h.actorOutbox)
+	r.HandleFunc(http.MethodPost, "/users/:username/inbox", h.inbox)
+	r.HandleFunc(http.MethodPost, "/inbox", h.inbox)
+	r.Handle(http.MethodGet, "/posts/:post_id", negotiateActivity(h.note, static))
+	r.HandleFunc(http.MethodGet, "/users/:username/feed.atom", h.userAtomFeed)
+	r.HandleFunc(http.MethodGet, "/users/:username/feed.rss", h.userRSSFeed)
+	r.HandleFunc(http.MethodGet, "/tags/:tag/feed.atom", h.tagAtomFeed)
+	r.HandleFunc(http.MethodGet, "/tags/:tag/feed.rss", h.tagRSSFeed)
+	r.Handle(http.MethodGet, "/...", static)
 
 	return r
 }
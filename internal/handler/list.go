@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/matryer/way"
+	"mime"
+	"net/http"
+	"social-media/internal/service"
+	"strconv"
+)
+
+type createListInput struct {
+	Name    string `json:"name"`
+	Private bool   `json:"private"`
+}
+
+func (h *handler) createList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in createListInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	l, err := h.svc.CreateList(r.Context(), in.Name, in.Private)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, l, http.StatusCreated)
+}
+
+func (h *handler) lists(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	username := way.Param(ctx, "username")
+	ll, err := h.svc.Lists(ctx, username)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if ll == nil {
+		ll = []service.List{} // non null array
+	}
+
+	h.respond(w, ll, http.StatusOK)
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := way.Param(ctx, "list_id")
+	l, err := h.svc.List(ctx, listID)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, l, http.StatusOK)
+}
+
+func (h *handler) deleteList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := way.Param(ctx, "list_id")
+	if err := h.svc.DeleteList(ctx, listID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	listID := way.Param(ctx, "list_id")
+	first, _ := strconv.ParseUint(q.Get("first"), 10, 64)
+	after := q.Get("after")
+	uu, err := h.svc.ListMembers(ctx, listID, first, after)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if uu == nil {
+		uu = service.UserProfiles{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:     uu,
+		EndCursor: uu.EndCursor(),
+	}, http.StatusOK)
+}
+
+func (h *handler) addListMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := way.Param(ctx, "list_id")
+	username := way.Param(ctx, "username")
+	if err := h.svc.AddListMember(ctx, listID, username); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) removeListMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := way.Param(ctx, "list_id")
+	username := way.Param(ctx, "username")
+	if err := h.svc.RemoveListMember(ctx, listID, username); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listTimeline(w http.ResponseWriter, r *http.Request) {
+	if a, _, err := mime.ParseMediaType(r.Header.Get("Accept")); err == nil && a == "text/event-stream" {
+		h.listStream(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	listID := way.Param(ctx, "list_id")
+	pp, info, err := h.svc.ListTimeline(ctx, listID, pageArgs(r.URL.Query()))
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if pp == nil {
+		pp = service.Posts{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:       pp,
+		StartCursor: pp.StartCursor(),
+		EndCursor:   pp.EndCursor(),
+		PageInfo:    &info,
+	}, http.StatusOK)
+}
+
+func (h *handler) listStream(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		h.respondErr(w, errStreamingUnsupported)
+		return
+	}
+
+	ctx := r.Context()
+	listID := way.Param(ctx, "list_id")
+	pp, err := h.svc.ListStream(ctx, listID)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+
+	for {
+		select {
+		case p := <-pp:
+			h.writeSSE(w, p)
+			f.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
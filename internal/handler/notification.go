@@ -1,11 +1,11 @@
 package handler
 
 import (
+	"encoding/json"
 	"github.com/matryer/way"
 	"mime"
 	"net/http"
 	"social-media/internal/service"
-	"strconv"
 )
 
 func (h *handler) notifications(w http.ResponseWriter, r *http.Request) {
@@ -14,10 +14,7 @@ func (h *handler) notifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	q := r.URL.Query()
-	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
-	before := emptyStrPtr(q.Get("before"))
-	nn, err := h.svc.Notifications(r.Context(), last, before)
+	nn, info, err := h.svc.Notifications(r.Context(), pageArgs(r.URL.Query()))
 	if err != nil {
 		h.respondErr(w, err)
 		return
@@ -30,8 +27,10 @@ func (h *handler) notifications(w http.ResponseWriter, r *http.Request) {
 	// h.respond(w, nn, http.StatusOK)
 
 	h.respond(w, paginatedRespBody{
-		Items:     nn,
-		EndCursor: nn.EndCursor(),
+		Items:       nn,
+		StartCursor: nn.StartCursor(),
+		EndCursor:   nn.EndCursor(),
+		PageInfo:    &info,
 	}, http.StatusOK)
 }
 
@@ -96,3 +95,31 @@ func (h *handler) hasUnreadNotifications(w http.ResponseWriter, r *http.Request)
 
 	h.respond(w, unread, http.StatusOK)
 }
+
+func (h *handler) notificationSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.svc.NotificationSettings(r.Context())
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, settings, http.StatusOK)
+}
+
+func (h *handler) updateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in []service.NotificationSetting
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	settings, err := h.svc.UpdateNotificationSettings(r.Context(), in)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, settings, http.StatusOK)
+}
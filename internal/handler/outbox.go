@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"github.com/matryer/way"
+	"net/http"
+	"social-media/internal/service"
+	"strconv"
+)
+
+func (h *handler) stuckOutboxJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
+	before := emptyStrPtr(q.Get("before"))
+	jj, err := h.svc.StuckOutboxJobs(r.Context(), last, before)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if jj == nil {
+		jj = service.OutboxJobs{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:     jj,
+		EndCursor: jj.EndCursor(),
+	}, http.StatusOK)
+}
+
+func (h *handler) retryOutboxJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	jobID := way.Param(ctx, "job_id")
+	if err := h.svc.RetryOutboxJob(ctx, jobID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,10 +1,10 @@
 package handler
 
 import (
+	"encoding/json"
 	"github.com/matryer/way"
 	"net/http"
 	"social-media/internal/service"
-	"strconv"
 )
 
 func (h *handler) togglePostLike(w http.ResponseWriter, r *http.Request) {
@@ -21,12 +21,9 @@ func (h *handler) togglePostLike(w http.ResponseWriter, r *http.Request) {
 
 func (h *handler) posts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	q := r.URL.Query()
-	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
-	before := emptyStrPtr(q.Get("before"))
 	username := way.Param(ctx, "username")
 
-	pp, err := h.svc.Posts(ctx, username, last, before)
+	pp, info, err := h.svc.Posts(ctx, username, pageArgs(r.URL.Query()))
 	if err != nil {
 		h.respondErr(w, err)
 		return
@@ -39,8 +36,10 @@ func (h *handler) posts(w http.ResponseWriter, r *http.Request) {
 	// h.respond(w, pp, http.StatusOK)
 
 	h.respond(w, paginatedRespBody{
-		Items:     pp,
-		EndCursor: pp.EndCursor(),
+		Items:       pp,
+		StartCursor: pp.StartCursor(),
+		EndCursor:   pp.EndCursor(),
+		PageInfo:    &info,
 	}, http.StatusOK)
 }
 
@@ -56,6 +55,32 @@ func (h *handler) post(w http.ResponseWriter, r *http.Request) {
 	h.respond(w, p, http.StatusOK)
 }
 
+type updatePostInput struct {
+	ReplyPolicy *service.ReplyPolicy `json:"replyPolicy"`
+}
+
+func (h *handler) updatePost(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in updatePostInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	postID := way.Param(ctx, "post_id")
+	p, err := h.svc.UpdatePost(ctx, postID, service.UpdatePostInput{
+		ReplyPolicy: in.ReplyPolicy,
+	})
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, p, http.StatusOK)
+}
+
 func (h *handler) togglePostSubscription(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	postID := way.Param(ctx, "post_id")
@@ -67,3 +92,39 @@ func (h *handler) togglePostSubscription(w http.ResponseWriter, r *http.Request)
 
 	h.respond(w, out, http.StatusOK)
 }
+
+func (h *handler) toggleRepost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID := way.Param(ctx, "post_id")
+	out, err := h.svc.ToggleRepost(ctx, postID)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, out, http.StatusOK)
+}
+
+type votePollInput struct {
+	OptionIDs []string `json:"optionIDs"`
+}
+
+func (h *handler) votePoll(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in votePollInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	postID := way.Param(ctx, "post_id")
+	poll, err := h.svc.VotePoll(ctx, postID, in.OptionIDs)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, poll, http.StatusCreated)
+}
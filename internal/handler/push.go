@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/matryer/way"
+	"net/http"
+	"social-media/internal/service"
+)
+
+type vapidPublicKeyBody struct {
+	PublicKey string `json:"publicKey"`
+}
+
+func (h *handler) vapidPublicKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.svc.VAPIDPublicKey()
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, vapidPublicKeyBody{PublicKey: key}, http.StatusOK)
+}
+
+func (h *handler) pushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ss, err := h.svc.PushSubscriptions(r.Context())
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if ss == nil {
+		ss = service.PushSubscriptions{} // non null array
+	}
+
+	h.respond(w, ss, http.StatusOK)
+}
+
+// createPushSubscription takes the JSON of the browser PushSubscription as is.
+func (h *handler) createPushSubscription(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in service.PushSubscription
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	out, err := h.svc.CreatePushSubscription(r.Context(), in)
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, out, http.StatusCreated)
+}
+
+func (h *handler) deletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	subscriptionID := way.Param(ctx, "subscription_id")
+	if err := h.svc.DeletePushSubscription(ctx, subscriptionID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
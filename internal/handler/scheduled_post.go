@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/matryer/way"
+	"net/http"
+	"social-media/internal/service"
+	"time"
+)
+
+type updateScheduledPostInput struct {
+	Content   *string    `json:"content"`
+	SpoilerOf *string    `json:"spoilerOf"`
+	NSFW      *bool      `json:"nsfw"`
+	PublishAt *time.Time `json:"publishAt"`
+}
+
+func (h *handler) scheduledPosts(w http.ResponseWriter, r *http.Request) {
+	pp, err := h.svc.ScheduledPosts(r.Context())
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if pp == nil {
+		pp = service.Posts{} // non null array
+	}
+
+	h.respond(w, pp, http.StatusOK)
+}
+
+func (h *handler) updateScheduledPost(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var in updateScheduledPostInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.respondErr(w, errBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	postID := way.Param(ctx, "post_id")
+	p, err := h.svc.UpdateScheduledPost(ctx, postID, service.UpdateScheduledPostInput{
+		Content:   in.Content,
+		SpoilerOf: in.SpoilerOf,
+		NSFW:      in.NSFW,
+		PublishAt: in.PublishAt,
+	})
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	h.respond(w, p, http.StatusOK)
+}
+
+func (h *handler) cancelScheduledPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	postID := way.Param(ctx, "post_id")
+	if err := h.svc.CancelScheduledPost(ctx, postID); err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
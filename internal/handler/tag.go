@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"github.com/matryer/way"
+	"net/http"
+	"social-media/internal/service"
+)
+
+func (h *handler) tagPosts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tag := way.Param(ctx, "tag")
+	pp, info, err := h.svc.TagPosts(ctx, tag, pageArgs(r.URL.Query()))
+	if err != nil {
+		h.respondErr(w, err)
+		return
+	}
+
+	if pp == nil {
+		pp = service.Posts{} // non null array
+	}
+
+	h.respond(w, paginatedRespBody{
+		Items:       pp,
+		StartCursor: pp.StartCursor(),
+		EndCursor:   pp.EndCursor(),
+		PageInfo:    &info,
+	}, http.StatusOK)
+}
@@ -5,13 +5,25 @@ import (
 	"mime"
 	"net/http"
 	"social-media/internal/service"
-	"strconv"
+	"time"
 )
 
 type createTimelineItemInput struct {
-	Content   string  `json:"content"`
-	SpoilerOf *string `json:"spoilerOf"`
-	NSFW      bool    `json:"nsfw"`
+	Content   string     `json:"content"`
+	SpoilerOf *string    `json:"spoilerOf"`
+	NSFW      bool       `json:"nsfw"`
+	QuoteID   *string    `json:"quoteID"`
+	PublishAt *time.Time `json:"publishAt"`
+	// ReplyPolicy defaults to everyone.
+	ReplyPolicy service.ReplyPolicy `json:"replyPolicy"`
+	// Visibility defaults to public.
+	Visibility service.Visibility `json:"visibility"`
+	Poll       *struct {
+		Options     []string  `json:"options"`
+		Multiple    bool      `json:"multiple"`
+		HideResults bool      `json:"hideResults"`
+		ClosesAt    time.Time `json:"closesAt"`
+	} `json:"poll"`
 }
 
 func (h *handler) createTimelineItem(w http.ResponseWriter, r *http.Request) {
@@ -22,7 +34,26 @@ func (h *handler) createTimelineItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ti, err := h.svc.CreateTimelineItem(r.Context(), in.Content, in.SpoilerOf, in.NSFW)
+	var poll *service.PollInput
+	if in.Poll != nil {
+		poll = &service.PollInput{
+			Options:     in.Poll.Options,
+			Multiple:    in.Poll.Multiple,
+			HideResults: in.Poll.HideResults,
+			ClosesAt:    in.Poll.ClosesAt,
+		}
+	}
+
+	ti, err := h.svc.CreateTimelineItem(r.Context(), service.CreateTimelineItemInput{
+		Content:     in.Content,
+		SpoilerOf:   in.SpoilerOf,
+		NSFW:        in.NSFW,
+		QuoteID:     in.QuoteID,
+		PublishAt:   in.PublishAt,
+		Poll:        poll,
+		ReplyPolicy: in.ReplyPolicy,
+		Visibility:  in.Visibility,
+	})
 	if err != nil {
 		h.respondErr(w, err)
 		return
@@ -39,9 +70,8 @@ func (h *handler) timeline(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 	q := r.URL.Query()
-	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
-	before := emptyStrPtr(q.Get("before"))
-	tt, err := h.svc.Timeline(ctx, last, before)
+	mode := service.TimelineMode(q.Get("mode"))
+	tt, info, err := h.svc.Timeline(ctx, mode, pageArgs(q))
 	if err != nil {
 		h.respondErr(w, err)
 		return
@@ -54,8 +84,10 @@ func (h *handler) timeline(w http.ResponseWriter, r *http.Request) {
 	// h.respond(w, tt, http.StatusOK)
 
 	h.respond(w, paginatedRespBody{
-		Items:     tt,
-		EndCursor: tt.EndCursor(),
+		Items:       tt,
+		StartCursor: tt.StartCursor(),
+		EndCursor:   tt.EndCursor(),
+		PageInfo:    &info,
 	}, http.StatusOK)
 }
 
@@ -81,8 +113,8 @@ func (h *handler) timelineItemStream(w http.ResponseWriter, r *http.Request) {
 
 	for {
 		select {
-		case ti := <-tt:
-			h.writeSSE(w, ti)
+		case e := <-tt:
+			h.writeSSEEvent(w, e.Type, e.Data)
 			f.Flush()
 		case <-ctx.Done():
 			return
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"social-media/internal/service"
+	"strconv"
 	"syscall"
 )
 
@@ -26,6 +28,7 @@ type paginatedRespBody struct {
 	Items       interface{} `json:"items"`
 	StartCursor *string     `json:"startCursor"`
 	EndCursor   *string     `json:"endCursor"`
+	*service.PageInfo
 }
 
 func (h *handler) respond(w http.ResponseWriter, v interface{}, statusCode int) {
@@ -100,12 +103,32 @@ func emptyStrPtr(s string) *string {
 	return &s
 }
 
+// pageArgs from the "first", "after", "last" and "before" query string parameters.
+func pageArgs(q url.Values) service.PageArgs {
+	first, _ := strconv.ParseUint(q.Get("first"), 10, 64)
+	last, _ := strconv.ParseUint(q.Get("last"), 10, 64)
+	return service.PageArgs{
+		First:  first,
+		After:  emptyStrPtr(q.Get("after")),
+		Last:   last,
+		Before: emptyStrPtr(q.Get("before")),
+	}
+}
+
 func (h *handler) writeSSE(w io.Writer, v interface{}) {
+	h.writeSSEEvent(w, "", v)
+}
+
+// writeSSEEvent writes a named event. An empty name is a plain message.
+func (h *handler) writeSSEEvent(w io.Writer, event string, v interface{}) {
 	b, err := json.Marshal(v)
 	if err != nil {
 		h.logger.Println("err", fmt.Errorf("could not json marshal sse data: %w", err))
 		return
 	}
 
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
 	fmt.Fprintf(w, "data: %s\n\n", b)
 }
@@ -0,0 +1,1289 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	activityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	securityContext        = "https://w3id.org/security/v1"
+	// publicAudience addresses an object to anyone.
+	publicAudience = "https://www.w3.org/ns/activitystreams#Public"
+
+	// ActivityContentType is the media type of ActivityPub documents.
+	ActivityContentType = "application/activity+json"
+	// ActivityMaxBodySize is the maximum size of an ActivityPub document, either received or fetched.
+	ActivityMaxBodySize = 1 << 20 // 1MB
+
+	activityAccept        = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+	federationUserAgent   = "social-media-federation/1.0"
+	actorOutboxPageSize   = 20
+	actorOutboxPageSuffix = "?page=true"
+)
+
+var (
+	// ErrInvalidResource denotes a WebFinger resource that is neither an acct URI nor a local actor ID.
+	ErrInvalidResource = InvalidArgumentError("invalid resource")
+	// ErrInvalidActivity denotes a malformed activity, or one that does not make sense for its actor.
+	ErrInvalidActivity = InvalidArgumentError("invalid activity")
+	// ErrInvalidActor denotes a remote actor that could not be understood,
+	// or that is not remote at all.
+	ErrInvalidActor = InvalidArgumentError("invalid actor")
+
+	errCommentExists = AlreadyExistsError("comment already exists")
+
+	reHTMLBreak = regexp.MustCompile(`(?i)<br\s*/?>|</p>\s*<p[^>]*>`)
+	reHTMLTag   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// WebFinger describes a local user to remote instances looking for their actor.
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type"`
+	Href string `json:"href"`
+}
+
+// Actor is the ActivityPub document of a local user.
+type Actor struct {
+	Context           []string       `json:"@context"`
+	ID                string         `json:"id"`
+	Type              string         `json:"type"`
+	PreferredUsername string         `json:"preferredUsername"`
+	Name              string         `json:"name"`
+	URL               string         `json:"url"`
+	Inbox             string         `json:"inbox"`
+	Outbox            string         `json:"outbox"`
+	Followers         string         `json:"followers"`
+	Icon              *ActorIcon     `json:"icon,omitempty"`
+	PublicKey         ActorPublicKey `json:"publicKey"`
+	Endpoints         ActorEndpoints `json:"endpoints"`
+}
+
+type ActorIcon struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type ActorPublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPEM string `json:"publicKeyPem"`
+}
+
+type ActorEndpoints struct {
+	SharedInbox string `json:"sharedInbox"`
+}
+
+// OrderedCollection of ActivityPub, or a page of it.
+type OrderedCollection struct {
+	Context      string     `json:"@context,omitempty"`
+	ID           string     `json:"id"`
+	Type         string     `json:"type"`
+	TotalItems   *int       `json:"totalItems,omitempty"`
+	First        string     `json:"first,omitempty"`
+	PartOf       string     `json:"partOf,omitempty"`
+	Next         string     `json:"next,omitempty"`
+	OrderedItems []Activity `json:"orderedItems,omitempty"`
+}
+
+// Activity of ActivityPub as sent by this instance.
+type Activity struct {
+	Context   string      `json:"@context,omitempty"`
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Actor     string      `json:"actor"`
+	Object    interface{} `json:"object"`
+	Published *time.Time  `json:"published,omitempty"`
+	To        []string    `json:"to,omitempty"`
+	Cc        []string    `json:"cc,omitempty"`
+}
+
+// Note is the ActivityPub object of a post.
+type Note struct {
+	Context      string    `json:"@context,omitempty"`
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	AttributedTo string    `json:"attributedTo"`
+	Content      string    `json:"content"`
+	Summary      *string   `json:"summary"`
+	Sensitive    bool      `json:"sensitive"`
+	Published    time.Time `json:"published"`
+	URL          string    `json:"url"`
+	To           []string  `json:"to"`
+	Cc           []string  `json:"cc"`
+}
+
+// incomingActivity is an activity received in an inbox.
+// The actor and object are either IDs or embedded objects.
+type incomingActivity struct {
+	ID           string          `json:"id"`
+	Type         string          `json:"type"`
+	Actor        json.RawMessage `json:"actor"`
+	Object       json.RawMessage `json:"object"`
+	Content      string          `json:"content"`
+	InReplyTo    json.RawMessage `json:"inReplyTo"`
+	AttributedTo json.RawMessage `json:"attributedTo"`
+}
+
+// localActor is a local user as seen by the fediverse.
+type localActor struct {
+	id           string
+	username     string
+	key          *rsa.PrivateKey
+	publicKeyPEM string
+}
+
+// remoteActor is a remote user, shadowed by a local users row
+// so they can follow, like and comment like anyone else.
+type remoteActor struct {
+	id          string
+	actorID     string
+	inbox       string
+	sharedInbox sql.NullString
+	keyID       string
+	publicKey   *rsa.PublicKey
+}
+
+// federationAddrAllowed denies private, loopback and otherwise non public addresses,
+// unless they are part of Service.FederationAllowlist.
+func (s *Service) federationAddrAllowed(addr netip.Addr) bool {
+	return publicAddrAllowed(s.FederationAllowlist, addr)
+}
+
+func (s *Service) originHost() string {
+	u, err := url.Parse(s.Origin)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+func (s *Service) actorID(username string) string {
+	return s.Origin + "/users/" + url.PathEscape(username)
+}
+
+func (s *Service) actorKeyID(username string) string {
+	return s.actorID(username) + "#main-key"
+}
+
+func (s *Service) noteID(postID string) string {
+	return s.Origin + "/posts/" + postID
+}
+
+// localUsername is the username of the local actor with the given ID.
+func (s *Service) localUsername(actorID string) (string, bool) {
+	prefix := s.Origin + "/users/"
+	if !strings.HasPrefix(actorID, prefix) {
+		return "", false
+	}
+
+	username, err := url.PathUnescape(strings.TrimPrefix(actorID, prefix))
+	if err != nil || !ValidUsername(username) {
+		return "", false
+	}
+
+	return username, true
+}
+
+// localPostID is the ID of the post with the given note ID.
+func (s *Service) localPostID(noteID string) (string, bool) {
+	prefix := s.Origin + "/posts/"
+	if !strings.HasPrefix(noteID, prefix) {
+		return "", false
+	}
+
+	postID := strings.TrimPrefix(noteID, prefix)
+	return postID, reUUID.MatchString(postID)
+}
+
+// objectID of an ActivityPub reference, given either as ID or as embedded object.
+func objectID(raw json.RawMessage) string {
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id
+	}
+
+	var obj struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		return obj.ID
+	}
+
+	return ""
+}
+
+// WebFinger resolves a local user from either an "acct:username@host" URI or their actor ID.
+func (s *Service) WebFinger(ctx context.Context, resource string) (WebFinger, error) {
+	var wf WebFinger
+	host := s.originHost()
+	var username string
+	if strings.HasPrefix(resource, "acct:") {
+		name, resourceHost, ok := strings.Cut(strings.TrimPrefix(resource, "acct:"), "@")
+		if !ok || !strings.EqualFold(resourceHost, host) {
+			return wf, ErrUserNotFound
+		}
+
+		username = name
+	} else if name, ok := s.localUsername(resource); ok {
+		username = name
+	} else {
+		return wf, ErrInvalidResource
+	}
+
+	if !ValidUsername(username) {
+		return wf, ErrInvalidUsername
+	}
+
+	if _, err := s.localUserID(ctx, username); err != nil {
+		return wf, err
+	}
+
+	actorID := s.actorID(username)
+	wf.Subject = "acct:" + username + "@" + host
+	wf.Aliases = []string{actorID}
+	wf.Links = []WebFingerLink{
+		{Rel: "self", Type: ActivityContentType, Href: actorID},
+		{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: actorID},
+	}
+	return wf, nil
+}
+
+// localUserID is the ID of the local user with the given username.
+// Remote users are not found.
+func (s *Service) localUserID(ctx context.Context, username string) (string, error) {
+	var uid string
+	query := "SELECT id FROM users WHERE username = $1 AND actor_id IS NULL"
+	err := s.Db.QueryRowContext(ctx, query, username).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not query select local user id from username: %w", err)
+	}
+
+	return uid, nil
+}
+
+// localActor of the local user with the given ID.
+// Their key pair is generated the first time they need it.
+func (s *Service) localActor(ctx context.Context, uid string) (localActor, error) {
+	a := localActor{id: uid}
+	var privPEM, pubPEM sql.NullString
+	query := "SELECT username, private_key, public_key FROM users WHERE id = $1 AND actor_id IS NULL"
+	err := s.Db.QueryRowContext(ctx, query, uid).Scan(&a.username, &privPEM, &pubPEM)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, ErrUserNotFound
+	}
+
+	if err != nil {
+		return a, fmt.Errorf("could not query select local actor: %w", err)
+	}
+
+	if !privPEM.Valid {
+		priv, pub, err := generateKeyPair()
+		if err != nil {
+			return a, err
+		}
+
+		// Whoever generated a key pair first wins.
+		query = `
+			UPDATE users SET private_key = COALESCE(private_key, $2)
+			, public_key = COALESCE(public_key, $3)
+			WHERE id = $1
+			RETURNING private_key, public_key`
+		if err = s.Db.QueryRowContext(ctx, query, uid, priv, pub).Scan(&privPEM, &pubPEM); err != nil {
+			return a, fmt.Errorf("could not update local actor key pair: %w", err)
+		}
+	}
+
+	if a.key, err = parsePrivateKey(privPEM.String); err != nil {
+		return a, err
+	}
+
+	a.publicKeyPEM = pubPEM.String
+	return a, nil
+}
+
+// Actor document of the local user with the given username.
+func (s *Service) Actor(ctx context.Context, username string) (Actor, error) {
+	var out Actor
+	if !ValidUsername(username) {
+		return out, ErrInvalidUsername
+	}
+
+	uid, err := s.localUserID(ctx, username)
+	if err != nil {
+		return out, err
+	}
+
+	a, err := s.localActor(ctx, uid)
+	if err != nil {
+		return out, err
+	}
+
+	var avatar sql.NullString
+	query := "SELECT avatar FROM users WHERE id = $1"
+	if err = s.Db.QueryRowContext(ctx, query, uid).Scan(&avatar); err != nil {
+		return out, fmt.Errorf("could not query select actor avatar: %w", err)
+	}
+
+	id := s.actorID(a.username)
+	out = Actor{
+		Context:           []string{activityStreamsContext, securityContext},
+		ID:                id,
+		Type:              "Person",
+		PreferredUsername: a.username,
+		Name:              a.username,
+		URL:               id,
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		PublicKey: ActorPublicKey{
+			ID:           s.actorKeyID(a.username),
+			Owner:        id,
+			PublicKeyPEM: a.publicKeyPEM,
+		},
+		Endpoints: ActorEndpoints{SharedInbox: s.Origin + "/inbox"},
+	}
+	if avatarURL := s.avatarURL(avatar); avatarURL != nil {
+		out.Icon = &ActorIcon{Type: "Image", URL: *avatarURL}
+	}
+	return out, nil
+}
+
+// ActorFollowers is the followers collection of the local user with the given username.
+// Only its size is disclosed.
+func (s *Service) ActorFollowers(ctx context.Context, username string) (OrderedCollection, error) {
+	var out OrderedCollection
+	if !ValidUsername(username) {
+		return out, ErrInvalidUsername
+	}
+
+	var followersCount int
+	query := "SELECT followers_count FROM users WHERE username = $1 AND actor_id IS NULL"
+	err := s.Db.QueryRowContext(ctx, query, username).Scan(&followersCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return out, ErrUserNotFound
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not query select actor followers count: %w", err)
+	}
+
+	return OrderedCollection{
+		Context:    activityStreamsContext,
+		ID:         s.actorID(username) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: &followersCount,
+	}, nil
+}
+
+// ActorOutbox is the outbox collection of the local user with the given username.
+// Its items are paginated with ActorOutboxPage.
+func (s *Service) ActorOutbox(ctx context.Context, username string) (OrderedCollection, error) {
+	var out OrderedCollection
+	if !ValidUsername(username) {
+		return out, ErrInvalidUsername
+	}
+
+	uid, err := s.localUserID(ctx, username)
+	if err != nil {
+		return out, err
+	}
+
+	var postsCount int
+	query := "SELECT count(*) FROM posts WHERE user_id = $1 AND visibility = 'public' AND publish_at IS NULL"
+	if err = s.Db.QueryRowContext(ctx, query, uid).Scan(&postsCount); err != nil {
+		return out, fmt.Errorf("could not query select actor outbox size: %w", err)
+	}
+
+	id := s.actorID(username) + "/outbox"
+	return OrderedCollection{
+		Context:    activityStreamsContext,
+		ID:         id,
+		Type:       "OrderedCollection",
+		TotalItems: &postsCount,
+		First:      id + actorOutboxPageSuffix,
+	}, nil
+}
+
+// ActorOutboxPage lists the public posts of the local user with the given username
+// as Create activities, most recent first and with backward pagination.
+func (s *Service) ActorOutboxPage(ctx context.Context, username string, before *string) (OrderedCollection, error) {
+	var out OrderedCollection
+	if !ValidUsername(username) {
+		return out, ErrInvalidUsername
+	}
+
+	if _, err := s.localUserID(ctx, username); err != nil {
+		return out, err
+	}
+
+	// The outbox is public, whoever asks for it.
+	ctx = context.WithValue(ctx, KeyAuthUserID, nil)
+	pp, info, err := s.Posts(ctx, username, PageArgs{Last: actorOutboxPageSize, Before: before})
+	if err != nil {
+		return out, err
+	}
+
+	id := s.actorID(username) + "/outbox"
+	out = OrderedCollection{
+		Context:      activityStreamsContext,
+		ID:           id + actorOutboxPageSuffix,
+		Type:         "OrderedCollectionPage",
+		PartOf:       id,
+		OrderedItems: []Activity{},
+	}
+	if before != nil {
+		out.ID = id + "?before=" + url.QueryEscape(*before)
+	}
+	if cursor := pp.EndCursor(); cursor != nil && info.HasNextPage != nil && *info.HasNextPage {
+		out.Next = id + "?before=" + url.QueryEscape(*cursor)
+	}
+
+	for _, p := range pp {
+		if p.Visibility != VisibilityPublic {
+			continue
+		}
+
+		p.User = &User{Username: username}
+		out.OrderedItems = append(out.OrderedItems, s.createNoteActivity(s.note(p)))
+	}
+	return out, nil
+}
+
+// Note of the public post with the given ID.
+func (s *Service) Note(ctx context.Context, postID string) (Note, error) {
+	if !reUUID.MatchString(postID) {
+		return Note{}, ErrInvalidPostID
+	}
+
+	// Only public posts are served, whoever asks for them.
+	p, err := s.Post(context.WithValue(ctx, KeyAuthUserID, nil), postID)
+	if err != nil {
+		return Note{}, err
+	}
+
+	n := s.note(p)
+	n.Context = activityStreamsContext
+	return n, nil
+}
+
+// note of a post. The post must have its user set.
+// Spoilers and NSFW posts are sent as sensitive with a content warning.
+func (s *Service) note(p Post) Note {
+	id := s.noteID(p.ID)
+	actorID := s.actorID(p.User.Username)
+	n := Note{
+		ID:           id,
+		Type:         "Note",
+		AttributedTo: actorID,
+		// Links to local users are relative.
+		Content:   strings.ReplaceAll(p.ContentHTML, `href="/`, `href="`+s.Origin+`/`),
+		Sensitive: p.NSFW || p.SpoilerOf != nil,
+		Published: p.CreatedAt,
+		URL:       id,
+		To:        []string{publicAudience},
+		Cc:        []string{actorID + "/followers"},
+	}
+	if p.Visibility == VisibilityFollowers {
+		n.To = []string{actorID + "/followers"}
+		n.Cc = []string{}
+	}
+
+	switch {
+	case p.SpoilerOf != nil:
+		n.Summary = ptrString("Spoiler of " + *p.SpoilerOf)
+	case p.NSFW:
+		n.Summary = ptrString("NSFW")
+	}
+	return n
+}
+
+func (s *Service) createNoteActivity(n Note) Activity {
+	published := n.Published
+	return Activity{
+		ID:        n.ID + "/activity",
+		Type:      "Create",
+		Actor:     n.AttributedTo,
+		Object:    n,
+		Published: &published,
+		To:        n.To,
+		Cc:        n.Cc,
+	}
+}
+
+// resolveRemoteActor finds the remote actor with the given username and host through WebFinger.
+// Remote instances are reached with the same scheme as this one.
+func (s *Service) resolveRemoteActor(ctx context.Context, username, host string, signer *localActor) (remoteActor, error) {
+	acct := username + "@" + host
+	var actorID string
+	query := "SELECT actor_id FROM users WHERE username = $1 AND actor_id IS NOT NULL"
+	err := s.Db.QueryRowContext(ctx, query, acct).Scan(&actorID)
+	if err == nil {
+		return s.remoteActor(ctx, actorID, signer, false)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return remoteActor{}, fmt.Errorf("could not query select remote actor id: %w", err)
+	}
+
+	origin, err := url.Parse(s.Origin)
+	if err != nil {
+		return remoteActor{}, fmt.Errorf("could not parse origin: %w", err)
+	}
+
+	u := url.URL{
+		Scheme:   origin.Scheme,
+		Host:     host,
+		Path:     "/.well-known/webfinger",
+		RawQuery: url.Values{"resource": {"acct:" + acct}}.Encode(),
+	}
+	var wf WebFinger
+	if err = s.fetchActivityJSON(ctx, u.String(), nil, &wf); err != nil {
+		return remoteActor{}, fmt.Errorf("could not fetch webfinger: %w", err)
+	}
+
+	for _, link := range wf.Links {
+		if link.Rel != "self" {
+			continue
+		}
+
+		if mediaType, _, _ := mime.ParseMediaType(link.Type); mediaType == ActivityContentType || mediaType == "application/ld+json" {
+			return s.remoteActor(ctx, link.Href, signer, false)
+		}
+	}
+
+	return remoteActor{}, ErrUserNotFound
+}
+
+// toggleRemoteFollow follows or unfollows a remote user, sending them the Follow or its Undo.
+// The follow is recorded right away, without waiting for it to be accepted.
+func (s *Service) toggleRemoteFollow(ctx context.Context, followerID, username, host string) (ToggleFollowOutput, error) {
+	var out ToggleFollowOutput
+	if username == "" || host == "" || strings.ContainsAny(username+host, "@/?# \t\n") {
+		return out, ErrInvalidUsername
+	}
+
+	follower, err := s.localActor(ctx, followerID)
+	if errors.Is(err, ErrUserNotFound) {
+		return out, ErrUserGone
+	}
+
+	if err != nil {
+		return out, err
+	}
+
+	followee, err := s.resolveRemoteActor(ctx, username, host, &follower)
+	if errors.Is(err, ErrInvalidActor) {
+		return out, ErrUserNotFound
+	}
+
+	if err != nil {
+		return out, err
+	}
+
+	query := "SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2)"
+	if err = s.Db.QueryRowContext(ctx, query, followerID, followee.id).Scan(&out.Following); err != nil {
+		return out, fmt.Errorf("could not query select existence of remote follow: %w", err)
+	}
+
+	followerActorID := s.actorID(follower.username)
+	activity := Activity{
+		Context: activityStreamsContext,
+		ID:      followerActorID + "#follows/" + followee.id,
+		Type:    "Follow",
+		Actor:   followerActorID,
+		Object:  followee.actorID,
+	}
+	if out.Following {
+		activity.ID += "/undo"
+		activity.Type = "Undo"
+		activity.Object = Activity{
+			ID:     followerActorID + "#follows/" + followee.id,
+			Type:   "Follow",
+			Actor:  followerActorID,
+			Object: followee.actorID,
+		}
+	}
+
+	b, err := json.Marshal(activity)
+	if err != nil {
+		return out, fmt.Errorf("could not json marshal follow activity: %w", err)
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return out, fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	if out.Following {
+		query = "DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2"
+		if _, err = tx.ExecContext(ctx, query, followerID, followee.id); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not delete remote follow: %w", err)
+		}
+
+		query = "UPDATE users SET followees_count = followees_count - 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, followerID); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not decrement followees count: %w", err)
+		}
+
+		query = `
+			UPDATE users SET followers_count = followers_count - 1 WHERE id = $1
+			RETURNING followers_count`
+		if err = tx.QueryRowContext(ctx, query, followee.id).Scan(&out.FollowersCount); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not decrement remote followers count: %w", err)
+		}
+	} else {
+		query = "INSERT INTO follows (follower_id, followee_id) VALUES ($1, $2)"
+		if _, err = tx.ExecContext(ctx, query, followerID, followee.id); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not insert remote follow: %w", err)
+		}
+
+		query = "UPDATE users SET followees_count = followees_count + 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, followerID); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not increment followees count: %w", err)
+		}
+
+		query = `
+			UPDATE users SET followers_count = followers_count + 1 WHERE id = $1
+			RETURNING followers_count`
+		if err = tx.QueryRowContext(ctx, query, followee.id).Scan(&out.FollowersCount); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not increment remote followers count: %w", err)
+		}
+	}
+
+	err = enqueue(ctx, tx, outboxDelivery, deliveryJob{
+		SenderID: followerID,
+		Inbox:    followee.inbox,
+		Activity: b,
+	})
+	if err != nil {
+		tx.Rollback()
+		return out, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return out, fmt.Errorf("could not commit to toggle remote follow: %w", err)
+	}
+
+	s.wakeOutbox()
+
+	out.Following = !out.Following
+	return out, nil
+}
+
+// ReceiveActivity posted to the inbox of the local user with the given username,
+// or to the shared inbox if no username is given.
+// The request must be signed by the actor of the activity.
+// Follow, Like and Undo of those are applied,
+// and so are Create of notes replying to local posts, as comments.
+// Any other activity is accepted and ignored.
+func (s *Service) ReceiveActivity(ctx context.Context, username string, r SignedRequest) error {
+	// Fetches on behalf of a personal inbox are signed by its owner,
+	// for the instances requiring authorized fetches.
+	var signer *localActor
+	if username != "" {
+		if !ValidUsername(username) {
+			return ErrInvalidUsername
+		}
+
+		uid, err := s.localUserID(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		a, err := s.localActor(ctx, uid)
+		if err != nil {
+			return err
+		}
+
+		signer = &a
+	}
+
+	var act incomingActivity
+	if err := json.Unmarshal(r.Body, &act); err != nil {
+		return ErrInvalidActivity
+	}
+
+	actorID := objectID(act.Actor)
+	if act.ID == "" || act.Type == "" || actorID == "" {
+		return ErrInvalidActivity
+	}
+
+	sig, err := parseSignature(r.Header)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	actor, err := s.remoteActor(ctx, actorID, signer, false)
+	if err != nil {
+		return err
+	}
+
+	if sig.keyID != actor.keyID || sig.verify(r, actor.publicKey) != nil {
+		// The key may have changed since the actor was last fetched.
+		if actor, err = s.remoteActor(ctx, actorID, signer, true); err != nil {
+			return err
+		}
+
+		if sig.keyID != actor.keyID {
+			return ErrInvalidSignature
+		}
+
+		if err = sig.verify(r, actor.publicKey); err != nil {
+			log.Println("error", fmt.Errorf("could not verify activity %s signature: %w", act.ID, err))
+			return ErrInvalidSignature
+		}
+	}
+
+	switch act.Type {
+	case "Follow":
+		return s.receiveFollow(ctx, actor, act, r.Body)
+	case "Like":
+		return s.receiveLike(ctx, actor, objectID(act.Object))
+	case "Undo":
+		var undone incomingActivity
+		if err = json.Unmarshal(act.Object, &undone); err != nil {
+			// Only an ID; nothing to tell what to undo.
+			return nil
+		}
+
+		if objectID(undone.Actor) != actor.actorID {
+			return ErrInvalidActivity
+		}
+
+		switch undone.Type {
+		case "Follow":
+			return s.receiveUnfollow(ctx, actor, objectID(undone.Object))
+		case "Like":
+			return s.receiveUnlike(ctx, actor, objectID(undone.Object))
+		}
+	case "Create":
+		var obj incomingActivity
+		if err = json.Unmarshal(act.Object, &obj); err != nil {
+			return nil
+		}
+
+		if obj.Type != "Note" {
+			return nil
+		}
+
+		return s.receiveNote(ctx, actor, obj)
+	}
+
+	return nil
+}
+
+// remoteActor with the given actor ID, as cached in its shadow users row
+// or fetched and upserted when not cached or if refresh.
+func (s *Service) remoteActor(ctx context.Context, actorID string, signer *localActor, refresh bool) (remoteActor, error) {
+	a := remoteActor{actorID: actorID}
+	if !refresh {
+		var publicKey string
+		query := "SELECT id, inbox, shared_inbox, public_key_id, public_key FROM users WHERE actor_id = $1"
+		err := s.Db.QueryRowContext(ctx, query, actorID).Scan(&a.id, &a.inbox, &a.sharedInbox, &a.keyID, &publicKey)
+		if err == nil {
+			if a.publicKey, err = parsePublicKey(publicKey); err == nil {
+				return a, nil
+			}
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return a, fmt.Errorf("could not query select remote actor: %w", err)
+		}
+	}
+
+	u, err := url.Parse(actorID)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return a, ErrInvalidActor
+	}
+
+	if strings.EqualFold(u.Host, s.originHost()) {
+		return a, ErrInvalidActor
+	}
+
+	var doc struct {
+		ID                string `json:"id"`
+		PreferredUsername string `json:"preferredUsername"`
+		Inbox             string `json:"inbox"`
+		Endpoints         struct {
+			SharedInbox string `json:"sharedInbox"`
+		} `json:"endpoints"`
+		PublicKey struct {
+			ID           string `json:"id"`
+			Owner        string `json:"owner"`
+			PublicKeyPEM string `json:"publicKeyPem"`
+		} `json:"publicKey"`
+	}
+	if err = s.fetchActivityJSON(ctx, actorID, signer, &doc); err != nil {
+		return a, fmt.Errorf("could not fetch remote actor: %w", err)
+	}
+
+	if doc.ID != actorID || doc.Inbox == "" || doc.PublicKey.Owner != actorID || doc.PublicKey.ID == "" ||
+		doc.PreferredUsername == "" || strings.ContainsAny(doc.PreferredUsername, "@/ \t\n") {
+		return a, ErrInvalidActor
+	}
+
+	if a.publicKey, err = parsePublicKey(doc.PublicKey.PublicKeyPEM); err != nil {
+		return a, ErrInvalidActor
+	}
+
+	a.inbox = doc.Inbox
+	a.sharedInbox = sql.NullString{String: doc.Endpoints.SharedInbox, Valid: doc.Endpoints.SharedInbox != ""}
+	a.keyID = doc.PublicKey.ID
+
+	query := `
+		INSERT INTO users (username, actor_id, inbox, shared_inbox, public_key_id, public_key)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (actor_id) DO UPDATE SET
+			inbox = EXCLUDED.inbox,
+			shared_inbox = EXCLUDED.shared_inbox,
+			public_key_id = EXCLUDED.public_key_id,
+			public_key = EXCLUDED.public_key
+		RETURNING id`
+	err = s.Db.QueryRowContext(ctx, query, doc.PreferredUsername+"@"+u.Host, actorID, a.inbox, a.sharedInbox, a.keyID, doc.PublicKey.PublicKeyPEM).
+		Scan(&a.id)
+	if isUniqueViolation(err) {
+		// Another actor of the same instance took the username before.
+		return a, ErrInvalidActor
+	}
+
+	if err != nil {
+		return a, fmt.Errorf("could not upsert remote actor: %w", err)
+	}
+
+	return a, nil
+}
+
+// fetchActivityJSON decodes the ActivityPub document with the given ID.
+// The request is signed if a signer is given.
+func (s *Service) fetchActivityJSON(ctx context.Context, id string, signer *localActor, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Accept", activityAccept)
+	req.Header.Set("User-Agent", federationUserAgent)
+	if signer != nil {
+		if err = signRequest(req, nil, s.actorKeyID(signer.username), signer.key); err != nil {
+			return err
+		}
+	}
+
+	resp, err := s.federationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if err = json.NewDecoder(io.LimitReader(resp.Body, ActivityMaxBodySize)).Decode(v); err != nil {
+		return fmt.Errorf("could not json decode response body: %w", err)
+	}
+
+	return nil
+}
+
+// receiveFollow makes the remote actor follow a local user and accepts the follow right away.
+func (s *Service) receiveFollow(ctx context.Context, actor remoteActor, act incomingActivity, raw []byte) error {
+	username, ok := s.localUsername(objectID(act.Object))
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	followeeID, err := s.localUserID(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	accept, err := json.Marshal(Activity{
+		Context: activityStreamsContext,
+		ID:      s.actorID(username) + "#accepts/" + url.QueryEscape(act.ID),
+		Type:    "Accept",
+		Actor:   s.actorID(username),
+		Object:  json.RawMessage(raw),
+	})
+	if err != nil {
+		return fmt.Errorf("could not json marshal follow accept: %w", err)
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	query := `
+		INSERT INTO follows (follower_id, followee_id) VALUES ($1, $2)
+		ON CONFLICT (follower_id, followee_id) DO NOTHING`
+	res, err := tx.ExecContext(ctx, query, actor.id, followeeID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not insert remote follow: %w", err)
+	}
+
+	// A follow sent again is accepted again, but counted once.
+	if n, _ := res.RowsAffected(); n != 0 {
+		query = "UPDATE users SET followees_count = followees_count + 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, actor.id); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not increment remote followees count: %w", err)
+		}
+
+		query = "UPDATE users SET followers_count = followers_count + 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, followeeID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not increment followers count: %w", err)
+		}
+
+		err = enqueue(ctx, tx, outboxFollowed, followedJob{
+			FollowerID:       actor.id,
+			FolloweeID:       followeeID,
+			FolloweeUsername: username,
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = enqueue(ctx, tx, outboxDelivery, deliveryJob{
+		SenderID: followeeID,
+		Inbox:    actor.inbox,
+		Activity: accept,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to receive follow: %w", err)
+	}
+
+	s.wakeOutbox()
+
+	return nil
+}
+
+// receiveUnfollow undoes a follow of the remote actor.
+func (s *Service) receiveUnfollow(ctx context.Context, actor remoteActor, followeeActorID string) error {
+	username, ok := s.localUsername(followeeActorID)
+	if !ok {
+		return nil
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	var followeeID string
+	query := `
+		DELETE FROM follows
+		WHERE follower_id = $1 AND followee_id = (SELECT id FROM users WHERE username = $2 AND actor_id IS NULL)
+		RETURNING followee_id`
+	err = tx.QueryRowContext(ctx, query, actor.id, username).Scan(&followeeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return nil
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not delete remote follow: %w", err)
+	}
+
+	query = "UPDATE users SET followees_count = followees_count - 1 WHERE id = $1"
+	if _, err = tx.ExecContext(ctx, query, actor.id); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not decrement remote followees count: %w", err)
+	}
+
+	query = "UPDATE users SET followers_count = followers_count - 1 WHERE id = $1"
+	if _, err = tx.ExecContext(ctx, query, followeeID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not decrement followers count: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to receive unfollow: %w", err)
+	}
+
+	return nil
+}
+
+// receiveLike makes the remote actor like a local post they can read.
+func (s *Service) receiveLike(ctx context.Context, actor remoteActor, noteID string) error {
+	postID, ok := s.localPostID(noteID)
+	if !ok {
+		return nil
+	}
+
+	visible, err := s.postVisible(context.WithValue(ctx, KeyAuthUserID, actor.id), postID)
+	if err != nil {
+		return err
+	}
+
+	if !visible {
+		return ErrPostNotFound
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	query := `
+		INSERT INTO post_likes (user_id, post_id) VALUES ($1, $2)
+		ON CONFLICT (user_id, post_id) DO NOTHING`
+	res, err := tx.ExecContext(ctx, query, actor.id, postID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not insert remote post like: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	if n != 0 {
+		query = "UPDATE posts SET likes_count = likes_count + 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, postID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not increment post likes count: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to receive post like: %w", err)
+	}
+
+	if n != 0 {
+		go s.notifyPostLike(actor.id, postID)
+	}
+
+	return nil
+}
+
+// receiveUnlike undoes a like of the remote actor.
+func (s *Service) receiveUnlike(ctx context.Context, actor remoteActor, noteID string) error {
+	postID, ok := s.localPostID(noteID)
+	if !ok {
+		return nil
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	query := "DELETE FROM post_likes WHERE user_id = $1 AND post_id = $2"
+	res, err := tx.ExecContext(ctx, query, actor.id, postID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not delete remote post like: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return nil
+	}
+
+	var authorID string
+	query = "UPDATE posts SET likes_count = likes_count - 1 WHERE id = $1 RETURNING user_id"
+	if err = tx.QueryRowContext(ctx, query, postID).Scan(&authorID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not decrement post likes count: %w", err)
+	}
+
+	if err = removeNotificationActor(ctx, tx, authorID, actor.id, "post_like", postID, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to receive post unlike: %w", err)
+	}
+
+	return nil
+}
+
+// receiveNote comments a local post with the remote note replying to it.
+// Notes replying to anything else are ignored, and so are the ones already received.
+func (s *Service) receiveNote(ctx context.Context, actor remoteActor, note incomingActivity) error {
+	postID, ok := s.localPostID(objectID(note.InReplyTo))
+	if !ok {
+		return nil
+	}
+
+	if note.ID == "" || objectID(note.AttributedTo) != actor.actorID {
+		return ErrInvalidActivity
+	}
+
+	_, err := s.createComment(context.WithValue(ctx, KeyAuthUserID, actor.id), postID, nil, htmlToText(note.Content), &note.ID)
+	if errors.Is(err, errCommentExists) {
+		return nil
+	}
+
+	return err
+}
+
+// htmlToText turns the HTML content of a remote note into plain text,
+// keeping its line breaks and shortened to fit a comment.
+func htmlToText(s string) string {
+	s = reHTMLBreak.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(reHTMLTag.ReplaceAllString(s, ""))
+	s = smartTrim(s)
+	if utf8.RuneCountInString(s) > commentContentMaxLength {
+		s = string([]rune(s)[:commentContentMaxLength-1]) + "…"
+	}
+	return s
+}
+
+// deliverPost enqueues the delivery of a just published post
+// to the inboxes of the remote followers of its author, once per instance when they share one.
+// Posts for the mentioned users only are not delivered.
+func (s *Service) deliverPost(ctx context.Context, p Post) error {
+	if p.Visibility == VisibilityMentioned {
+		return nil
+	}
+
+	query := `
+		SELECT DISTINCT COALESCE(users.shared_inbox, users.inbox) FROM follows
+		INNER JOIN users ON follows.follower_id = users.id
+		WHERE follows.followee_id = $1 AND users.actor_id IS NOT NULL`
+	rows, err := s.Db.QueryContext(ctx, query, p.UserID)
+	if err != nil {
+		return fmt.Errorf("could not query select remote followers inboxes: %w", err)
+	}
+
+	defer rows.Close()
+
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if err = rows.Scan(&inbox); err != nil {
+			return fmt.Errorf("could not scan remote follower inbox: %w", err)
+		}
+
+		inboxes = append(inboxes, inbox)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate remote follower inbox rows: %w", err)
+	}
+
+	if len(inboxes) == 0 {
+		return nil
+	}
+
+	create := s.createNoteActivity(s.note(p))
+	create.Context = activityStreamsContext
+	b, err := json.Marshal(create)
+	if err != nil {
+		return fmt.Errorf("could not json marshal create activity: %w", err)
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	for _, inbox := range inboxes {
+		err = enqueue(ctx, tx, outboxDelivery, deliveryJob{
+			SenderID: p.UserID,
+			Inbox:    inbox,
+			Activity: b,
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to deliver post: %w", err)
+	}
+
+	s.wakeOutbox()
+
+	return nil
+}
+
+// deliver posts the activity to the remote inbox, signed by its sender.
+// It runs as an outbox job. Inboxes that are gone or refuse the activity are given up on.
+func (s *Service) deliver(ctx context.Context, in deliveryJob) error {
+	sender, err := s.localActor(ctx, in.SenderID)
+	if errors.Is(err, ErrUserNotFound) {
+		// Deleted in the meantime.
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.Inbox, bytes.NewReader(in.Activity))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", ActivityContentType)
+	req.Header.Set("User-Agent", federationUserAgent)
+	if err = signRequest(req, in.Activity, s.actorKeyID(sender.username), sender.key); err != nil {
+		return err
+	}
+
+	resp, err := s.federationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, ActivityMaxBodySize))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		log.Println("error", fmt.Errorf("delivery to %s given up with status code %d", in.Inbox, resp.StatusCode))
+		return nil
+	}
+
+	return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
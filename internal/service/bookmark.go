@@ -0,0 +1,375 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const bookmarkCollectionNameMaxLength = 64
+
+var (
+	// ErrInvalidCollectionID denotes an invalid bookmark collection ID; that is not uuid.
+	ErrInvalidCollectionID = InvalidArgumentError("invalid collection ID")
+	// ErrInvalidCollectionName denotes an empty or too long bookmark collection name.
+	ErrInvalidCollectionName = InvalidArgumentError("invalid collection name")
+	// ErrCollectionNotFound denotes a not found bookmark collection.
+	ErrCollectionNotFound = NotFoundError("collection not found")
+	// ErrCollectionNameTaken denotes a bookmark collection name already in use by the user.
+	ErrCollectionNameTaken = AlreadyExistsError("collection name taken")
+	// ErrBookmarkNotFound denotes a not found bookmark.
+	ErrBookmarkNotFound = NotFoundError("bookmark not found")
+)
+
+// Bookmark of a post saved for later by the authenticated user.
+type Bookmark struct {
+	PostID       string    `json:"-"`
+	CollectionID *string   `json:"collectionID"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Post         *Post     `json:"post"`
+}
+
+type Bookmarks []Bookmark
+
+func (bb Bookmarks) EndCursor() *string {
+	if len(bb) == 0 {
+		return nil
+	}
+
+	last := bb[len(bb)-1]
+	return ptrString(encodeCursor(last.PostID, last.CreatedAt))
+}
+
+// BookmarkCollection groups bookmarks under a name.
+type BookmarkCollection struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Bookmarks of the authenticated user, optionally within a collection,
+// most recently bookmarked first and with backward pagination.
+func (s *Service) Bookmarks(ctx context.Context, collectionID *string, last uint64, before *string) (Bookmarks, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	if collectionID != nil && !reUUID.MatchString(*collectionID) {
+		return nil, ErrInvalidCollectionID
+	}
+
+	var beforePostID string
+	var beforeCreatedAt time.Time
+
+	if before != nil {
+		var err error
+		beforePostID, beforeCreatedAt, err = decodeCursor(*before)
+		if err != nil || !reUUID.MatchString(beforePostID) {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	last = normalizePageSize(last)
+	query, args, err := buildQuery(`
+		SELECT bookmarks.collection_id
+		, bookmarks.created_at
+		, posts.id
+		, posts.content
+		, posts.spoiler_of
+		, posts.nsfw
+		, posts.likes_count
+		, posts.comments_count
+		, posts.reposts_count
+		, posts.quotes_count
+		, posts.reply_policy
+		, posts.visibility
+		, posts.created_at
+		, posts.user_id = @uid AS post_mine
+		, likes.user_id IS NOT NULL AS liked
+		, subscriptions.user_id IS NOT NULL AS subscribed
+		, reposts.user_id IS NOT NULL AS reposted
+		, users.username
+		, users.avatar
+		, quotes.id
+		, quotes.content
+		, quotes.spoiler_of
+		, quotes.nsfw
+		, quotes.created_at
+		, quote_users.username
+		, quote_users.avatar
+		FROM bookmarks
+		INNER JOIN posts ON bookmarks.post_id = posts.id
+		INNER JOIN users ON posts.user_id = users.id
+		LEFT JOIN posts AS quotes ON posts.quote_id = quotes.id AND `+visiblePostSQL("quotes")+`
+		LEFT JOIN users AS quote_users ON quotes.user_id = quote_users.id
+		LEFT JOIN post_likes AS likes
+			ON likes.user_id = @uid AND likes.post_id = posts.id
+		LEFT JOIN post_subscriptions AS subscriptions
+			ON subscriptions.user_id = @uid AND subscriptions.post_id = posts.id
+		LEFT JOIN reposts
+			ON reposts.user_id = @uid AND reposts.post_id = posts.id
+		WHERE bookmarks.user_id = @uid
+		AND `+visiblePostSQL("posts")+`
+		{{ if .collectionID }}
+			AND bookmarks.collection_id = @collectionID
+		{{ end }}
+		{{ if and .beforePostID .beforeCreatedAt }}
+			AND bookmarks.created_at <= @beforeCreatedAt
+			AND (
+				bookmarks.post_id != @beforePostID
+					OR bookmarks.created_at < @beforeCreatedAt
+			)
+		{{ end }}
+		ORDER BY bookmarks.created_at DESC, bookmarks.post_id ASC
+		LIMIT @last`, map[string]interface{}{
+		"auth":            true,
+		"uid":             uid,
+		"collectionID":    collectionID,
+		"last":            last,
+		"beforePostID":    beforePostID,
+		"beforeCreatedAt": beforeCreatedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build bookmarks sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select bookmarks: %w", err)
+	}
+
+	defer rows.Close()
+
+	var bb Bookmarks
+	for rows.Next() {
+		var b Bookmark
+		var p Post
+		var u User
+		var avatar sql.NullString
+		var q quoteScanner
+		dest := []interface{}{
+			&b.CollectionID,
+			&b.CreatedAt,
+			&p.ID,
+			&p.Content,
+			&p.SpoilerOf,
+			&p.NSFW,
+			&p.LikesCount,
+			&p.CommentsCount,
+			&p.RepostsCount,
+			&p.QuotesCount,
+			&p.ReplyPolicy,
+			&p.Visibility,
+			&p.CreatedAt,
+			&p.Mine,
+			&p.Liked,
+			&p.Subscribed,
+			&p.Reposted,
+			&u.Username,
+			&avatar,
+		}
+		if err = rows.Scan(append(dest, q.dest()...)...); err != nil {
+			return nil, fmt.Errorf("could not scan bookmark: %w", err)
+		}
+
+		u.AvatarURL = s.avatarURL(avatar)
+		p.User = &u
+		p.Quote = s.quotedPost(q)
+		p.Bookmarked = true
+		b.PostID = p.ID
+		b.Post = &p
+		bb = append(bb, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate bookmark rows: %w", err)
+	}
+
+	pp := make([]*Post, len(bb))
+	for i, b := range bb {
+		pp[i] = b.Post
+	}
+	if err = s.hydratePosts(ctx, pp...); err != nil {
+		return nil, err
+	}
+
+	return bb, nil
+}
+
+// Bookmark a post for the authenticated user, optionally within a collection.
+// Bookmarking an already bookmarked post moves it to the given collection.
+func (s *Service) Bookmark(ctx context.Context, postID string, collectionID *string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(postID) {
+		return ErrInvalidPostID
+	}
+
+	if collectionID != nil {
+		if !reUUID.MatchString(*collectionID) {
+			return ErrInvalidCollectionID
+		}
+
+		var exists bool
+		query := "SELECT EXISTS (SELECT 1 FROM bookmark_collections WHERE id = $1 AND user_id = $2)"
+		if err := s.Db.QueryRowContext(ctx, query, *collectionID, uid).Scan(&exists); err != nil {
+			return fmt.Errorf("could not query select bookmark collection existence: %w", err)
+		}
+
+		if !exists {
+			return ErrCollectionNotFound
+		}
+	}
+
+	query, args, err := buildQuery(`
+		INSERT INTO bookmarks (user_id, post_id, collection_id)
+		SELECT @uid, posts.id, @collectionID FROM posts
+		WHERE posts.id = @postID AND posts.publish_at IS NULL
+		AND `+visiblePostSQL("posts")+`
+		ON CONFLICT (user_id, post_id) DO UPDATE SET collection_id = EXCLUDED.collection_id`, map[string]interface{}{
+		"auth":         true,
+		"uid":          uid,
+		"postID":       postID,
+		"collectionID": collectionID,
+	})
+	if err != nil {
+		return fmt.Errorf("could not build bookmark sql query: %w", err)
+	}
+
+	res, err := s.Db.ExecContext(ctx, query, args...)
+	if isForeignKeyViolation(err) {
+		return ErrUserGone
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not insert bookmark: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPostNotFound
+	}
+
+	return nil
+}
+
+// DeleteBookmark of the authenticated user.
+func (s *Service) DeleteBookmark(ctx context.Context, postID string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(postID) {
+		return ErrInvalidPostID
+	}
+
+	res, err := s.Db.ExecContext(ctx, "DELETE FROM bookmarks WHERE user_id = $1 AND post_id = $2", uid, postID)
+	if err != nil {
+		return fmt.Errorf("could not delete bookmark: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrBookmarkNotFound
+	}
+
+	return nil
+}
+
+// BookmarkCollections of the authenticated user in alphabetical order.
+func (s *Service) BookmarkCollections(ctx context.Context) ([]BookmarkCollection, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	query := `
+		SELECT id, name, created_at FROM bookmark_collections
+		WHERE user_id = $1
+		ORDER BY name ASC`
+	rows, err := s.Db.QueryContext(ctx, query, uid)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select bookmark collections: %w", err)
+	}
+
+	defer rows.Close()
+
+	var cc []BookmarkCollection
+	for rows.Next() {
+		var c BookmarkCollection
+		if err = rows.Scan(&c.ID, &c.Name, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan bookmark collection: %w", err)
+		}
+
+		cc = append(cc, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate bookmark collection rows: %w", err)
+	}
+
+	return cc, nil
+}
+
+// CreateBookmarkCollection for the authenticated user.
+func (s *Service) CreateBookmarkCollection(ctx context.Context, name string) (BookmarkCollection, error) {
+	var c BookmarkCollection
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return c, ErrUnauthenticated
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > bookmarkCollectionNameMaxLength {
+		return c, ErrInvalidCollectionName
+	}
+
+	query := "INSERT INTO bookmark_collections (user_id, name) VALUES ($1, $2) RETURNING id, created_at"
+	err := s.Db.QueryRowContext(ctx, query, uid, name).Scan(&c.ID, &c.CreatedAt)
+	if isUniqueViolation(err) {
+		return c, ErrCollectionNameTaken
+	}
+
+	if isForeignKeyViolation(err) {
+		return c, ErrUserGone
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not insert bookmark collection: %w", err)
+	}
+
+	c.Name = name
+
+	return c, nil
+}
+
+// DeleteBookmarkCollection of the authenticated user.
+// Its bookmarks are kept, outside any collection.
+func (s *Service) DeleteBookmarkCollection(ctx context.Context, collectionID string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(collectionID) {
+		return ErrInvalidCollectionID
+	}
+
+	query := "DELETE FROM bookmark_collections WHERE id = $1 AND user_id = $2"
+	res, err := s.Db.ExecContext(ctx, query, collectionID, uid)
+	if err != nil {
+		return fmt.Errorf("could not delete bookmark collection: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrCollectionNotFound
+	}
+
+	return nil
+}
@@ -8,17 +8,19 @@ type BrokerRepository struct {
 	timelineItemBroker *TimelineItemBroker
 	commentBroker      *CommentBroker
 	notificationBroker *NotificationBroker
+	listBroker         *ListBroker
 }
 
 func newBrokerRepository() *BrokerRepository {
 	brokerRepository := &BrokerRepository{
 		&TimelineItemBroker{
-			Notifier:       make(chan TimelineItem, 1),
+			Notifier:       make(chan TimelineEvent, 1),
 			NewClients:     make(chan *timelineItemClient),
 			ClosingClients: make(chan *timelineItemClient),
+			ConnectedUsers: make(chan chan []string),
 			Clients:        make(map[string]Set[*timelineItemClient]),
 		}, &CommentBroker{
-			Notifier:       make(chan Comment, 1),
+			Notifier:       make(chan PostEvent, 1),
 			NewClients:     make(chan *commentClient),
 			ClosingClients: make(chan *commentClient),
 			Clients:        make(map[string]Set[*commentClient]),
@@ -26,17 +28,33 @@ func newBrokerRepository() *BrokerRepository {
 			Notifier:       make(chan Notification, 1),
 			NewClients:     make(chan *notificationClient),
 			ClosingClients: make(chan *notificationClient),
+			UserConnected:  make(chan userConnectedRequest),
 			Clients:        make(map[string]Set[*notificationClient]),
+		}, &ListBroker{
+			Notifier:         make(chan ListEvent, 1),
+			NewClients:       make(chan *listClient),
+			ClosingClients:   make(chan *listClient),
+			ConnectedViewers: make(chan chan map[string][]string),
+			Clients:          make(map[string]Set[*listClient]),
 		},
 	}
 	go brokerRepository.timelineItemBroker.listen()
 	go brokerRepository.commentBroker.listen()
 	go brokerRepository.notificationBroker.listen()
+	go brokerRepository.listBroker.listen()
 	return brokerRepository
 }
 
+// TimelineEvent is delivered to the clients streaming the timeline of a user.
+// Type is empty for new timeline items, so they keep arriving as plain messages.
+type TimelineEvent struct {
+	Type   string
+	UserID string
+	Data   interface{}
+}
+
 type timelineItemClient struct {
-	timelines chan TimelineItem
+	timelines chan TimelineEvent
 	userID    string
 	ctx       context.Context
 }
@@ -45,11 +63,13 @@ type timelineItemClient struct {
 // listens for incoming events on its Notifier channel
 // and broadcast event data to all registered connections
 type TimelineItemBroker struct {
-	Notifier chan TimelineItem
+	Notifier chan TimelineEvent
 	// New client connections
 	NewClients chan *timelineItemClient
 	// Closed client connections
 	ClosingClients chan *timelineItemClient
+	// Requests for the IDs of the users with open connections
+	ConnectedUsers chan chan []string
 	// Client connections registry
 	Clients map[string]Set[*timelineItemClient]
 }
@@ -69,11 +89,20 @@ func (broker *TimelineItemBroker) listen() {
 			close(s.timelines)
 			broker.Clients[s.userID].Remove(s)
 
-		case timelineItem := <-broker.Notifier:
-			// We got a new timelineItem from the outside! Send timelineItem to correct connected clients
-			for client := range broker.Clients[timelineItem.UserID] {
+		case reply := <-broker.ConnectedUsers:
+			var uids []string
+			for userID, clients := range broker.Clients {
+				if len(clients) != 0 {
+					uids = append(uids, userID)
+				}
+			}
+			reply <- uids
+
+		case event := <-broker.Notifier:
+			// We got a new event from the outside! Send event to correct connected clients
+			for client := range broker.Clients[event.UserID] {
 				select {
-				case client.timelines <- timelineItem:
+				case client.timelines <- event:
 				// no ops
 				case <-client.ctx.Done():
 					// no ops
@@ -83,15 +112,32 @@ func (broker *TimelineItemBroker) listen() {
 	}
 }
 
+// connectedUsers returns the IDs of the users streaming their timeline.
+func (broker *TimelineItemBroker) connectedUsers() []string {
+	reply := make(chan []string, 1)
+	broker.ConnectedUsers <- reply
+	return <-reply
+}
+
+// PostEvent is delivered to the clients streaming a post.
+// Type is empty for new comments, so they keep arriving as plain messages.
+type PostEvent struct {
+	Type   string
+	PostID string
+	// ActorID is the user that caused the event, who doesn't get it back.
+	ActorID string
+	Data    interface{}
+}
+
 type commentClient struct {
-	comments chan Comment
-	postID   string
-	userID   *string
-	ctx      context.Context
+	events chan PostEvent
+	postID string
+	userID *string
+	ctx    context.Context
 }
 
 type CommentBroker struct {
-	Notifier       chan Comment
+	Notifier       chan PostEvent
 	NewClients     chan *commentClient
 	ClosingClients chan *commentClient
 	Clients        map[string]Set[*commentClient]
@@ -107,14 +153,14 @@ func (broker *CommentBroker) listen() {
 			broker.Clients[s.postID].Add(s)
 
 		case s := <-broker.ClosingClients:
-			close(s.comments)
+			close(s.events)
 			broker.Clients[s.postID].Remove(s)
 
-		case comment := <-broker.Notifier:
-			for client := range broker.Clients[comment.PostID] {
-				if !(client.userID != nil && *(client.userID) == comment.UserID) {
+		case event := <-broker.Notifier:
+			for client := range broker.Clients[event.PostID] {
+				if !(client.userID != nil && *(client.userID) == event.ActorID) {
 					select {
-					case client.comments <- comment:
+					case client.events <- event:
 						// no ops
 					case <-client.ctx.Done():
 						// no ops
@@ -131,11 +177,18 @@ type notificationClient struct {
 	ctx           context.Context
 }
 
+type userConnectedRequest struct {
+	userID string
+	reply  chan bool
+}
+
 type NotificationBroker struct {
 	Notifier       chan Notification
 	NewClients     chan *notificationClient
 	ClosingClients chan *notificationClient
-	Clients        map[string]Set[*notificationClient]
+	// Requests for whether a user has open connections
+	UserConnected chan userConnectedRequest
+	Clients       map[string]Set[*notificationClient]
 }
 
 func (broker *NotificationBroker) listen() {
@@ -151,6 +204,9 @@ func (broker *NotificationBroker) listen() {
 			close(s.notifications)
 			broker.Clients[s.userID].Remove(s)
 
+		case req := <-broker.UserConnected:
+			req.reply <- len(broker.Clients[req.userID]) != 0
+
 		case notification := <-broker.Notifier:
 			for client := range broker.Clients[notification.UserID] {
 				select {
@@ -163,3 +219,86 @@ func (broker *NotificationBroker) listen() {
 		}
 	}
 }
+
+// connected tells whether the user is streaming their notifications.
+func (broker *NotificationBroker) connected(userID string) bool {
+	reply := make(chan bool, 1)
+	broker.UserConnected <- userConnectedRequest{userID: userID, reply: reply}
+	return <-reply
+}
+
+type listClient struct {
+	posts  chan Post
+	listID string
+	userID *string
+	ctx    context.Context
+}
+
+type ListBroker struct {
+	Notifier       chan ListEvent
+	NewClients     chan *listClient
+	ClosingClients chan *listClient
+	// Requests for the IDs of the users streaming each list
+	ConnectedViewers chan chan map[string][]string
+	Clients          map[string]Set[*listClient]
+}
+
+func (broker *ListBroker) listen() {
+	for {
+		select {
+		case s := <-broker.NewClients:
+			if broker.Clients[s.listID] == nil {
+				broker.Clients[s.listID] = make(Set[*listClient])
+			}
+			broker.Clients[s.listID].Add(s)
+
+		case s := <-broker.ClosingClients:
+			close(s.posts)
+			broker.Clients[s.listID].Remove(s)
+			if len(broker.Clients[s.listID]) == 0 {
+				delete(broker.Clients, s.listID)
+			}
+
+		case reply := <-broker.ConnectedViewers:
+			viewers := make(map[string][]string, len(broker.Clients))
+			for listID, clients := range broker.Clients {
+				uids := []string{}
+				for client := range clients {
+					if client.userID != nil {
+						uids = append(uids, *client.userID)
+					}
+				}
+				viewers[listID] = uids
+			}
+			reply <- viewers
+
+		case event := <-broker.Notifier:
+			for client := range broker.Clients[event.ListID] {
+				if event.Audience != nil {
+					if client.userID == nil {
+						continue
+					}
+
+					if _, ok := event.Audience[*client.userID]; !ok {
+						continue
+					}
+				}
+
+				select {
+				case client.posts <- event.Post:
+					// no ops
+				case <-client.ctx.Done():
+					// no ops
+				}
+			}
+		}
+	}
+}
+
+// connectedViewers returns the IDs of the lists being streamed,
+// each with the IDs of the authenticated users streaming it.
+func (broker *ListBroker) connectedViewers() map[string][]string {
+	reply := make(chan map[string][]string, 1)
+	broker.ConnectedViewers <- reply
+	return <-reply
+}
@@ -3,57 +3,254 @@ package service
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 const commentContentMaxLength = 2048
 
+// CommentsSort order.
+type CommentsSort string
+
+const (
+	// CommentsSortNewest lists most recent comments first. It is the default.
+	CommentsSortNewest CommentsSort = "newest"
+	// CommentsSortOldest lists comments in the order they were made.
+	CommentsSortOldest CommentsSort = "oldest"
+	// CommentsSortTop lists most liked comments first, then most recent.
+	CommentsSortTop CommentsSort = "top"
+)
+
 var (
 	// ErrInvalidCommentID denotes an invalid comment ID; that is not uuid.
 	ErrInvalidCommentID = InvalidArgumentError("invalid comment ID")
 	// ErrCommentNotFound denotes a not found comment.
 	ErrCommentNotFound = NotFoundError("comment not found")
+	// ErrUpdateCommentDenied denotes an attempt to edit someone else comment.
+	ErrUpdateCommentDenied = PermissionDeniedError("update comment denied")
+	// ErrDeleteCommentDenied denotes an attempt to delete a comment
+	// by someone who is neither its author nor the post author.
+	ErrDeleteCommentDenied = PermissionDeniedError("delete comment denied")
+	// ErrCommentDenied denotes an attempt to comment on a post
+	// whose reply policy excludes the user.
+	ErrCommentDenied = PermissionDeniedError("comment denied")
+	// ErrPinCommentDenied denotes an attempt to pin a comment on someone else post.
+	ErrPinCommentDenied = PermissionDeniedError("pin comment denied")
+	// ErrCannotPinReply denotes an attempt to pin a reply instead of a top-level comment.
+	ErrCannotPinReply = InvalidArgumentError("cannot pin reply")
+	// ErrInvalidCommentsSort denotes an unknown comments sort order.
+	ErrInvalidCommentsSort = InvalidArgumentError("invalid comments sort")
 )
 
 // Comment model.
 type Comment struct {
-	ID         string    `json:"id"`
-	UserID     string    `json:"-"`
-	PostID     string    `json:"-"`
-	Content    string    `json:"content"`
-	LikesCount int       `json:"likesCount"`
-	CreatedAt  time.Time `json:"createdAt"`
-	User       *User     `json:"user,omitempty"`
-	Mine       bool      `json:"mine"`
-	Liked      bool      `json:"liked"`
+	ID           string       `json:"id"`
+	UserID       string       `json:"-"`
+	PostID       string       `json:"-"`
+	ParentID     *string      `json:"parentID"`
+	Content      string       `json:"content"`
+	ContentHTML  string       `json:"contentHTML"`
+	Entities     []Entity     `json:"entities"`
+	LikesCount   int          `json:"likesCount"`
+	RepliesCount int          `json:"repliesCount"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	EditedAt     *time.Time   `json:"editedAt"`
+	User         *User        `json:"user,omitempty"`
+	Mine         bool         `json:"mine"`
+	Liked        bool         `json:"liked"`
+	Pinned       bool         `json:"pinned"`
+	LinkPreview  *LinkPreview `json:"linkPreview,omitempty"`
 }
 
 type Comments []Comment
 
+func (cc Comments) StartCursor() *string {
+	return cc.SortedStartCursor(CommentsSortNewest)
+}
+
 func (cc Comments) EndCursor() *string {
+	return cc.SortedEndCursor(CommentsSortNewest)
+}
+
+// SortedStartCursor is the start cursor of comments listed with the given sort order.
+// Like the end cursor, it skips the pinned comment unless alone.
+func (cc Comments) SortedStartCursor(sort CommentsSort) *string {
+	if len(cc) == 0 {
+		return nil
+	}
+
+	first := cc[0]
+	for _, c := range cc {
+		if !c.Pinned {
+			first = c
+			break
+		}
+	}
+	return ptrString(encodeCommentsCursor(sort, first))
+}
+
+// SortedEndCursor is the end cursor of comments listed with the given sort order.
+// The pinned comment is out of the sort order, so it is no cursor unless alone.
+func (cc Comments) SortedEndCursor(sort CommentsSort) *string {
 	if len(cc) == 0 {
 		return nil
 	}
 
 	last := cc[len(cc)-1]
-	return ptrString(encodeCursor(last.ID, last.CreatedAt))
+	for i := len(cc) - 1; i >= 0; i-- {
+		if !cc[i].Pinned {
+			last = cc[i]
+			break
+		}
+	}
+	return ptrString(encodeCommentsCursor(sort, last))
+}
+
+func (sort CommentsSort) normalize() (CommentsSort, error) {
+	switch sort {
+	case "":
+		return CommentsSortNewest, nil
+	case CommentsSortNewest, CommentsSortOldest, CommentsSortTop:
+		return sort, nil
+	}
+	return "", ErrInvalidCommentsSort
+}
+
+// commentsCursor is the position of a comment within a sort order.
+type commentsCursor struct {
+	id         string
+	createdAt  time.Time
+	likesCount int
+}
+
+// encodeCommentsCursor keeps the newest order compatible with the generic cursor.
+// Other orders prefix their name so a cursor can't be used with a different sort.
+func encodeCommentsCursor(sort CommentsSort, c Comment) string {
+	if sort == "" || sort == CommentsSortNewest {
+		return encodeCursor(c.ID, c.CreatedAt)
+	}
+
+	s := fmt.Sprintf("%s,%s,%d,%s", sort, c.ID, c.LikesCount, c.CreatedAt.Format(time.RFC3339Nano))
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCommentsCursor(sort CommentsSort, s string) (commentsCursor, error) {
+	var cur commentsCursor
+	if sort == CommentsSortNewest {
+		var err error
+		cur.id, cur.createdAt, err = decodeCursor(s)
+		return cur, err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return cur, fmt.Errorf("could not base64 decode cursor: %w", err)
+	}
+
+	parts := strings.Split(string(b), ",")
+	if len(parts) != 4 {
+		return cur, errors.New("expected cursor to have four items split by comma")
+	}
+
+	if CommentsSort(parts[0]) != sort {
+		return cur, errors.New("cursor sort mismatch")
+	}
+
+	cur.id = parts[1]
+	if cur.likesCount, err = strconv.Atoi(parts[2]); err != nil {
+		return cur, fmt.Errorf("could not parse cursor likes count: %w", err)
+	}
+
+	if cur.createdAt, err = time.Parse(time.RFC3339Nano, parts[3]); err != nil {
+		return cur, fmt.Errorf("could not parse cursor timestamp: %w", err)
+	}
+
+	return cur, nil
+}
+
+func (cc Comments) ptrs() []*Comment {
+	out := make([]*Comment, len(cc))
+	for i := range cc {
+		out[i] = &cc[i]
+	}
+	return out
 }
 
 // CreateComment on a post.
 func (s *Service) CreateComment(ctx context.Context, postID string, content string) (Comment, error) {
+	if !reUUID.MatchString(postID) {
+		return Comment{}, ErrInvalidPostID
+	}
+
+	return s.createComment(ctx, postID, nil, content, nil)
+}
+
+// CreateReply to a comment.
+func (s *Service) CreateReply(ctx context.Context, commentID string, content string) (Comment, error) {
+	if !reUUID.MatchString(commentID) {
+		return Comment{}, ErrInvalidCommentID
+	}
+
+	if _, ok := ctx.Value(KeyAuthUserID).(string); !ok {
+		return Comment{}, ErrUnauthenticated
+	}
+
+	postID, err := s.visibleCommentPostID(ctx, commentID)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	return s.createComment(ctx, postID, &commentID, content, nil)
+}
+
+// visibleCommentPostID is the post of the comment,
+// as long as the user in the context can see it.
+// Comments of posts they can't see are not found.
+func (s *Service) visibleCommentPostID(ctx context.Context, commentID string) (string, error) {
+	uid, auth := ctx.Value(KeyAuthUserID).(string)
+	query, args, err := buildQuery(`
+		SELECT comments.post_id FROM comments
+		INNER JOIN posts ON comments.post_id = posts.id
+		WHERE comments.id = @commentID
+			AND posts.publish_at IS NULL
+			AND `+visiblePostSQL("posts"), map[string]interface{}{
+		"auth":      auth,
+		"uid":       uid,
+		"commentID": commentID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not build comment post sql query: %w", err)
+	}
+
+	var postID string
+	err = s.Db.QueryRowContext(ctx, query, args...).Scan(&postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrCommentNotFound
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not query select comment post: %w", err)
+	}
+
+	return postID, nil
+}
+
+// createComment on a post, or in reply to a comment if parentID is given.
+// apID is the ActivityPub ID of a comment received from a remote instance.
+func (s *Service) createComment(ctx context.Context, postID string, parentID *string, content string, apID *string) (Comment, error) {
 	var c Comment
 	uid, ok := ctx.Value(KeyAuthUserID).(string)
 	if !ok {
 		return c, ErrUnauthenticated
 	}
 
-	if !reUUID.MatchString(postID) {
-		return c, ErrInvalidPostID
-	}
-
 	content = smartTrim(content)
 	if content == "" || utf8.RuneCountInString(content) > commentContentMaxLength {
 		return c, ErrInvalidContent
@@ -64,12 +261,25 @@ func (s *Service) CreateComment(ctx context.Context, postID string, content stri
 		return c, fmt.Errorf("could not begin tx: %w", err)
 	}
 
+	if err = checkReplyPolicy(ctx, tx, uid, postID); err != nil {
+		tx.Rollback()
+		return c, err
+	}
+
 	query := `
-			INSERT INTO comments (user_id, post_id, content) VALUES ($1, $2, $3)
+			INSERT INTO comments (user_id, post_id, parent_id, content, ap_id) VALUES ($1, $2, $3, $4, $5)
 			RETURNING id, created_at`
-	err = tx.QueryRowContext(ctx, query, uid, postID, content).Scan(&c.ID, &c.CreatedAt)
+	err = tx.QueryRowContext(ctx, query, uid, postID, parentID, content, apID).Scan(&c.ID, &c.CreatedAt)
+	if isUniqueViolation(err) {
+		tx.Rollback()
+		return c, errCommentExists
+	}
+
 	if isForeignKeyViolation(err) {
 		tx.Rollback()
+		if parentID != nil {
+			return c, ErrCommentNotFound
+		}
 		return c, ErrPostNotFound
 	}
 
@@ -80,6 +290,7 @@ func (s *Service) CreateComment(ctx context.Context, postID string, content stri
 
 	c.UserID = uid
 	c.PostID = postID
+	c.ParentID = parentID
 	c.Content = content
 	c.Mine = true
 
@@ -87,6 +298,7 @@ func (s *Service) CreateComment(ctx context.Context, postID string, content stri
 			INSERT INTO post_subscriptions (user_id, post_id) VALUES ($1, $2)
 			ON CONFLICT (user_id, post_id) DO NOTHING`
 	if _, err = tx.ExecContext(ctx, query, uid, postID); err != nil {
+		tx.Rollback()
 		return c, fmt.Errorf("could not insert post subcription after commenting: %w", err)
 	}
 
@@ -96,53 +308,195 @@ func (s *Service) CreateComment(ctx context.Context, postID string, content stri
 		return c, fmt.Errorf("could not update and increment post comments count: %w", err)
 	}
 
+	if parentID != nil {
+		query = "UPDATE comments SET replies_count = replies_count + 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, *parentID); err != nil {
+			tx.Rollback()
+			return c, fmt.Errorf("could not update and increment comment replies count: %w", err)
+		}
+	}
+
+	if err = enqueue(ctx, tx, outboxCommentCreated, commentCreatedJob{CommentID: c.ID}); err != nil {
+		tx.Rollback()
+		return c, err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return c, fmt.Errorf("could not commit to create comment: %w", err)
 	}
 
-	go s.commentCreated(c)
+	s.wakeOutbox()
+
+	if err = s.attachCommentRichText(ctx, &c); err != nil {
+		log.Println("error", err)
+	}
 
 	return c, nil
 }
 
-func (s *Service) commentCreated(c Comment) {
-	u, err := s.userByID(context.Background(), c.UserID)
+// checkReplyPolicy denies commenting on a post whose reply policy excludes the given user.
+// The post author can always comment.
+func checkReplyPolicy(ctx context.Context, tx *sql.Tx, uid, postID string) error {
+	var authorID, content string
+	var policy ReplyPolicy
+	query, args, err := buildQuery(`
+		SELECT user_id, content, reply_policy FROM posts
+		WHERE id = @postID AND publish_at IS NULL
+		AND `+visiblePostSQL("posts"), map[string]interface{}{
+		"auth":   true,
+		"uid":    uid,
+		"postID": postID,
+	})
+	if err != nil {
+		return fmt.Errorf("could not build reply policy sql query: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&authorID, &content, &policy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPostNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select post reply policy: %w", err)
+	}
+
+	if authorID == uid || policy == ReplyPolicyEveryone {
+		return nil
+	}
+
+	var allowed bool
+	switch policy {
+	case ReplyPolicyFollowers:
+		query = "SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2)"
+		err = tx.QueryRowContext(ctx, query, uid, authorID).Scan(&allowed)
+	case ReplyPolicyFollowing:
+		query = "SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2)"
+		err = tx.QueryRowContext(ctx, query, authorID, uid).Scan(&allowed)
+	case ReplyPolicyMentioned:
+		if mentions := collectMentions(content); len(mentions) != 0 {
+			query = "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND username = ANY($2))"
+			err = tx.QueryRowContext(ctx, query, uid, pq.Array(mentions)).Scan(&allowed)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not query check reply policy: %w", err)
+	}
+
+	if !allowed {
+		return ErrCommentDenied
+	}
+
+	return nil
+}
+
+// commentCreated notifies the post subscribers, the mentioned users and the replied comment author,
+// and delivers the comment to the post stream. It runs as an outbox job.
+func (s *Service) commentCreated(ctx context.Context, commentID string) error {
+	var c Comment
+	query := "SELECT user_id, post_id, parent_id, content, created_at FROM comments WHERE id = $1"
+	err := s.Db.QueryRowContext(ctx, query, commentID).Scan(&c.UserID, &c.PostID, &c.ParentID, &c.Content, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted in the meantime.
+		return nil
+	}
+
 	if err != nil {
-		log.Println("error", fmt.Errorf("could not fetch comment user: %w", err))
-		return
+		return fmt.Errorf("could not query select created comment: %w", err)
 	}
 
+	u, err := s.userByID(ctx, c.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not fetch comment user: %w", err)
+	}
+
+	c.ID = commentID
 	c.User = &u
-	c.Mine = false
 
-	go s.notifyComment(c)
-	go s.notifyCommentMention(c)
+	if err = s.attachCommentRichText(ctx, &c); err != nil {
+		return err
+	}
+
+	if err = s.notifyComment(ctx, c); err != nil {
+		return err
+	}
+
+	if err = s.notifyCommentMention(ctx, c); err != nil {
+		return err
+	}
+
+	if c.ParentID != nil {
+		if err = s.notifyCommentReply(ctx, c); err != nil {
+			return err
+		}
+	}
+
 	go s.broadcastComment(c)
+	go s.unfurlLink(c.Content)
+
+	return nil
 }
 
-func (s *Service) Comments(ctx context.Context, postID string, last uint64, before *string) (Comments, error) {
+// Comments of a post, excluding replies, in the given sort order with pagination in both directions.
+func (s *Service) Comments(ctx context.Context, postID string, sort CommentsSort, page PageArgs) (Comments, PageInfo, error) {
 	if !reUUID.MatchString(postID) {
-		return nil, ErrInvalidPostID
+		return nil, PageInfo{}, ErrInvalidPostID
 	}
 
-	var beforeCommentID string
-	var beforeCreatedAt time.Time
+	return s.comments(ctx, postID, "", sort, page)
+}
+
+// Replies to a comment in the given sort order with pagination in both directions.
+func (s *Service) Replies(ctx context.Context, commentID string, sort CommentsSort, page PageArgs) (Comments, PageInfo, error) {
+	if !reUUID.MatchString(commentID) {
+		return nil, PageInfo{}, ErrInvalidCommentID
+	}
 
-	if before != nil {
-		var err error
-		beforeCommentID, beforeCreatedAt, err = decodeCursor(*before)
-		if err != nil || !reUUID.MatchString(beforeCommentID) {
-			return nil, ErrInvalidCursor
+	return s.comments(ctx, "", commentID, sort, page)
+}
+
+// comments of either a post or a parent comment.
+func (s *Service) comments(ctx context.Context, postID, parentID string, sort CommentsSort, page PageArgs) (Comments, PageInfo, error) {
+	var info PageInfo
+	sort, err := sort.normalize()
+	if err != nil {
+		return nil, info, err
+	}
+
+	if err = page.validate(); err != nil {
+		return nil, info, err
+	}
+
+	var cur commentsCursor
+	if cursor := page.cursor(); cursor != nil {
+		cur, err = decodeCommentsCursor(sort, *cursor)
+		if err != nil || !reUUID.MatchString(cur.id) {
+			return nil, info, ErrInvalidCursor
 		}
 	}
 
+	// The pinned comment tops the first page of a post comments
+	// without taking the place of another one.
+	pinnable := postID != "" && page.cursor() == nil
+	limit := page.limit()
+	if pinnable {
+		limit++
+	}
+
 	uid, auth := ctx.Value(KeyAuthUserID).(string)
-	last = normalizePageSize(last)
 	query, args, err := buildQuery(`
 		SELECT comments.id
+		, comments.post_id
+		, comments.parent_id
 		, comments.content
 		, comments.likes_count
+		, comments.replies_count
 		, comments.created_at
+		, comments.edited_at
+		, comments.id IS NOT DISTINCT FROM posts.pinned_comment_id AS pinned
 		, users.username
 		, users.avatar
 		{{if .auth}}
@@ -151,34 +505,80 @@ func (s *Service) Comments(ctx context.Context, postID string, last uint64, befo
 		{{end}}
 		FROM comments
 		INNER JOIN users ON comments.user_id = users.id
+		INNER JOIN posts ON comments.post_id = posts.id
 		{{if .auth}}
 		LEFT JOIN comment_likes AS likes
 			ON likes.comment_id = comments.id AND likes.user_id = @uid
 		{{end}}
-		WHERE comments.post_id = @postID
-		{{ if and .beforeCommentID .beforeCreatedAt }}
-			AND comments.created_at <= @beforeCreatedAt
+		{{ if .parentID }}
+		WHERE comments.parent_id = @parentID
+		{{ else }}
+		WHERE comments.post_id = @postID AND comments.parent_id IS NULL
+		{{ end }}
+		AND `+visiblePostSQL("posts")+`
+		{{ if .hasCursor }}
+			{{ if .postID }}
+			AND comments.id IS DISTINCT FROM posts.pinned_comment_id
+			{{ end }}
+			{{ if and (eq .sort "top") .forward }}
+			AND (comments.likes_count, comments.created_at, comments.id) > (@cursorLikesCount, @cursorCreatedAt, @cursorCommentID)
+			{{ else if eq .sort "top" }}
+			AND (comments.likes_count, comments.created_at, comments.id) < (@cursorLikesCount, @cursorCreatedAt, @cursorCommentID)
+			{{ else if and (eq .sort "oldest") .forward }}
+			AND (comments.created_at, comments.id) < (@cursorCreatedAt, @cursorCommentID)
+			{{ else if eq .sort "oldest" }}
+			AND (comments.created_at, comments.id) > (@cursorCreatedAt, @cursorCommentID)
+			{{ else if .forward }}
+			AND comments.created_at >= @cursorCreatedAt
 			AND (
-				comments.id != @beforeCommentID
-					OR comments.created_at < @beforeCreatedAt
+				comments.id != @cursorCommentID
+					OR comments.created_at > @cursorCreatedAt
 			)
+			{{ else }}
+			AND comments.created_at <= @cursorCreatedAt
+			AND (
+				comments.id != @cursorCommentID
+					OR comments.created_at < @cursorCreatedAt
+			)
+			{{ end }}
+		{{ end }}
+		ORDER BY
+		{{ if and .postID (not .hasCursor) }}
+		comments.id IS NOT DISTINCT FROM posts.pinned_comment_id DESC,
 		{{ end }}
-		ORDER BY comments.created_at DESC, comments.id ASC
-		LIMIT @last`, map[string]interface{}{
-		"auth":            auth,
-		"uid":             uid,
-		"postID":          postID,
-		"last":            last,
-		"beforeCommentID": beforeCommentID,
-		"beforeCreatedAt": beforeCreatedAt,
+		{{ if and (eq .sort "top") .forward }}
+		comments.likes_count ASC, comments.created_at ASC, comments.id ASC
+		{{ else if eq .sort "top" }}
+		comments.likes_count DESC, comments.created_at DESC, comments.id DESC
+		{{ else if and (eq .sort "oldest") .forward }}
+		comments.created_at DESC, comments.id DESC
+		{{ else if eq .sort "oldest" }}
+		comments.created_at ASC, comments.id ASC
+		{{ else if .forward }}
+		comments.created_at ASC, comments.id DESC
+		{{ else }}
+		comments.created_at DESC, comments.id ASC
+		{{ end }}
+		LIMIT @limit`, map[string]interface{}{
+		"auth":             auth,
+		"uid":              uid,
+		"postID":           postID,
+		"parentID":         parentID,
+		"sort":             string(sort),
+		"forward":          page.forward(),
+		"limit":            limit,
+		"hasCursor":        page.cursor() != nil,
+		"cursorCommentID":  cur.id,
+		"cursorCreatedAt":  cur.createdAt,
+		"cursorLikesCount": cur.likesCount,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not build comments sql query: %w", err)
+		return nil, info, fmt.Errorf("could not build comments sql query: %w", err)
 	}
 
 	rows, err := s.Db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("could not query select comments: %w", err)
+		return nil, info, fmt.Errorf("could not query select comments: %w", err)
 	}
 
 	defer rows.Close()
@@ -188,12 +588,24 @@ func (s *Service) Comments(ctx context.Context, postID string, last uint64, befo
 		var c Comment
 		var u User
 		var avatar sql.NullString
-		dest := []interface{}{&c.ID, &c.Content, &c.LikesCount, &c.CreatedAt, &u.Username, &avatar}
+		dest := []interface{}{
+			&c.ID,
+			&c.PostID,
+			&c.ParentID,
+			&c.Content,
+			&c.LikesCount,
+			&c.RepliesCount,
+			&c.CreatedAt,
+			&c.EditedAt,
+			&c.Pinned,
+			&u.Username,
+			&avatar,
+		}
 		if auth {
 			dest = append(dest, &c.Mine, &c.Liked)
 		}
 		if err = rows.Scan(dest...); err != nil {
-			return nil, fmt.Errorf("could not scan comment: %w", err)
+			return nil, info, fmt.Errorf("could not scan comment: %w", err)
 		}
 
 		u.AvatarURL = s.avatarURL(avatar)
@@ -202,10 +614,260 @@ func (s *Service) Comments(ctx context.Context, postID string, last uint64, befo
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("could not iterate comment rows: %w", err)
+		return nil, info, fmt.Errorf("could not iterate comment rows: %w", err)
+	}
+
+	var pinned *Comment
+	if pinnable && len(cc) != 0 && cc[0].Pinned {
+		pinned = &cc[0]
+		cc = cc[1:]
+	} else if pinnable && uint64(len(cc)) > page.limit() {
+		cc = cc[:page.limit()]
+	}
+
+	cc, info = paginate(cc, page)
+	if pinned != nil {
+		cc = append(Comments{*pinned}, cc...)
+	}
+
+	if err = s.attachCommentRichText(ctx, cc.ptrs()...); err != nil {
+		return nil, info, err
+	}
+
+	if err = s.attachCommentLinkPreviews(ctx, cc.ptrs()...); err != nil {
+		return nil, info, err
+	}
+
+	return cc, info, nil
+}
+
+type ToggleCommentPinOutput struct {
+	Pinned bool `json:"pinned"`
+}
+
+// ToggleCommentPin pins a top-level comment on top of its post comments,
+// replacing any previously pinned one, or unpins it.
+// Only the post author can pin comments.
+func (s *Service) ToggleCommentPin(ctx context.Context, commentID string) (ToggleCommentPinOutput, error) {
+	var out ToggleCommentPinOutput
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return out, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(commentID) {
+		return out, ErrInvalidCommentID
+	}
+
+	var postID, postAuthorID string
+	var parentID, pinnedCommentID *string
+	query := `
+		SELECT comments.post_id, comments.parent_id, posts.user_id, posts.pinned_comment_id
+		FROM comments
+		INNER JOIN posts ON comments.post_id = posts.id
+		WHERE comments.id = $1`
+	err := s.Db.QueryRowContext(ctx, query, commentID).Scan(&postID, &parentID, &postAuthorID, &pinnedCommentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return out, ErrCommentNotFound
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not query select comment to pin: %w", err)
+	}
+
+	if postAuthorID != uid {
+		return out, ErrPinCommentDenied
+	}
+
+	if parentID != nil {
+		return out, ErrCannotPinReply
+	}
+
+	out.Pinned = pinnedCommentID == nil || *pinnedCommentID != commentID
+	var pin *string
+	if out.Pinned {
+		pin = &commentID
+	}
+
+	query = "UPDATE posts SET pinned_comment_id = $1 WHERE id = $2"
+	if _, err = s.Db.ExecContext(ctx, query, pin, postID); err != nil {
+		return out, fmt.Errorf("could not update post pinned comment: %w", err)
 	}
 
-	return cc, nil
+	return out, nil
+}
+
+// UpdateComment content. Only the comment author can edit it.
+func (s *Service) UpdateComment(ctx context.Context, commentID string, content string) (Comment, error) {
+	var c Comment
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return c, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(commentID) {
+		return c, ErrInvalidCommentID
+	}
+
+	content = smartTrim(content)
+	if content == "" || utf8.RuneCountInString(content) > commentContentMaxLength {
+		return c, ErrInvalidContent
+	}
+
+	query := "SELECT user_id FROM comments WHERE id = $1"
+	err := s.Db.QueryRowContext(ctx, query, commentID).Scan(&c.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCommentNotFound
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not query select comment author: %w", err)
+	}
+
+	if c.UserID != uid {
+		return c, ErrUpdateCommentDenied
+	}
+
+	query = `
+		UPDATE comments SET content = $1, edited_at = now()
+		WHERE id = $2 AND user_id = $3
+		RETURNING post_id, parent_id, likes_count, replies_count, created_at, edited_at`
+	err = s.Db.QueryRowContext(ctx, query, content, commentID, uid).Scan(
+		&c.PostID,
+		&c.ParentID,
+		&c.LikesCount,
+		&c.RepliesCount,
+		&c.CreatedAt,
+		&c.EditedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCommentNotFound
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("could not update comment: %w", err)
+	}
+
+	c.ID = commentID
+	c.Content = content
+	c.Mine = true
+
+	u, err := s.userByID(ctx, uid)
+	if err != nil {
+		return c, err
+	}
+
+	c.User = &u
+
+	if err = s.attachCommentRichText(ctx, &c); err != nil {
+		log.Println("error", err)
+	}
+
+	go s.commentUpdated(c)
+
+	return c, nil
+}
+
+func (s *Service) commentUpdated(c Comment) {
+	c.Mine = false
+	c.Liked = false
+
+	go s.broadcastPostEvent(PostEvent{Type: "comment_updated", PostID: c.PostID, ActorID: c.UserID, Data: c})
+	go s.unfurlLink(c.Content)
+}
+
+// DeletedComment is delivered to the post stream when a comment is deleted.
+type DeletedComment struct {
+	ID       string  `json:"id"`
+	ParentID *string `json:"parentID"`
+}
+
+// DeleteComment along with its replies.
+// Both the comment author and the post author can delete it.
+func (s *Service) DeleteComment(ctx context.Context, commentID string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(commentID) {
+		return ErrInvalidCommentID
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	var authorID, postID, postAuthorID string
+	var parentID *string
+	query := `
+		SELECT comments.user_id, comments.post_id, comments.parent_id, posts.user_id
+		FROM comments
+		INNER JOIN posts ON comments.post_id = posts.id
+		WHERE comments.id = $1
+		FOR UPDATE OF comments`
+	err = tx.QueryRowContext(ctx, query, commentID).Scan(&authorID, &postID, &parentID, &postAuthorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrCommentNotFound
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not query select comment to delete: %w", err)
+	}
+
+	if uid != authorID && uid != postAuthorID {
+		tx.Rollback()
+		return ErrDeleteCommentDenied
+	}
+
+	// Replies go away with the comment, so they are discounted from the post too.
+	// The thread is counted as it is deleted, so the count matches the rows actually gone.
+	var deletedCount int
+	query = `
+		WITH RECURSIVE thread AS (
+			SELECT id FROM comments WHERE id = $1
+			UNION ALL
+			SELECT comments.id FROM comments
+			INNER JOIN thread ON comments.parent_id = thread.id
+		), deleted AS (
+			DELETE FROM comments WHERE id IN (SELECT id FROM thread)
+			RETURNING id
+		)
+		SELECT count(*) FROM deleted`
+	if err = tx.QueryRowContext(ctx, query, commentID).Scan(&deletedCount); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not delete comment thread: %w", err)
+	}
+
+	query = "UPDATE posts SET comments_count = comments_count - $1 WHERE id = $2"
+	if _, err = tx.ExecContext(ctx, query, deletedCount, postID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not update and decrement post comments count: %w", err)
+	}
+
+	if parentID != nil {
+		query = "UPDATE comments SET replies_count = replies_count - 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, *parentID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not update and decrement comment replies count: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to delete comment: %w", err)
+	}
+
+	go s.broadcastPostEvent(PostEvent{
+		Type:    "comment_deleted",
+		PostID:  postID,
+		ActorID: uid,
+		Data:    DeletedComment{ID: commentID, ParentID: parentID},
+	})
+
+	return nil
 }
 
 func (s *Service) ToggleCommentLike(ctx context.Context, commentID string) (ToggleLikeOutput, error) {
@@ -219,6 +881,10 @@ func (s *Service) ToggleCommentLike(ctx context.Context, commentID string) (Togg
 		return out, ErrInvalidCommentID
 	}
 
+	if _, err := s.visibleCommentPostID(ctx, commentID); err != nil {
+		return out, err
+	}
+
 	query := `
 			SELECT EXISTS (
 				SELECT 1 FROM comment_likes WHERE user_id = $1 AND comment_id = $2
@@ -237,17 +903,23 @@ func (s *Service) ToggleCommentLike(ctx context.Context, commentID string) (Togg
 			return out, fmt.Errorf("could not delete comment like: %w", err)
 		}
 
-		query = "UPDATE comments SET likes_count = likes_count - 1 WHERE id = $1 RETURNING likes_count"
-		if err = tx.QueryRowContext(ctx, query, commentID).Scan(&out.LikesCount); err != nil {
+		var postID, authorID string
+		query = "UPDATE comments SET likes_count = likes_count - 1 WHERE id = $1 RETURNING likes_count, post_id, user_id"
+		if err = tx.QueryRowContext(ctx, query, commentID).Scan(&out.LikesCount, &postID, &authorID); err != nil {
 			tx.Rollback()
 			return out, fmt.Errorf("could not decrement comment likes count: %w", err)
 		}
+
+		if err = removeNotificationActor(ctx, tx, authorID, uid, "comment_like", postID, &commentID); err != nil {
+			tx.Rollback()
+			return out, err
+		}
 	} else {
 		query = "INSERT INTO comment_likes (user_id, comment_id) VALUES ($1, $2)"
 		if _, err = tx.ExecContext(ctx, query, uid, commentID); err != nil {
 			tx.Rollback()
 			if isForeignKeyViolation(err) {
-				return out, ErrPostNotFound
+				return out, ErrCommentNotFound
 			}
 			return out, fmt.Errorf("could not insert comment like: %w", err)
 		}
@@ -264,13 +936,31 @@ func (s *Service) ToggleCommentLike(ctx context.Context, commentID string) (Togg
 	}
 
 	out.Liked = !out.Liked
+
+	if out.Liked {
+		go s.notifyCommentLike(uid, commentID)
+	}
+
 	return out, nil
 }
 
-// CommentStream to receive comments in realtime.
-func (s *Service) CommentStream(ctx context.Context, postID string) <-chan Comment {
-	cc := make(chan Comment)
-	c := &commentClient{comments: cc, postID: postID, ctx: ctx}
+// CommentStream to receive comments and other post events in realtime.
+func (s *Service) CommentStream(ctx context.Context, postID string) (<-chan PostEvent, error) {
+	if !reUUID.MatchString(postID) {
+		return nil, ErrInvalidPostID
+	}
+
+	visible, err := s.postVisible(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !visible {
+		return nil, ErrPostNotFound
+	}
+
+	ee := make(chan PostEvent)
+	c := &commentClient{events: ee, postID: postID, ctx: ctx}
 	if uid, ok := ctx.Value(KeyAuthUserID).(string); ok {
 		c.userID = &uid
 	}
@@ -282,9 +972,13 @@ func (s *Service) CommentStream(ctx context.Context, postID string) <-chan Comme
 		<-ctx.Done()
 		s.BrokerRepository.commentBroker.ClosingClients <- c
 	}()
-	return cc
+	return ee, nil
 }
 
 func (s *Service) broadcastComment(c Comment) {
-	s.BrokerRepository.commentBroker.Notifier <- c
+	s.broadcastPostEvent(PostEvent{PostID: c.PostID, ActorID: c.UserID, Data: c})
+}
+
+func (s *Service) broadcastPostEvent(e PostEvent) {
+	s.BrokerRepository.commentBroker.Notifier <- e
 }
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	htmltemplate "html/template"
+	"log"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// digestBatch is how many users get their digest at once.
+	digestBatch = 50
+	// digestMaxItems is the number of notifications listed in a digest.
+	digestMaxItems = 20
+)
+
+var (
+	// ErrInvalidDigestFrequency denotes an unknown email digest frequency.
+	ErrInvalidDigestFrequency = InvalidArgumentError("invalid digest frequency")
+	// ErrInvalidUnsubscribeToken denotes a malformed or forged unsubscribe token.
+	ErrInvalidUnsubscribeToken = InvalidArgumentError("invalid unsubscribe token")
+
+	//go:embed templates/digest.txt.tmpl templates/digest.html.tmpl
+	digestTemplatesFS embed.FS
+
+	digestTextTemplate = template.Must(template.ParseFS(digestTemplatesFS, "templates/digest.txt.tmpl"))
+	digestHTMLTemplate = htmltemplate.Must(htmltemplate.ParseFS(digestTemplatesFS, "templates/digest.html.tmpl"))
+)
+
+// DigestFrequency of the emails summing up the unread notifications of a user.
+type DigestFrequency string
+
+const (
+	DigestOff    DigestFrequency = "off"
+	DigestDaily  DigestFrequency = "daily"
+	DigestWeekly DigestFrequency = "weekly"
+)
+
+func (f DigestFrequency) valid() bool {
+	switch f {
+	case DigestOff, DigestDaily, DigestWeekly:
+		return true
+	}
+	return false
+}
+
+type digestItem struct {
+	Text string
+	URL  string
+}
+
+type digestData struct {
+	Username         string
+	Frequency        DigestFrequency
+	Total            int
+	Items            []digestItem
+	More             int
+	NotificationsURL string
+	UnsubscribeURL   string
+}
+
+// DigestFrequency of the authenticated user.
+func (s *Service) DigestFrequency(ctx context.Context) (DigestFrequency, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+
+	var f DigestFrequency
+	query := "SELECT digest_frequency FROM users WHERE id = $1"
+	err := s.Db.QueryRowContext(ctx, query, uid).Scan(&f)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserGone
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not query select digest frequency: %w", err)
+	}
+
+	return f, nil
+}
+
+// UpdateDigestFrequency of the authenticated user.
+func (s *Service) UpdateDigestFrequency(ctx context.Context, f DigestFrequency) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !f.valid() {
+		return ErrInvalidDigestFrequency
+	}
+
+	query := "UPDATE users SET digest_frequency = $2 WHERE id = $1"
+	res, err := s.Db.ExecContext(ctx, query, uid, f)
+	if err != nil {
+		return fmt.Errorf("could not update digest frequency: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserGone
+	}
+
+	return nil
+}
+
+// UnsubscribeDigest turns off the email digests of the user the unsubscribe token was made for.
+// It needs no authentication, so it works from the link in the email.
+func (s *Service) UnsubscribeDigest(ctx context.Context, token string) error {
+	uid, ok := s.verifyUnsubscribeToken(token)
+	if !ok {
+		return ErrInvalidUnsubscribeToken
+	}
+
+	query := "UPDATE users SET digest_frequency = 'off' WHERE id = $1"
+	if _, err := s.Db.ExecContext(ctx, query, uid); err != nil {
+		return fmt.Errorf("could not update and unsubscribe digest: %w", err)
+	}
+
+	return nil
+}
+
+// unsubscribeToken for the given user ID, signed so it can't be made for someone else.
+func (s *Service) unsubscribeToken(uid string) string {
+	return uid + "." + base64.RawURLEncoding.EncodeToString(s.unsubscribeMAC(uid))
+}
+
+func (s *Service) verifyUnsubscribeToken(token string) (string, bool) {
+	uid, sig, ok := strings.Cut(token, ".")
+	if !ok || !reUUID.MatchString(uid) {
+		return "", false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", false
+	}
+
+	return uid, hmac.Equal(b, s.unsubscribeMAC(uid))
+}
+
+// unsubscribeMAC of the user ID, keyed with a key derived from the JWT secret,
+// so the secret signing auth tokens signs nothing else.
+func (s *Service) unsubscribeMAC(uid string) []byte {
+	derive := hmac.New(sha256.New, []byte(s.JWTSecret))
+	derive.Write([]byte("digest-unsubscribe"))
+
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write([]byte(uid))
+	return mac.Sum(nil)
+}
+
+// SendDigests emails their digest to the users it is due, forever.
+// Nothing is sent without a Mailer.
+func (s *Service) SendDigests(interval time.Duration) {
+	if s.Mailer == nil {
+		log.Println("no mailer; email digests are off")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			n, err := s.sendDueDigests(context.Background())
+			if err != nil {
+				log.Println("error", fmt.Errorf("could not send digests: %w", err))
+				break
+			}
+
+			if n < digestBatch {
+				break
+			}
+		}
+	}
+}
+
+// sendDueDigests claims a batch of users whose digest is due and sends it to them.
+// A digest that fails to send gets its previous send time back,
+// so it is due again on the next run.
+func (s *Service) sendDueDigests(ctx context.Context) (int, error) {
+	query := `
+		WITH due AS (
+			SELECT id, digest_sent_at FROM users
+			WHERE email IS NOT NULL
+				AND (
+					(digest_frequency = 'daily' AND (digest_sent_at IS NULL OR digest_sent_at <= now() - INTERVAL '1 day'))
+					OR (digest_frequency = 'weekly' AND (digest_sent_at IS NULL OR digest_sent_at <= now() - INTERVAL '7 days'))
+				)
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE users SET digest_sent_at = now()
+		FROM due
+		WHERE users.id = due.id
+		RETURNING users.id, users.username, users.email, users.digest_frequency, due.digest_sent_at`
+	rows, err := s.Db.QueryContext(ctx, query, digestBatch)
+	if err != nil {
+		return 0, fmt.Errorf("could not update and claim due digests: %w", err)
+	}
+
+	defer rows.Close()
+
+	var dd []digestData
+	var uids, emails []string
+	var prevSentAts []sql.NullTime
+	for rows.Next() {
+		var d digestData
+		var uid, email string
+		var prevSentAt sql.NullTime
+		if err = rows.Scan(&uid, &d.Username, &email, &d.Frequency, &prevSentAt); err != nil {
+			return 0, fmt.Errorf("could not scan due digest: %w", err)
+		}
+
+		dd = append(dd, d)
+		uids = append(uids, uid)
+		emails = append(emails, email)
+		prevSentAts = append(prevSentAts, prevSentAt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not iterate due digest rows: %w", err)
+	}
+
+	for i, d := range dd {
+		if err = s.sendDigest(ctx, uids[i], emails[i], d); err != nil {
+			log.Println("error", fmt.Errorf("could not send digest to user %s: %w", uids[i], err))
+
+			query = "UPDATE users SET digest_sent_at = $2 WHERE id = $1"
+			if _, err = s.Db.ExecContext(ctx, query, uids[i], prevSentAts[i]); err != nil {
+				log.Println("error", fmt.Errorf("could not reset digest sent at of user %s: %w", uids[i], err))
+			}
+		}
+	}
+
+	return len(dd), nil
+}
+
+// sendDigest of the unread notifications not part of a previous digest.
+// Notifications updated with new actors since they were part of one are again.
+func (s *Service) sendDigest(ctx context.Context, uid, email string, d digestData) error {
+	query := `
+		SELECT count(*) OVER ()
+		, id
+		, actors
+		, type
+		, post_id
+		, comment_id
+		, issued_at
+		FROM notifications
+		WHERE user_id = $1
+			AND read_at IS NULL
+			AND (digested_at IS NULL OR issued_at > digested_at)
+		ORDER BY issued_at DESC
+		LIMIT $2`
+	rows, err := s.Db.QueryContext(ctx, query, uid, digestMaxItems)
+	if err != nil {
+		return fmt.Errorf("could not query select digest notifications: %w", err)
+	}
+
+	defer rows.Close()
+
+	var nids []string
+	for rows.Next() {
+		var n Notification
+		if err = rows.Scan(&d.Total, &n.ID, pq.Array(&n.Actors), &n.Type, &n.PostID, &n.CommentID, &n.IssuedAt); err != nil {
+			return fmt.Errorf("could not scan digest notification: %w", err)
+		}
+
+		nids = append(nids, n.ID)
+		d.Items = append(d.Items, s.digestItem(n))
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate digest notification rows: %w", err)
+	}
+
+	if len(nids) == 0 {
+		return nil
+	}
+
+	d.More = d.Total - len(d.Items)
+	d.NotificationsURL = s.Origin + "/notifications"
+	d.UnsubscribeURL = s.Origin + "/email_digest/unsubscribe?token=" + url.QueryEscape(s.unsubscribeToken(uid))
+
+	var text, html bytes.Buffer
+	if err = digestTextTemplate.Execute(&text, d); err != nil {
+		return fmt.Errorf("could not render digest text: %w", err)
+	}
+
+	if err = digestHTMLTemplate.Execute(&html, d); err != nil {
+		return fmt.Errorf("could not render digest html: %w", err)
+	}
+
+	subject := fmt.Sprintf("You have %d unread notifications", d.Total)
+	if d.Total == 1 {
+		subject = "You have 1 unread notification"
+	}
+
+	err = s.Mailer.Send(ctx, Mail{
+		To:             email,
+		Subject:        subject,
+		Text:           text.String(),
+		HTML:           html.String(),
+		UnsubscribeURL: d.UnsubscribeURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	query = "UPDATE notifications SET digested_at = now() WHERE id = ANY($1)"
+	if _, err = s.Db.ExecContext(ctx, query, pq.Array(nids)); err != nil {
+		return fmt.Errorf("could not update digested notifications: %w", err)
+	}
+
+	return nil
+}
+
+// digestItem describes the notification in a sentence, with a link to what it is about.
+func (s *Service) digestItem(n Notification) digestItem {
+	actors := "Someone"
+	switch len(n.Actors) {
+	case 0:
+	case 1:
+		actors = n.Actors[0]
+	case 2:
+		actors = n.Actors[0] + " and " + n.Actors[1]
+	default:
+		actors = fmt.Sprintf("%s and %d others", n.Actors[0], len(n.Actors)-1)
+	}
+
+	item := digestItem{URL: s.Origin + "/notifications"}
+	if n.PostID != nil {
+		item.URL = s.Origin + "/posts/" + *n.PostID
+	}
+
+	switch n.Type {
+	case "follow":
+		item.Text = actors + " followed you"
+		if len(n.Actors) != 0 {
+			item.URL = s.Origin + "/users/" + url.PathEscape(n.Actors[0])
+		}
+	case "comment":
+		item.Text = actors + " commented on a post you are subscribed to"
+	case "comment_reply":
+		item.Text = actors + " replied to your comment"
+	case "post_mention":
+		item.Text = actors + " mentioned you in a post"
+	case "comment_mention":
+		item.Text = actors + " mentioned you in a comment"
+	case "post_like":
+		item.Text = actors + " liked your post"
+	case "comment_like":
+		item.Text = actors + " liked your comment"
+	case "repost":
+		item.Text = actors + " reposted your post"
+	case "poll_closed":
+		item.Text = "A poll you voted in has closed"
+	default:
+		item.Text = actors + " interacted with you"
+	}
+	return item
+}
@@ -0,0 +1,401 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+// draftMaxAge is how long a draft is kept since its last update.
+const draftMaxAge = time.Hour * 24 * 30
+
+var (
+	// ErrInvalidDraftID denotes an invalid draft ID; that is not uuid.
+	ErrInvalidDraftID = InvalidArgumentError("invalid draft ID")
+	// ErrDraftNotFound denotes a not found draft.
+	ErrDraftNotFound = NotFoundError("draft not found")
+)
+
+// Draft model.
+type Draft struct {
+	ID          string      `json:"id"`
+	UserID      string      `json:"-"`
+	Content     string      `json:"content"`
+	SpoilerOf   *string     `json:"spoilerOf"`
+	NSFW        bool        `json:"nsfw"`
+	QuoteID     *string     `json:"quoteID"`
+	ReplyPolicy ReplyPolicy `json:"replyPolicy"`
+	Visibility  Visibility  `json:"visibility"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// DraftInput request.
+// Unlike posts, drafts may be saved with an empty content.
+// They carry no poll, as its closing time would go by while drafting.
+type DraftInput struct {
+	Content   string
+	SpoilerOf *string
+	NSFW      bool
+	QuoteID   *string
+	// ReplyPolicy defaults to everyone.
+	ReplyPolicy ReplyPolicy
+	// Visibility defaults to public.
+	Visibility Visibility
+}
+
+type Drafts []Draft
+
+func (dd Drafts) EndCursor() *string {
+	if len(dd) == 0 {
+		return nil
+	}
+
+	last := dd[len(dd)-1]
+	return ptrString(encodeCursor(last.ID, last.UpdatedAt))
+}
+
+func (in *DraftInput) normalize() error {
+	in.Content = smartTrim(in.Content)
+	if utf8.RuneCountInString(in.Content) > postContentMaxLength {
+		return ErrInvalidContent
+	}
+
+	if in.SpoilerOf != nil {
+		*in.SpoilerOf = smartTrim(*in.SpoilerOf)
+		if *in.SpoilerOf == "" {
+			in.SpoilerOf = nil
+		} else if utf8.RuneCountInString(*in.SpoilerOf) > postSpoilerMaxLength {
+			return ErrInvalidSpoiler
+		}
+	}
+
+	if in.QuoteID != nil && !reUUID.MatchString(*in.QuoteID) {
+		return ErrInvalidQuoteID
+	}
+
+	if in.ReplyPolicy == "" {
+		in.ReplyPolicy = ReplyPolicyEveryone
+	} else if !in.ReplyPolicy.valid() {
+		return ErrInvalidReplyPolicy
+	}
+
+	if in.Visibility == "" {
+		in.Visibility = VisibilityPublic
+	} else if !in.Visibility.valid() {
+		return ErrInvalidVisibility
+	}
+
+	return nil
+}
+
+// Drafts of the authenticated user, most recently updated first and with backward pagination.
+func (s *Service) Drafts(ctx context.Context, last uint64, before *string) (Drafts, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	var beforeDraftID string
+	var beforeUpdatedAt time.Time
+
+	if before != nil {
+		var err error
+		beforeDraftID, beforeUpdatedAt, err = decodeCursor(*before)
+		if err != nil || !reUUID.MatchString(beforeDraftID) {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	last = normalizePageSize(last)
+	query, args, err := buildQuery(`
+		SELECT id
+		, content
+		, spoiler_of
+		, nsfw
+		, quote_id
+		, reply_policy
+		, visibility
+		, created_at
+		, updated_at
+		FROM drafts
+		WHERE user_id = @uid
+		{{ if and .beforeDraftID .beforeUpdatedAt }}
+			AND updated_at <= @beforeUpdatedAt
+			AND (
+				id != @beforeDraftID
+					OR updated_at < @beforeUpdatedAt
+			)
+		{{ end }}
+		ORDER BY updated_at DESC, id ASC
+		LIMIT @last`, map[string]interface{}{
+		"uid":             uid,
+		"last":            last,
+		"beforeDraftID":   beforeDraftID,
+		"beforeUpdatedAt": beforeUpdatedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build drafts sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select drafts: %w", err)
+	}
+
+	defer rows.Close()
+
+	var dd Drafts
+	for rows.Next() {
+		var d Draft
+		if err = rows.Scan(
+			&d.ID,
+			&d.Content,
+			&d.SpoilerOf,
+			&d.NSFW,
+			&d.QuoteID,
+			&d.ReplyPolicy,
+			&d.Visibility,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan draft: %w", err)
+		}
+
+		d.UserID = uid
+		dd = append(dd, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate draft rows: %w", err)
+	}
+
+	return dd, nil
+}
+
+// Draft of the authenticated user with the given ID.
+func (s *Service) Draft(ctx context.Context, draftID string) (Draft, error) {
+	var d Draft
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return d, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(draftID) {
+		return d, ErrInvalidDraftID
+	}
+
+	query := `
+		SELECT content, spoiler_of, nsfw, quote_id, reply_policy, visibility, created_at, updated_at
+		FROM drafts WHERE id = $1 AND user_id = $2`
+	err := s.Db.QueryRowContext(ctx, query, draftID, uid).Scan(
+		&d.Content,
+		&d.SpoilerOf,
+		&d.NSFW,
+		&d.QuoteID,
+		&d.ReplyPolicy,
+		&d.Visibility,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, ErrDraftNotFound
+	}
+
+	if err != nil {
+		return d, fmt.Errorf("could not query select draft: %w", err)
+	}
+
+	d.ID = draftID
+	d.UserID = uid
+
+	return d, nil
+}
+
+// CreateDraft saves a new draft for the authenticated user.
+func (s *Service) CreateDraft(ctx context.Context, in DraftInput) (Draft, error) {
+	var d Draft
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return d, ErrUnauthenticated
+	}
+
+	if err := in.normalize(); err != nil {
+		return d, err
+	}
+
+	query := `
+		INSERT INTO drafts (user_id, content, spoiler_of, nsfw, quote_id, reply_policy, visibility)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at`
+	err := s.Db.QueryRowContext(ctx, query, uid, in.Content, in.SpoilerOf, in.NSFW, in.QuoteID, in.ReplyPolicy, in.Visibility).
+		Scan(&d.ID, &d.CreatedAt, &d.UpdatedAt)
+	if isForeignKeyViolation(err) {
+		if in.QuoteID != nil {
+			return d, ErrPostNotFound
+		}
+		return d, ErrUserGone
+	}
+
+	if err != nil {
+		return d, fmt.Errorf("could not insert draft: %w", err)
+	}
+
+	d.UserID = uid
+	d.Content = in.Content
+	d.SpoilerOf = in.SpoilerOf
+	d.NSFW = in.NSFW
+	d.QuoteID = in.QuoteID
+	d.ReplyPolicy = in.ReplyPolicy
+	d.Visibility = in.Visibility
+
+	return d, nil
+}
+
+// UpdateDraft replaces the contents of a draft of the authenticated user.
+func (s *Service) UpdateDraft(ctx context.Context, draftID string, in DraftInput) (Draft, error) {
+	var d Draft
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return d, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(draftID) {
+		return d, ErrInvalidDraftID
+	}
+
+	if err := in.normalize(); err != nil {
+		return d, err
+	}
+
+	query := `
+		UPDATE drafts SET
+			content = $1,
+			spoiler_of = $2,
+			nsfw = $3,
+			quote_id = $4,
+			reply_policy = $5,
+			visibility = $6,
+			updated_at = now()
+		WHERE id = $7 AND user_id = $8
+		RETURNING created_at, updated_at`
+	err := s.Db.QueryRowContext(ctx, query, in.Content, in.SpoilerOf, in.NSFW, in.QuoteID, in.ReplyPolicy, in.Visibility, draftID, uid).
+		Scan(&d.CreatedAt, &d.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, ErrDraftNotFound
+	}
+
+	if isForeignKeyViolation(err) {
+		return d, ErrPostNotFound
+	}
+
+	if err != nil {
+		return d, fmt.Errorf("could not update draft: %w", err)
+	}
+
+	d.ID = draftID
+	d.UserID = uid
+	d.Content = in.Content
+	d.SpoilerOf = in.SpoilerOf
+	d.NSFW = in.NSFW
+	d.QuoteID = in.QuoteID
+	d.ReplyPolicy = in.ReplyPolicy
+	d.Visibility = in.Visibility
+
+	return d, nil
+}
+
+// DeleteDraft of the authenticated user.
+func (s *Service) DeleteDraft(ctx context.Context, draftID string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(draftID) {
+		return ErrInvalidDraftID
+	}
+
+	res, err := s.Db.ExecContext(ctx, "DELETE FROM drafts WHERE id = $1 AND user_id = $2", draftID, uid)
+	if err != nil {
+		return fmt.Errorf("could not delete draft: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDraftNotFound
+	}
+
+	return nil
+}
+
+// PublishDraft turns a draft of the authenticated user into a post,
+// going through the same validation and side effects as CreateTimelineItem.
+// The draft is deleted once published.
+func (s *Service) PublishDraft(ctx context.Context, draftID string) (TimelineItem, error) {
+	var ti TimelineItem
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ti, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(draftID) {
+		return ti, ErrInvalidDraftID
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return ti, fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	var in CreateTimelineItemInput
+	query := `
+		DELETE FROM drafts WHERE id = $1 AND user_id = $2
+		RETURNING content, spoiler_of, nsfw, quote_id, reply_policy, visibility`
+	err = tx.QueryRowContext(ctx, query, draftID, uid).
+		Scan(&in.Content, &in.SpoilerOf, &in.NSFW, &in.QuoteID, &in.ReplyPolicy, &in.Visibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ti, ErrDraftNotFound
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return ti, fmt.Errorf("could not delete draft to publish: %w", err)
+	}
+
+	if err = in.normalize(); err != nil {
+		tx.Rollback()
+		return ti, err
+	}
+
+	ti, err = s.insertTimelineItem(ctx, tx, uid, in)
+	if err != nil {
+		tx.Rollback()
+		return ti, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return ti, fmt.Errorf("could not commit to publish draft: %w", err)
+	}
+
+	s.timelineItemCreated(ctx, ti)
+
+	return ti, nil
+}
+
+// PruneStaleDrafts deletes drafts not updated in a while every interval, forever.
+func (s *Service) PruneStaleDrafts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		query := "DELETE FROM drafts WHERE updated_at < $1"
+		if _, err := s.Db.Exec(query, time.Now().Add(-draftMaxAge)); err != nil {
+			log.Println("error", fmt.Errorf("could not delete stale drafts: %w", err))
+		}
+	}
+}
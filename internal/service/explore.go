@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExploreMode of the explore feed.
+type ExploreMode string
+
+const (
+	// ExploreLatest lists most recent public posts first. It is the default.
+	ExploreLatest ExploreMode = "latest"
+	// ExplorePopular lists public posts of the last week
+	// ranked by their likes, comments and reposts decayed by age.
+	ExplorePopular ExploreMode = "popular"
+)
+
+// explorePopularWindow limits popular posts to the recent ones.
+const explorePopularWindow = time.Hour * 24 * 7
+
+// explorePopularScoreSQL ranks a post as of @asOf.
+// Interactions weight less the older the post gets.
+const explorePopularScoreSQL = `(
+	(posts.likes_count + 2 * posts.comments_count + 3 * posts.reposts_count)::float8
+	/ power(extract(epoch FROM @asOf::timestamptz - posts.created_at)::float8 / 3600 + 2, 1.5)
+)`
+
+// ErrInvalidExploreMode denotes an unknown explore mode.
+var ErrInvalidExploreMode = InvalidArgumentError("invalid explore mode")
+
+func (mode ExploreMode) normalize() (ExploreMode, error) {
+	switch mode {
+	case "":
+		return ExploreLatest, nil
+	case ExploreLatest, ExplorePopular:
+		return mode, nil
+	}
+	return "", ErrInvalidExploreMode
+}
+
+// ExplorePosts is a page of the explore feed.
+type ExplorePosts struct {
+	Posts Posts
+
+	mode ExploreMode
+	// asOf is the time popular posts were ranked at.
+	// It is kept across pages so scores stay comparable.
+	asOf   time.Time
+	scores []float64
+}
+
+func (ep ExplorePosts) EndCursor() *string {
+	if len(ep.Posts) == 0 {
+		return nil
+	}
+
+	last := ep.Posts[len(ep.Posts)-1]
+	if ep.mode != ExplorePopular {
+		return ptrString(encodeCursor(last.ID, last.CreatedAt))
+	}
+
+	s := fmt.Sprintf("%s,%s,%s,%s",
+		ExplorePopular,
+		last.ID,
+		strconv.FormatFloat(ep.scores[len(ep.scores)-1], 'g', -1, 64),
+		ep.asOf.Format(time.RFC3339Nano),
+	)
+	return ptrString(base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+// popularCursor is the position of a post within the popular ranking.
+type popularCursor struct {
+	id    string
+	score float64
+	asOf  time.Time
+}
+
+func decodePopularCursor(s string) (popularCursor, error) {
+	var cur popularCursor
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return cur, fmt.Errorf("could not base64 decode cursor: %w", err)
+	}
+
+	parts := strings.Split(string(b), ",")
+	if len(parts) != 4 {
+		return cur, errors.New("expected cursor to have four items split by comma")
+	}
+
+	if ExploreMode(parts[0]) != ExplorePopular {
+		return cur, errors.New("cursor mode mismatch")
+	}
+
+	cur.id = parts[1]
+	if cur.score, err = strconv.ParseFloat(parts[2], 64); err != nil {
+		return cur, fmt.Errorf("could not parse cursor score: %w", err)
+	}
+
+	if cur.asOf, err = time.Parse(time.RFC3339Nano, parts[3]); err != nil {
+		return cur, fmt.Errorf("could not parse cursor timestamp: %w", err)
+	}
+
+	return cur, nil
+}
+
+// Explore public posts from the whole instance, with backward pagination.
+// NSFW posts are left out for anonymous users.
+func (s *Service) Explore(ctx context.Context, mode ExploreMode, last uint64, before *string) (ExplorePosts, error) {
+	mode, err := mode.normalize()
+	if err != nil {
+		return ExplorePosts{}, err
+	}
+
+	ep := ExplorePosts{mode: mode, asOf: time.Now().UTC()}
+
+	var beforePostID string
+	var beforeCreatedAt time.Time
+	var beforeScore float64
+	if before != nil {
+		if mode == ExplorePopular {
+			cur, err := decodePopularCursor(*before)
+			if err != nil || !reUUID.MatchString(cur.id) {
+				return ep, ErrInvalidCursor
+			}
+
+			beforePostID, beforeScore, ep.asOf = cur.id, cur.score, cur.asOf
+		} else {
+			beforePostID, beforeCreatedAt, err = decodeCursor(*before)
+			if err != nil || !reUUID.MatchString(beforePostID) {
+				return ep, ErrInvalidCursor
+			}
+		}
+	}
+
+	uid, auth := ctx.Value(KeyAuthUserID).(string)
+	last = normalizePageSize(last)
+	query, args, err := buildQuery(`
+		SELECT posts.id
+		, posts.content
+		, posts.spoiler_of
+		, posts.nsfw
+		, posts.likes_count
+		, posts.comments_count
+		, posts.reposts_count
+		, posts.quotes_count
+		, posts.reply_policy
+		, posts.visibility
+		, posts.created_at
+		, users.username
+		, users.avatar
+		, quotes.id
+		, quotes.content
+		, quotes.spoiler_of
+		, quotes.nsfw
+		, quotes.created_at
+		, quote_users.username
+		, quote_users.avatar
+		{{ if .popular }}
+		, `+explorePopularScoreSQL+` AS score
+		{{ end }}
+		{{ if .auth }}
+		, posts.user_id = @uid AS post_mine
+		, likes.user_id IS NOT NULL AS liked
+		, subscriptions.user_id IS NOT NULL AS subscribed
+		, reposts.user_id IS NOT NULL AS reposted
+		, bookmarks.user_id IS NOT NULL AS bookmarked
+		{{ end }}
+		FROM posts
+		INNER JOIN users ON posts.user_id = users.id
+		LEFT JOIN posts AS quotes ON posts.quote_id = quotes.id AND `+visiblePostSQL("quotes")+`
+		LEFT JOIN users AS quote_users ON quotes.user_id = quote_users.id
+		{{ if .auth }}
+		LEFT JOIN post_likes AS likes
+			ON likes.user_id = @uid AND likes.post_id = posts.id
+		LEFT JOIN post_subscriptions AS subscriptions
+			ON subscriptions.user_id = @uid AND subscriptions.post_id = posts.id
+		LEFT JOIN reposts
+			ON reposts.user_id = @uid AND reposts.post_id = posts.id
+		LEFT JOIN bookmarks
+			ON bookmarks.user_id = @uid AND bookmarks.post_id = posts.id
+		{{ end }}
+		WHERE posts.visibility = 'public'
+		AND posts.publish_at IS NULL
+		{{ if not .auth }}
+		AND NOT posts.nsfw
+		{{ end }}
+		{{ if .popular }}
+			AND posts.created_at <= @asOf
+			AND posts.created_at > @asOf::timestamptz - @window::interval
+			{{ if .beforePostID }}
+			AND (`+explorePopularScoreSQL+`, posts.id) < (@beforeScore::float8, @beforePostID::uuid)
+			{{ end }}
+			ORDER BY score DESC, posts.id DESC
+		{{ else }}
+			{{ if and .beforePostID .beforeCreatedAt }}
+			AND posts.created_at <= @beforeCreatedAt
+			AND (posts.id != @beforePostID OR posts.created_at < @beforeCreatedAt)
+			{{ end }}
+			ORDER BY posts.created_at DESC, posts.id ASC
+		{{ end }}
+		LIMIT @last`, map[string]interface{}{
+		"auth":            auth,
+		"uid":             uid,
+		"popular":         mode == ExplorePopular,
+		"asOf":            ep.asOf,
+		"window":          fmt.Sprintf("%d seconds", int64(explorePopularWindow/time.Second)),
+		"last":            last,
+		"beforePostID":    beforePostID,
+		"beforeCreatedAt": beforeCreatedAt,
+		"beforeScore":     beforeScore,
+	})
+	if err != nil {
+		return ep, fmt.Errorf("could not build explore sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ep, fmt.Errorf("could not query select explore posts: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Post
+		var u User
+		var avatar sql.NullString
+		var q quoteScanner
+		var score float64
+		dest := []interface{}{
+			&p.ID,
+			&p.Content,
+			&p.SpoilerOf,
+			&p.NSFW,
+			&p.LikesCount,
+			&p.CommentsCount,
+			&p.RepostsCount,
+			&p.QuotesCount,
+			&p.ReplyPolicy,
+			&p.Visibility,
+			&p.CreatedAt,
+			&u.Username,
+			&avatar,
+		}
+		dest = append(dest, q.dest()...)
+		if mode == ExplorePopular {
+			dest = append(dest, &score)
+		}
+		if auth {
+			dest = append(dest, &p.Mine, &p.Liked, &p.Subscribed, &p.Reposted, &p.Bookmarked)
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return ep, fmt.Errorf("could not scan explore post: %w", err)
+		}
+
+		u.AvatarURL = s.avatarURL(avatar)
+		p.User = &u
+		p.Quote = s.quotedPost(q)
+		ep.Posts = append(ep.Posts, p)
+		ep.scores = append(ep.scores, score)
+	}
+
+	if err = rows.Err(); err != nil {
+		return ep, fmt.Errorf("could not iterate explore post rows: %w", err)
+	}
+
+	if err = s.hydratePosts(ctx, ep.Posts.ptrs()...); err != nil {
+		return ep, err
+	}
+
+	return ep, nil
+}
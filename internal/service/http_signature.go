@@ -0,0 +1,213 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpSignatureMaxSkew is how far the Date of a signed request can be from now.
+const httpSignatureMaxSkew = time.Hour
+
+// ErrInvalidSignature denotes a missing, malformed or not matching HTTP signature.
+var ErrInvalidSignature = UnauthenticatedError("invalid signature")
+
+// signRequest signs the request with the draft-cavage HTTP Signatures scheme used across the fediverse.
+// The Date, Host and, when there is a body, Digest headers are set and signed.
+func signRequest(r *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	r.Header.Set("Host", r.URL.Host)
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "digest")
+	}
+
+	target := strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+	sum := sha256.Sum256([]byte(signingString(target, r.Header, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return fmt.Errorf("could not sign request: %w", err)
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// SignedRequest is what is needed of an incoming request to verify its signature.
+type SignedRequest struct {
+	Method string
+	// RequestURI is the path and query of the request.
+	RequestURI string
+	Host       string
+	Header     http.Header
+	Body       []byte
+}
+
+type httpSignature struct {
+	keyID     string
+	headers   []string
+	signature []byte
+}
+
+// parseSignature from the Signature header.
+func parseSignature(header http.Header) (httpSignature, error) {
+	var sig httpSignature
+	v := header.Get("Signature")
+	if v == "" {
+		return sig, errors.New("missing signature header")
+	}
+
+	params := map[string]string{}
+	for _, part := range strings.Split(v, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return sig, errors.New("malformed signature header")
+		}
+
+		params[key] = strings.Trim(val, `"`)
+	}
+
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return sig, fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+
+	sig.keyID = params["keyId"]
+	if sig.keyID == "" {
+		return sig, errors.New("missing signature key ID")
+	}
+
+	sig.headers = strings.Fields(params["headers"])
+	if len(sig.headers) == 0 {
+		sig.headers = []string{"date"}
+	}
+
+	var err error
+	if sig.signature, err = base64.StdEncoding.DecodeString(params["signature"]); err != nil {
+		return sig, fmt.Errorf("could not base64 decode signature: %w", err)
+	}
+
+	return sig, nil
+}
+
+// verify the signature of the request with the given public key.
+// The request target, host and date must be signed, so must be the digest of a body.
+func (sig httpSignature) verify(r SignedRequest, key *rsa.PublicKey) error {
+	signed := map[string]bool{}
+	for _, h := range sig.headers {
+		signed[h] = true
+	}
+
+	if !signed["(request-target)"] || !signed["host"] || !signed["date"] || (r.Body != nil && !signed["digest"]) {
+		return errors.New("required headers not signed")
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("could not parse date: %w", err)
+	}
+
+	if d := time.Since(date); d > httpSignatureMaxSkew || d < -httpSignatureMaxSkew {
+		return errors.New("date out of range")
+	}
+
+	if r.Body != nil {
+		sum := sha256.Sum256(r.Body)
+		if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+			return errors.New("digest mismatch")
+		}
+	}
+
+	header := r.Header.Clone()
+	header.Set("Host", r.Host)
+	target := strings.ToLower(r.Method) + " " + r.RequestURI
+	sum := sha256.Sum256([]byte(signingString(target, header, sig.headers)))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig.signature); err != nil {
+		return fmt.Errorf("could not verify signature: %w", err)
+	}
+
+	return nil
+}
+
+func signingString(target string, header http.Header, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		if h == "(request-target)" {
+			lines[i] = h + ": " + target
+			continue
+		}
+
+		lines[i] = h + ": " + header.Get(h)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// generateKeyPair returns a new RSA private key and its public key, both PEM encoded.
+func generateKeyPair() (string, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", fmt.Errorf("could not generate rsa key: %w", err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", fmt.Errorf("could not marshal public key: %w", err)
+	}
+
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+	return string(privPEM), string(pubPEM), nil
+}
+
+func parsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("could not pem decode private key")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %w", err)
+	}
+
+	return key, nil
+}
+
+func parsePublicKey(s string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("could not pem decode public key")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse public key: %w", err)
+		}
+
+		return key, nil
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key: %w", err)
+	}
+
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not rsa")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	linkPreviewTimeout      = time.Second * 5
+	linkPreviewMaxBytes     = 512 << 10 // 512KB
+	linkPreviewMaxRedirects = 3
+	linkPreviewTTL          = time.Hour * 24
+	linkPreviewMaxLength    = 300
+)
+
+var (
+	reURL       = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+[^\s<>"'` + "`" + `.,;:!?)\]]`)
+	reMetaTag   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	reAttribute = regexp.MustCompile(`(?s)([a-zA-Z][a-zA-Z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	reTitleTag  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+	// cgnatPrefix is the shared address space of carrier-grade NATs.
+	cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+	errAddrForbidden = errors.New("address forbidden")
+)
+
+// LinkPreview of the first URL found in a post or comment content.
+type LinkPreview struct {
+	URL         string  `json:"url"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	ImageURL    *string `json:"imageURL"`
+	SiteName    *string `json:"siteName"`
+}
+
+// firstURL in the given text, if any.
+func firstURL(s string) string {
+	return reURL.FindString(s)
+}
+
+// newRestrictedClient is an HTTP client that only dials the allowed addresses.
+func newRestrictedClient(allowed func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: linkPreviewTimeout,
+		// Control runs with the already resolved address,
+		// so a hostname can't be used to reach a private network.
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+
+			if !allowed(addrPort.Addr().Unmap()) {
+				return errAddrForbidden
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: linkPreviewTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   linkPreviewTimeout,
+			ResponseHeaderTimeout: linkPreviewTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= linkPreviewMaxRedirects {
+				return errors.New("too many redirects")
+			}
+
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("unsupported redirect scheme")
+			}
+
+			return nil
+		},
+	}
+}
+
+// linkPreviewAddrAllowed denies private, loopback and otherwise non public addresses,
+// unless they are part of Service.LinkPreviewAllowlist.
+func (s *Service) linkPreviewAddrAllowed(addr netip.Addr) bool {
+	return publicAddrAllowed(s.LinkPreviewAllowlist, addr)
+}
+
+// publicAddrAllowed denies private, loopback and otherwise non public addresses,
+// unless they are part of the allowlist.
+func publicAddrAllowed(allowlist []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range allowlist {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return !(addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		cgnatPrefix.Contains(addr))
+}
+
+// unfurlLink fetches and caches the preview of the first URL found in the given text.
+// Recently fetched URLs, failed or not, are not fetched again.
+func (s *Service) unfurlLink(text string) {
+	rawURL := firstURL(text)
+	if rawURL == "" {
+		return
+	}
+
+	ctx := context.Background()
+	var fresh bool
+	query := "SELECT EXISTS (SELECT 1 FROM link_previews WHERE url = $1 AND fetched_at > $2)"
+	if err := s.Db.QueryRowContext(ctx, query, rawURL, time.Now().Add(-linkPreviewTTL)).Scan(&fresh); err != nil {
+		log.Println("error", fmt.Errorf("could not query select link preview freshness: %w", err))
+		return
+	}
+
+	if fresh {
+		return
+	}
+
+	lp, err := s.fetchLinkPreview(ctx, rawURL)
+	failed := err != nil
+	if failed {
+		log.Println("error", fmt.Errorf("could not fetch link preview of %q: %w", rawURL, err))
+	}
+
+	query = `
+		INSERT INTO link_previews (url, title, description, image_url, site_name, failed) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (url) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			image_url = EXCLUDED.image_url,
+			site_name = EXCLUDED.site_name,
+			failed = EXCLUDED.failed,
+			fetched_at = now()`
+	if _, err = s.Db.ExecContext(ctx, query, rawURL, lp.Title, lp.Description, lp.ImageURL, lp.SiteName, failed); err != nil {
+		log.Println("error", fmt.Errorf("could not upsert link preview: %w", err))
+	}
+}
+
+func (s *Service) fetchLinkPreview(ctx context.Context, rawURL string) (LinkPreview, error) {
+	lp := LinkPreview{URL: rawURL}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return lp, fmt.Errorf("invalid url: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, linkPreviewTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return lp, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "social-media-link-preview/1.0")
+
+	resp, err := s.linkPreviewClient.Do(req)
+	if err != nil {
+		return lp, fmt.Errorf("could not do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return lp, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return lp, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxBytes))
+	if err != nil {
+		return lp, fmt.Errorf("could not read body: %w", err)
+	}
+
+	meta := parseMetaTags(string(b))
+	pick := func(keys ...string) *string {
+		for _, key := range keys {
+			if v := cleanPreviewText(meta[key]); v != "" {
+				return &v
+			}
+		}
+		return nil
+	}
+
+	lp.Title = pick("og:title", "twitter:title")
+	if lp.Title == nil {
+		if m := reTitleTag.FindStringSubmatch(string(b)); m != nil {
+			if v := cleanPreviewText(m[1]); v != "" {
+				lp.Title = &v
+			}
+		}
+	}
+	lp.Description = pick("og:description", "twitter:description", "description")
+	lp.SiteName = pick("og:site_name")
+	if image := pick("og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != nil {
+		// Relative to the final URL after redirects.
+		if imageURL, err := resp.Request.URL.Parse(*image); err == nil &&
+			(imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+			lp.ImageURL = ptrString(imageURL.String())
+		}
+	}
+
+	if lp.Title == nil && lp.Description == nil && lp.ImageURL == nil {
+		return lp, errors.New("no preview metadata")
+	}
+
+	return lp, nil
+}
+
+// parseMetaTags collects the content of <meta> tags keyed by their property or name.
+// The first occurrence wins.
+func parseMetaTags(doc string) map[string]string {
+	meta := map[string]string{}
+	for _, tag := range reMetaTag.FindAllString(doc, -1) {
+		attrs := map[string]string{}
+		for _, m := range reAttribute.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; key == "" || ok {
+			continue
+		}
+
+		meta[key] = attrs["content"]
+	}
+	return meta
+}
+
+func cleanPreviewText(s string) string {
+	s = smartTrim(html.UnescapeString(s))
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > linkPreviewMaxLength {
+		s = string([]rune(s)[:linkPreviewMaxLength-1]) + "…"
+	}
+	return s
+}
+
+// linkPreviews fetches the cached, non failed, previews of the given URLs keyed by URL.
+func (s *Service) linkPreviews(ctx context.Context, urls []string) (map[string]*LinkPreview, error) {
+	previews := map[string]*LinkPreview{}
+	if len(urls) == 0 {
+		return previews, nil
+	}
+
+	query := `
+		SELECT url, title, description, image_url, site_name
+		FROM link_previews
+		WHERE url = ANY($1) AND NOT failed`
+	rows, err := s.Db.QueryContext(ctx, query, pq.Array(urls))
+	if err != nil {
+		return nil, fmt.Errorf("could not query select link previews: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var lp LinkPreview
+		if err = rows.Scan(&lp.URL, &lp.Title, &lp.Description, &lp.ImageURL, &lp.SiteName); err != nil {
+			return nil, fmt.Errorf("could not scan link preview: %w", err)
+		}
+
+		previews[lp.URL] = &lp
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate link preview rows: %w", err)
+	}
+
+	return previews, nil
+}
+
+// attachPostLinkPreviews sets the link preview of each of the given posts that has one.
+func (s *Service) attachPostLinkPreviews(ctx context.Context, pp ...*Post) error {
+	var urls []string
+	for _, p := range pp {
+		if u := firstURL(p.Content); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	previews, err := s.linkPreviews(ctx, urls)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pp {
+		p.LinkPreview = previews[firstURL(p.Content)]
+	}
+
+	return nil
+}
+
+// attachCommentLinkPreviews sets the link preview of each of the given comments that has one.
+func (s *Service) attachCommentLinkPreviews(ctx context.Context, cc ...*Comment) error {
+	var urls []string
+	for _, c := range cc {
+		if u := firstURL(c.Content); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	previews, err := s.linkPreviews(ctx, urls)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cc {
+		c.LinkPreview = previews[firstURL(c.Content)]
+	}
+
+	return nil
+}
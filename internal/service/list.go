@@ -0,0 +1,690 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const listNameMaxLength = 64
+
+var (
+	// ErrInvalidListID denotes an invalid list ID; that is not uuid.
+	ErrInvalidListID = InvalidArgumentError("invalid list ID")
+	// ErrInvalidListName denotes an empty or too long list name.
+	ErrInvalidListName = InvalidArgumentError("invalid list name")
+	// ErrListNotFound denotes a not found list, or a private list of another user.
+	ErrListNotFound = NotFoundError("list not found")
+	// ErrListNameTaken denotes a list name already in use by the user.
+	ErrListNameTaken = AlreadyExistsError("list name taken")
+	// ErrUpdateListDenied denotes an attempt to change the list of another user.
+	ErrUpdateListDenied = PermissionDeniedError("update list denied")
+	// ErrListMemberNotFound denotes a user that is not member of a list.
+	ErrListMemberNotFound = NotFoundError("list member not found")
+)
+
+// List of accounts curated by a user.
+// Members are added without following them.
+// Private lists are only seen by their owner.
+type List struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Private      bool      `json:"private"`
+	MembersCount int       `json:"membersCount"`
+	CreatedAt    time.Time `json:"createdAt"`
+	User         *User     `json:"user,omitempty"`
+	Mine         bool      `json:"mine"`
+}
+
+// ListEvent is delivered to the clients streaming a list timeline.
+type ListEvent struct {
+	ListID string
+	// Audience are the IDs of the users that can read the post when it is not public.
+	Audience map[string]struct{}
+	Post     Post
+}
+
+// CreateList for the authenticated user.
+func (s *Service) CreateList(ctx context.Context, name string, private bool) (List, error) {
+	var l List
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return l, ErrUnauthenticated
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > listNameMaxLength {
+		return l, ErrInvalidListName
+	}
+
+	query := "INSERT INTO lists (user_id, name, private) VALUES ($1, $2, $3) RETURNING id, created_at"
+	err := s.Db.QueryRowContext(ctx, query, uid, name, private).Scan(&l.ID, &l.CreatedAt)
+	if isUniqueViolation(err) {
+		return l, ErrListNameTaken
+	}
+
+	if isForeignKeyViolation(err) {
+		return l, ErrUserGone
+	}
+
+	if err != nil {
+		return l, fmt.Errorf("could not insert list: %w", err)
+	}
+
+	l.Name = name
+	l.Private = private
+	l.Mine = true
+
+	return l, nil
+}
+
+// Lists of the given user in alphabetical order.
+// Private lists are included only for their owner.
+func (s *Service) Lists(ctx context.Context, username string) ([]List, error) {
+	username = strings.TrimSpace(username)
+	if !validUserHandle(username) {
+		return nil, ErrInvalidUsername
+	}
+
+	uid, auth := ctx.Value(KeyAuthUserID).(string)
+	query, args, err := buildQuery(`
+		SELECT lists.id
+		, lists.name
+		, lists.private
+		, lists.created_at
+		, (SELECT count(*) FROM list_members WHERE list_members.list_id = lists.id) AS members_count
+		, users.username
+		, users.avatar
+		{{ if .auth }}
+		, lists.user_id = @uid AS list_mine
+		{{ end }}
+		FROM lists
+		INNER JOIN users ON lists.user_id = users.id
+		WHERE users.username = @username
+		{{ if .auth }}
+		AND (NOT lists.private OR lists.user_id = @uid)
+		{{ else }}
+		AND NOT lists.private
+		{{ end }}
+		ORDER BY lists.name ASC`, map[string]interface{}{
+		"auth":     auth,
+		"uid":      uid,
+		"username": username,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build lists sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select lists: %w", err)
+	}
+
+	defer rows.Close()
+
+	var ll []List
+	for rows.Next() {
+		var l List
+		var u User
+		var avatar sql.NullString
+		dest := []interface{}{
+			&l.ID,
+			&l.Name,
+			&l.Private,
+			&l.CreatedAt,
+			&l.MembersCount,
+			&u.Username,
+			&avatar,
+		}
+		if auth {
+			dest = append(dest, &l.Mine)
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("could not scan list: %w", err)
+		}
+
+		u.AvatarURL = s.avatarURL(avatar)
+		l.User = &u
+		ll = append(ll, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate list rows: %w", err)
+	}
+
+	return ll, nil
+}
+
+// List by ID.
+func (s *Service) List(ctx context.Context, listID string) (List, error) {
+	var l List
+	if !reUUID.MatchString(listID) {
+		return l, ErrInvalidListID
+	}
+
+	uid, _ := ctx.Value(KeyAuthUserID).(string)
+	var userID string
+	var u User
+	var avatar sql.NullString
+	query := `
+		SELECT lists.name
+		, lists.private
+		, lists.created_at
+		, (SELECT count(*) FROM list_members WHERE list_members.list_id = lists.id) AS members_count
+		, lists.user_id
+		, users.username
+		, users.avatar
+		FROM lists
+		INNER JOIN users ON lists.user_id = users.id
+		WHERE lists.id = $1`
+	err := s.Db.QueryRowContext(ctx, query, listID).Scan(
+		&l.Name,
+		&l.Private,
+		&l.CreatedAt,
+		&l.MembersCount,
+		&userID,
+		&u.Username,
+		&avatar,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return l, ErrListNotFound
+	}
+
+	if err != nil {
+		return l, fmt.Errorf("could not query select list: %w", err)
+	}
+
+	l.Mine = userID == uid
+	if l.Private && !l.Mine {
+		return l, ErrListNotFound
+	}
+
+	u.AvatarURL = s.avatarURL(avatar)
+	l.ID = listID
+	l.User = &u
+
+	return l, nil
+}
+
+// listOwner returns the ID of the owner of a list readable by the authenticated user.
+func (s *Service) listOwner(ctx context.Context, listID string) (string, error) {
+	if !reUUID.MatchString(listID) {
+		return "", ErrInvalidListID
+	}
+
+	var ownerID string
+	var private bool
+	query := "SELECT user_id, private FROM lists WHERE id = $1"
+	err := s.Db.QueryRowContext(ctx, query, listID).Scan(&ownerID, &private)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrListNotFound
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not query select list owner: %w", err)
+	}
+
+	if uid, _ := ctx.Value(KeyAuthUserID).(string); private && ownerID != uid {
+		return "", ErrListNotFound
+	}
+
+	return ownerID, nil
+}
+
+// DeleteList of the authenticated user.
+func (s *Service) DeleteList(ctx context.Context, listID string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(listID) {
+		return ErrInvalidListID
+	}
+
+	res, err := s.Db.ExecContext(ctx, "DELETE FROM lists WHERE id = $1 AND user_id = $2", listID, uid)
+	if err != nil {
+		return fmt.Errorf("could not delete list: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrListNotFound
+	}
+
+	return nil
+}
+
+// AddListMember adds a user to a list of the authenticated user.
+// Adding a user that is already a member does nothing.
+func (s *Service) AddListMember(ctx context.Context, listID, username string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	username = strings.TrimSpace(username)
+	if !validUserHandle(username) {
+		return ErrInvalidUsername
+	}
+
+	ownerID, err := s.listOwner(ctx, listID)
+	if err != nil {
+		return err
+	}
+
+	if ownerID != uid {
+		return ErrUpdateListDenied
+	}
+
+	var memberID string
+	err = s.Db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&memberID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select list member id: %w", err)
+	}
+
+	query := "INSERT INTO list_members (list_id, user_id) VALUES ($1, $2) ON CONFLICT (list_id, user_id) DO NOTHING"
+	_, err = s.Db.ExecContext(ctx, query, listID, memberID)
+	if isForeignKeyViolation(err) {
+		return ErrListNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not insert list member: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveListMember removes a user from a list of the authenticated user.
+func (s *Service) RemoveListMember(ctx context.Context, listID, username string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	username = strings.TrimSpace(username)
+	if !validUserHandle(username) {
+		return ErrInvalidUsername
+	}
+
+	ownerID, err := s.listOwner(ctx, listID)
+	if err != nil {
+		return err
+	}
+
+	if ownerID != uid {
+		return ErrUpdateListDenied
+	}
+
+	query := `
+		DELETE FROM list_members
+		WHERE list_id = $1 AND user_id = (SELECT id FROM users WHERE username = $2)`
+	res, err := s.Db.ExecContext(ctx, query, listID, username)
+	if err != nil {
+		return fmt.Errorf("could not delete list member: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrListMemberNotFound
+	}
+
+	return nil
+}
+
+// ListMembers in ascending order with forward pagination.
+func (s *Service) ListMembers(ctx context.Context, listID string, first uint64, after string) (UserProfiles, error) {
+	if _, err := s.listOwner(ctx, listID); err != nil {
+		return nil, err
+	}
+
+	first = normalizePageSize(first)
+	uid, auth := ctx.Value(KeyAuthUserID).(string)
+	query, args, err := buildQuery(`
+		SELECT users.id
+		, COALESCE(users.email, '') AS email
+		, users.username
+		, users.avatar
+		, users.followers_count
+		, users.followees_count
+		{{ if .auth }}
+		, followers.follower_id IS NOT NULL AS following
+		, followees.followee_id IS NOT NULL AS followeed
+		{{ end }}
+		FROM list_members
+		INNER JOIN users ON list_members.user_id = users.id
+		{{ if .auth }}
+		LEFT JOIN follows AS followers
+			ON followers.follower_id = @uid AND followers.followee_id = users.id
+		LEFT JOIN follows AS followees
+			ON followees.follower_id = users.id AND followees.followee_id = @uid
+		{{ end }}
+		WHERE list_members.list_id = @listID
+		{{ if .after }}AND username > @after{{ end }}
+		ORDER BY username ASC
+		LIMIT @first`, map[string]interface{}{
+		"auth":   auth,
+		"uid":    uid,
+		"listID": listID,
+		"first":  first,
+		"after":  after,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build list members sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select list members: %w", err)
+	}
+
+	defer rows.Close()
+
+	var uu UserProfiles
+	for rows.Next() {
+		var u UserProfile
+		var avatar sql.NullString
+		dest := []interface{}{
+			&u.ID,
+			&u.Email,
+			&u.Username,
+			&avatar,
+			&u.FollowersCount,
+			&u.FolloweesCount,
+		}
+		if auth {
+			dest = append(dest, &u.Following, &u.Followeed)
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("could not scan list member: %w", err)
+		}
+
+		u.Me = auth && uid == u.ID
+		if !u.Me {
+			u.ID = ""
+			u.Email = ""
+		}
+		u.AvatarURL = s.avatarURL(avatar)
+		uu = append(uu, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate list member rows: %w", err)
+	}
+
+	return uu, nil
+}
+
+// ListTimeline are the posts of the list members readable by the authenticated user,
+// most recent first and with pagination in both directions.
+func (s *Service) ListTimeline(ctx context.Context, listID string, page PageArgs) (Posts, PageInfo, error) {
+	var info PageInfo
+	if _, err := s.listOwner(ctx, listID); err != nil {
+		return nil, info, err
+	}
+
+	if err := page.validate(); err != nil {
+		return nil, info, err
+	}
+
+	var cursorPostID string
+	var cursorCreatedAt time.Time
+	if cursor := page.cursor(); cursor != nil {
+		var err error
+		cursorPostID, cursorCreatedAt, err = decodeCursor(*cursor)
+		if err != nil || !reUUID.MatchString(cursorPostID) {
+			return nil, info, ErrInvalidCursor
+		}
+	}
+
+	uid, auth := ctx.Value(KeyAuthUserID).(string)
+	query, args, err := buildQuery(`
+		SELECT posts.id
+		, posts.content
+		, posts.spoiler_of
+		, posts.nsfw
+		, posts.likes_count
+		, posts.comments_count
+		, posts.reposts_count
+		, posts.quotes_count
+		, posts.reply_policy
+		, posts.visibility
+		, posts.created_at
+		, users.username
+		, users.avatar
+		, quotes.id
+		, quotes.content
+		, quotes.spoiler_of
+		, quotes.nsfw
+		, quotes.created_at
+		, quote_users.username
+		, quote_users.avatar
+		{{ if .auth }}
+		, posts.user_id = @uid AS post_mine
+		, likes.user_id IS NOT NULL AS liked
+		, subscriptions.user_id IS NOT NULL AS subscribed
+		, reposts.user_id IS NOT NULL AS reposted
+		, bookmarks.user_id IS NOT NULL AS bookmarked
+		{{ end }}
+		FROM list_members
+		INNER JOIN posts ON posts.user_id = list_members.user_id
+		INNER JOIN users ON posts.user_id = users.id
+		LEFT JOIN posts AS quotes ON posts.quote_id = quotes.id AND `+visiblePostSQL("quotes")+`
+		LEFT JOIN users AS quote_users ON quotes.user_id = quote_users.id
+		{{ if .auth }}
+		LEFT JOIN post_likes AS likes
+			ON likes.user_id = @uid AND likes.post_id = posts.id
+		LEFT JOIN post_subscriptions AS subscriptions
+			ON subscriptions.user_id = @uid AND subscriptions.post_id = posts.id
+		LEFT JOIN reposts
+			ON reposts.user_id = @uid AND reposts.post_id = posts.id
+		LEFT JOIN bookmarks
+			ON bookmarks.user_id = @uid AND bookmarks.post_id = posts.id
+		{{ end }}
+		WHERE list_members.list_id = @listID
+		AND posts.publish_at IS NULL
+		AND `+visiblePostSQL("posts")+`
+		{{ if and .cursorPostID .forward }}
+		AND posts.created_at >= @cursorCreatedAt
+		AND (posts.id != @cursorPostID OR posts.created_at > @cursorCreatedAt)
+		{{ else if .cursorPostID }}
+		AND posts.created_at <= @cursorCreatedAt
+		AND (posts.id != @cursorPostID OR posts.created_at < @cursorCreatedAt)
+		{{ end }}
+		{{ if .forward }}
+		ORDER BY posts.created_at ASC, posts.id DESC
+		{{ else }}
+		ORDER BY posts.created_at DESC, posts.id ASC
+		{{ end }}
+		LIMIT @limit`, map[string]interface{}{
+		"auth":            auth,
+		"uid":             uid,
+		"listID":          listID,
+		"forward":         page.forward(),
+		"limit":           page.limit(),
+		"cursorPostID":    cursorPostID,
+		"cursorCreatedAt": cursorCreatedAt,
+	})
+	if err != nil {
+		return nil, info, fmt.Errorf("could not build list timeline sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, info, fmt.Errorf("could not query select list timeline: %w", err)
+	}
+
+	defer rows.Close()
+
+	var pp Posts
+	for rows.Next() {
+		var p Post
+		var u User
+		var avatar sql.NullString
+		var q quoteScanner
+		dest := []interface{}{
+			&p.ID,
+			&p.Content,
+			&p.SpoilerOf,
+			&p.NSFW,
+			&p.LikesCount,
+			&p.CommentsCount,
+			&p.RepostsCount,
+			&p.QuotesCount,
+			&p.ReplyPolicy,
+			&p.Visibility,
+			&p.CreatedAt,
+			&u.Username,
+			&avatar,
+		}
+		dest = append(dest, q.dest()...)
+		if auth {
+			dest = append(dest, &p.Mine, &p.Liked, &p.Subscribed, &p.Reposted, &p.Bookmarked)
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return nil, info, fmt.Errorf("could not scan list timeline post: %w", err)
+		}
+
+		u.AvatarURL = s.avatarURL(avatar)
+		p.User = &u
+		p.Quote = s.quotedPost(q)
+		pp = append(pp, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, info, fmt.Errorf("could not iterate list timeline rows: %w", err)
+	}
+
+	pp, info = paginate(pp, page)
+	if err = s.hydratePosts(ctx, pp.ptrs()...); err != nil {
+		return nil, info, err
+	}
+
+	return pp, info, nil
+}
+
+// ListStream to receive new posts of the list members in realtime.
+func (s *Service) ListStream(ctx context.Context, listID string) (<-chan Post, error) {
+	if _, err := s.listOwner(ctx, listID); err != nil {
+		return nil, err
+	}
+
+	pp := make(chan Post)
+	c := &listClient{posts: pp, listID: listID, ctx: ctx}
+	if uid, ok := ctx.Value(KeyAuthUserID).(string); ok {
+		c.userID = &uid
+	}
+	// Signal the broker that we have a new connection
+	s.BrokerRepository.listBroker.NewClients <- c
+
+	go func() {
+		// Listen to connection close and un-register client connection
+		<-ctx.Done()
+		s.BrokerRepository.listBroker.ClosingClients <- c
+	}()
+	return pp, nil
+}
+
+// streamPostToLists sends a new post to the clients streaming
+// the timeline of a list the author is member of.
+// Posts that are not public only go to the users that can read them.
+func (s *Service) streamPostToLists(ctx context.Context, p Post) error {
+	viewers := s.BrokerRepository.listBroker.connectedViewers()
+	if len(viewers) == 0 {
+		return nil
+	}
+
+	listIDs := make([]string, 0, len(viewers))
+	for listID := range viewers {
+		listIDs = append(listIDs, listID)
+	}
+
+	query := "SELECT list_id FROM list_members WHERE user_id = $1 AND list_id = ANY($2)"
+	rows, err := s.Db.QueryContext(ctx, query, p.UserID, pq.Array(listIDs))
+	if err != nil {
+		return fmt.Errorf("could not query select streaming lists: %w", err)
+	}
+
+	defer rows.Close()
+
+	listIDs = listIDs[:0]
+	for rows.Next() {
+		var listID string
+		if err = rows.Scan(&listID); err != nil {
+			return fmt.Errorf("could not scan streaming list: %w", err)
+		}
+
+		listIDs = append(listIDs, listID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate streaming list rows: %w", err)
+	}
+
+	var audience map[string]struct{}
+	if p.Visibility != VisibilityPublic {
+		var uids []string
+		for _, listID := range listIDs {
+			uids = append(uids, viewers[listID]...)
+		}
+
+		if audience, err = s.postAudience(ctx, p.ID, uids); err != nil {
+			return err
+		}
+	}
+
+	for _, listID := range listIDs {
+		go s.broadcastListEvent(ListEvent{ListID: listID, Audience: audience, Post: p})
+	}
+
+	return nil
+}
+
+// postAudience returns which of the given users can read a post.
+func (s *Service) postAudience(ctx context.Context, postID string, uids []string) (map[string]struct{}, error) {
+	audience := map[string]struct{}{}
+	if len(uids) == 0 {
+		return audience, nil
+	}
+
+	query := `
+		SELECT users.id FROM users
+		INNER JOIN posts ON posts.id = $1
+		WHERE users.id = ANY($2)
+			AND ` + postVisibleToSQL("posts", "users.id")
+	rows, err := s.Db.QueryContext(ctx, query, postID, pq.Array(uids))
+	if err != nil {
+		return nil, fmt.Errorf("could not query select post audience: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid string
+		if err = rows.Scan(&uid); err != nil {
+			return nil, fmt.Errorf("could not scan post audience user: %w", err)
+		}
+
+		audience[uid] = struct{}{}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate post audience rows: %w", err)
+	}
+
+	return audience, nil
+}
+
+func (s *Service) broadcastListEvent(e ListEvent) {
+	s.BrokerRepository.listBroker.Notifier <- e
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Mail is an email with both plain text and HTML bodies.
+type Mail struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// UnsubscribeURL, if any, is set as the one-click List-Unsubscribe of the mail.
+	UnsubscribeURL string
+}
+
+// Mailer sends mails.
+type Mailer interface {
+	Send(ctx context.Context, m Mail) error
+}
+
+// SMTPMailer sends mails through an SMTP server,
+// authenticating if a username is given.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	msg, err := mail.encode(m.Sender)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	if err = smtp.SendMail(addr, auth, m.Sender, []string{mail.To}, msg); err != nil {
+		return fmt.Errorf("could not send mail: %w", err)
+	}
+
+	return nil
+}
+
+// FileMailer writes mails as .eml files in a directory instead of sending them.
+type FileMailer struct {
+	Dir    string
+	Sender string
+}
+
+func (m *FileMailer) Send(ctx context.Context, mail Mail) error {
+	msg, err := mail.encode(m.Sender)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("could not create mails dir: %w", err)
+	}
+
+	name := fmt.Sprintf("%s_%s.eml", time.Now().UTC().Format("20060102T150405"), randomHex(4))
+	if err = os.WriteFile(filepath.Join(m.Dir, name), msg, 0o644); err != nil {
+		return fmt.Errorf("could not write mail file: %w", err)
+	}
+
+	return nil
+}
+
+// MemoryMailer keeps the mails in memory instead of sending them.
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []Mail
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, mail)
+	return nil
+}
+
+// Sent mails so far.
+func (m *MemoryMailer) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.mails...)
+}
+
+// encode the mail as a multipart/alternative MIME message.
+func (m Mail) encode(from string) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create mail part: %w", err)
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err = qw.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("could not write mail part: %w", err)
+		}
+
+		if err = qw.Close(); err != nil {
+			return nil, fmt.Errorf("could not close mail part: %w", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("could not close mail body: %w", err)
+	}
+
+	var msg bytes.Buffer
+	header := [][2]string{
+		{"From", from},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	if m.UnsubscribeURL != "" {
+		header = append(header,
+			[2]string{"List-Unsubscribe", "<" + m.UnsubscribeURL + ">"},
+			[2]string{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+		)
+	}
+	for _, h := range header {
+		msg.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/lib/pq"
 	"log"
@@ -14,17 +15,27 @@ var ErrInvalidNotificationID = InvalidArgumentError("invalid notification ID")
 
 // Notification model.
 type Notification struct {
-	ID       string    `json:"id"`
-	UserID   string    `json:"-"`
-	Actors   []string  `json:"actors"`
-	Type     string    `json:"type"`
-	PostID   *string   `json:"postID,omitempty"`
-	Read     bool      `json:"read"`
-	IssuedAt time.Time `json:"issuedAt"`
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	Actors    []string  `json:"actors"`
+	Type      string    `json:"type"`
+	PostID    *string   `json:"postID,omitempty"`
+	CommentID *string   `json:"commentID,omitempty"`
+	Read      bool      `json:"read"`
+	IssuedAt  time.Time `json:"issuedAt"`
 }
 
 type Notifications []Notification
 
+func (pp Notifications) StartCursor() *string {
+	if len(pp) == 0 {
+		return nil
+	}
+
+	first := pp[0]
+	return ptrString(encodeCursor(first.ID, first.IssuedAt))
+}
+
 func (pp Notifications) EndCursor() *string {
 	if len(pp) == 0 {
 		return nil
@@ -34,55 +45,71 @@ func (pp Notifications) EndCursor() *string {
 	return ptrString(encodeCursor(last.ID, last.IssuedAt))
 }
 
-// Notifications from the authenticated user in descending order with backward pagination.
-func (s *Service) Notifications(ctx context.Context, last uint64, before *string) (Notifications, error) {
+// Notifications from the authenticated user in descending order with pagination in both directions.
+func (s *Service) Notifications(ctx context.Context, page PageArgs) (Notifications, PageInfo, error) {
+	var info PageInfo
 	uid, ok := ctx.Value(KeyAuthUserID).(string)
 	if !ok {
-		return nil, ErrUnauthenticated
+		return nil, info, ErrUnauthenticated
+	}
+
+	if err := page.validate(); err != nil {
+		return nil, info, err
 	}
 
-	var beforeNotificationID string
-	var beforeIssuedAt time.Time
+	var cursorNotificationID string
+	var cursorIssuedAt time.Time
 
-	if before != nil {
+	if cursor := page.cursor(); cursor != nil {
 		var err error
-		beforeNotificationID, beforeIssuedAt, err = decodeCursor(*before)
-		if err != nil || !reUUID.MatchString(beforeNotificationID) {
-			return nil, ErrInvalidCursor
+		cursorNotificationID, cursorIssuedAt, err = decodeCursor(*cursor)
+		if err != nil || !reUUID.MatchString(cursorNotificationID) {
+			return nil, info, ErrInvalidCursor
 		}
 	}
 
-	last = normalizePageSize(last)
 	query, args, err := buildQuery(`
 		SELECT id
 		, actors
 		, type
 		, post_id
+		, comment_id
 		, read_at
 		, issued_at
 		FROM notifications
 		WHERE user_id = @uid
-		{{ if and .beforeNotificationID .beforeIssuedAt }}
-			AND issued_at <= @beforeIssuedAt
+		{{ if and .cursorNotificationID .forward }}
+			AND issued_at >= @cursorIssuedAt
 			AND (
-				id != @beforeNotificationID
-					OR issued_at < @beforeIssuedAt
+				id != @cursorNotificationID
+					OR issued_at > @cursorIssuedAt
+			)
+		{{ else if .cursorNotificationID }}
+			AND issued_at <= @cursorIssuedAt
+			AND (
+				id != @cursorNotificationID
+					OR issued_at < @cursorIssuedAt
 			)
 		{{ end }}
+		{{ if .forward }}
+		ORDER BY issued_at ASC, id DESC
+		{{ else }}
 		ORDER BY issued_at DESC, id ASC
-		LIMIT @last`, map[string]interface{}{
+		{{ end }}
+		LIMIT @limit`, map[string]interface{}{
 		"uid":                  uid,
-		"last":                 last,
-		"beforeNotificationID": beforeNotificationID,
-		"beforeIssuedAt":       beforeIssuedAt,
+		"forward":              page.forward(),
+		"limit":                page.limit(),
+		"cursorNotificationID": cursorNotificationID,
+		"cursorIssuedAt":       cursorIssuedAt,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not build notifications sql query: %w", err)
+		return nil, info, fmt.Errorf("could not build notifications sql query: %w", err)
 	}
 
 	rows, err := s.Db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("could not query select notifications: %w", err)
+		return nil, info, fmt.Errorf("could not query select notifications: %w", err)
 	}
 
 	defer rows.Close()
@@ -91,18 +118,19 @@ func (s *Service) Notifications(ctx context.Context, last uint64, before *string
 	for rows.Next() {
 		var n Notification
 		var readAt *time.Time
-		if err = rows.Scan(&n.ID, pq.Array(&n.Actors), &n.Type, &n.PostID, &readAt, &n.IssuedAt); err != nil {
-			return nil, fmt.Errorf("could not scan notification: %w", err)
+		if err = rows.Scan(&n.ID, pq.Array(&n.Actors), &n.Type, &n.PostID, &n.CommentID, &readAt, &n.IssuedAt); err != nil {
+			return nil, info, fmt.Errorf("could not scan notification: %w", err)
 		}
 		n.Read = readAt != nil
 		nn = append(nn, n)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("could not iterate over notification rows: %w", err)
+		return nil, info, fmt.Errorf("could not iterate over notification rows: %w", err)
 	}
 
-	return nn, nil
+	nn, info = paginate(nn, page)
+	return nn, info, nil
 }
 
 // MarkNotificationAsRead sets a notification from the authenticated user as read.
@@ -158,23 +186,33 @@ func (s *Service) HasUnreadNotifications(ctx context.Context) (bool, error) {
 	return unread, nil
 }
 
-func (s *Service) notifyFollow(followerID, followeeID string) {
-	ctx := context.Background()
+// notifyFollow adds the follower to the unread follow notification of the followee,
+// once per follower.
+func (s *Service) notifyFollow(ctx context.Context, followerID, followeeID string) error {
 	var n Notification
 	var notified bool
 
+	var allowed bool
+	query := "SELECT " + notificationAllowedSQL("$1", "follow", "$2")
+	err := s.Db.QueryRowContext(ctx, query, followeeID, followerID).Scan(&allowed)
+	if err != nil {
+		return fmt.Errorf("could not query select follow notification setting: %w", err)
+	}
+
+	if !allowed {
+		return nil
+	}
+
 	var actor string
-	query := "SELECT username FROM users WHERE id = $1"
-	err := s.Db.QueryRowContext(ctx, query, followerID).Scan(&actor)
+	query = "SELECT username FROM users WHERE id = $1"
+	err = s.Db.QueryRowContext(ctx, query, followerID).Scan(&actor)
 	if err != nil {
-		log.Println(fmt.Errorf("could not query select follow notification actor: %w", err))
-		return
+		return fmt.Errorf("could not query select follow notification actor: %w", err)
 	}
 
 	tx, err := s.Db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("could not begin tx: %w", err)
-		return
+		return fmt.Errorf("could not begin tx: %w", err)
 	}
 	query = `SELECT EXISTS (
 			SELECT 1 FROM notifications
@@ -184,20 +222,21 @@ func (s *Service) notifyFollow(followerID, followeeID string) {
 		)`
 	err = tx.QueryRowContext(ctx, query, followeeID, actor).Scan(&notified)
 	if err != nil {
-		log.Println(fmt.Errorf("could not query select follow notification existence: %w", err))
-		return
+		tx.Rollback()
+		return fmt.Errorf("could not query select follow notification existence: %w", err)
 	}
 
 	if notified {
-		return
+		tx.Rollback()
+		return nil
 	}
 
 	var nid string
 	query = "SELECT id FROM notifications WHERE user_id = $1 AND type = 'follow' AND read_at IS NULL"
 	err = tx.QueryRowContext(ctx, query, followeeID).Scan(&nid)
 	if err != nil && err != sql.ErrNoRows {
-		log.Println(fmt.Errorf("could not query select unread follow notification: %w", err))
-		return
+		tx.Rollback()
+		return fmt.Errorf("could not query select unread follow notification: %w", err)
 	}
 
 	if err == sql.ErrNoRows {
@@ -209,8 +248,7 @@ func (s *Service) notifyFollow(followerID, followeeID string) {
 		err = row.Scan(&n.ID, &n.IssuedAt)
 		if err != nil {
 			tx.Rollback()
-			log.Println(fmt.Errorf("could not insert follow notification: %w", err))
-			return
+			return fmt.Errorf("could not insert follow notification: %w", err)
 		}
 
 		n.Actors = actors
@@ -225,8 +263,7 @@ func (s *Service) notifyFollow(followerID, followeeID string) {
 		err = row.Scan(pq.Array(&n.Actors), &n.IssuedAt)
 		if err != nil {
 			tx.Rollback()
-			log.Println(fmt.Errorf("could not update follow notification: %w", err))
-			return
+			return fmt.Errorf("could not update follow notification: %w", err)
 		}
 
 		n.ID = nid
@@ -236,23 +273,30 @@ func (s *Service) notifyFollow(followerID, followeeID string) {
 	n.Type = "follow"
 
 	if err = tx.Commit(); err != nil {
-		log.Printf("could not commit to notify follow: %w", err)
-		return
+		return fmt.Errorf("could not commit to notify follow: %w", err)
 	}
 
 	if !notified {
 		go s.broadcastNotification(n)
 	}
+
+	return nil
 }
 
-func (s *Service) notifyComment(c Comment) {
+// notifyComment notifies the post subscribers, but the replied comment author,
+// adding the commenter to their unread comment notification of the post.
+func (s *Service) notifyComment(ctx context.Context, c Comment) error {
 	actor := c.User.Username
-	rows, err := s.Db.Query(`
+	rows, err := s.Db.QueryContext(ctx, `
 		INSERT INTO notifications (user_id, actors, type, post_id)
 		SELECT user_id, $1, 'comment', $2 FROM post_subscriptions
 		WHERE post_subscriptions.user_id != $3
 			AND post_subscriptions.post_id = $2
-		ON CONFLICT (user_id, type, post_id, read_at) DO UPDATE SET
+			AND post_subscriptions.user_id NOT IN (
+				SELECT user_id FROM comments WHERE id = $5
+			)
+			AND `+notificationAllowedSQL("post_subscriptions.user_id", "comment", "$3")+`
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO UPDATE SET
 			actors = array_prepend($4, array_remove(notifications.actors, $4)),
 			issued_at = now()
 		RETURNING id, user_id, actors, issued_at`,
@@ -260,10 +304,10 @@ func (s *Service) notifyComment(c Comment) {
 		c.PostID,
 		c.UserID,
 		actor,
+		c.ParentID,
 	)
 	if err != nil {
-		log.Println("error", fmt.Errorf("could not insert comment notifications: %w", err))
-		return
+		return fmt.Errorf("could not insert comment notifications: %w", err)
 	}
 
 	defer rows.Close()
@@ -271,8 +315,7 @@ func (s *Service) notifyComment(c Comment) {
 	for rows.Next() {
 		var n Notification
 		if err = rows.Scan(&n.ID, &n.UserID, pq.Array(&n.Actors), &n.IssuedAt); err != nil {
-			log.Println("error", fmt.Errorf("could not scan comment notification: %w", err))
-			return
+			return fmt.Errorf("could not scan comment notification: %w", err)
 		}
 
 		n.Type = "comment"
@@ -282,23 +325,71 @@ func (s *Service) notifyComment(c Comment) {
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Println("error", fmt.Errorf("could not iterate over comment notification rows: %w", err))
-		return
+		return fmt.Errorf("could not iterate over comment notification rows: %w", err)
+	}
+
+	return nil
+}
+
+// notifyCommentReply notifies the author of the replied comment,
+// who is left out of the regular comment notification.
+func (s *Service) notifyCommentReply(ctx context.Context, c Comment) error {
+	actor := c.User.Username
+	rows, err := s.Db.QueryContext(ctx, `
+		INSERT INTO notifications (user_id, actors, type, post_id, comment_id)
+		SELECT user_id, $1, 'comment_reply', post_id, id FROM comments
+		WHERE id = $2
+			AND user_id != $3
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO UPDATE SET
+			actors = array_prepend($4, array_remove(notifications.actors, $4)),
+			issued_at = now()
+		RETURNING id, user_id, actors, issued_at`,
+		pq.Array([]string{actor}),
+		c.ParentID,
+		c.UserID,
+		actor,
+	)
+	if err != nil {
+		return fmt.Errorf("could not insert comment reply notification: %w", err)
 	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var n Notification
+		if err = rows.Scan(&n.ID, &n.UserID, pq.Array(&n.Actors), &n.IssuedAt); err != nil {
+			return fmt.Errorf("could not scan comment reply notification: %w", err)
+		}
+
+		n.Type = "comment_reply"
+		n.PostID = &c.PostID
+		n.CommentID = c.ParentID
+
+		go s.broadcastNotification(n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate comment reply notification rows: %w", err)
+	}
+
+	return nil
 }
 
-func (s *Service) notifyPostMention(p Post) {
+// notifyPostMention notifies the users mentioned in the post content, once per post.
+func (s *Service) notifyPostMention(ctx context.Context, p Post) error {
 	mentions := collectMentions(p.Content)
 	if len(mentions) == 0 {
-		return
+		return nil
 	}
 
 	actors := []string{p.User.Username}
-	rows, err := s.Db.Query(`
+	rows, err := s.Db.QueryContext(ctx, `
 		INSERT INTO notifications (user_id, actors, type, post_id)
 		SELECT users.id, $1, 'post_mention', $2 FROM users
 		WHERE users.id != $3
 			AND username = ANY($4)
+			AND `+notificationAllowedSQL("users.id", "post_mention", "$3")+`
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO NOTHING
 		RETURNING id, user_id, issued_at`,
 		pq.Array(actors),
 		p.ID,
@@ -306,8 +397,7 @@ func (s *Service) notifyPostMention(p Post) {
 		pq.Array(mentions),
 	)
 	if err != nil {
-		log.Println("error", fmt.Errorf("could not insert post mention notifications: %w", err))
-		return
+		return fmt.Errorf("could not insert post mention notifications: %w", err)
 	}
 
 	defer rows.Close()
@@ -315,8 +405,7 @@ func (s *Service) notifyPostMention(p Post) {
 	for rows.Next() {
 		var n Notification
 		if err = rows.Scan(&n.ID, &n.UserID, &n.IssuedAt); err != nil {
-			log.Println("error", fmt.Errorf("could not scan post mention notification: %w", err))
-			return
+			return fmt.Errorf("could not scan post mention notification: %w", err)
 		}
 
 		n.Actors = actors
@@ -327,25 +416,29 @@ func (s *Service) notifyPostMention(p Post) {
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Println("error", fmt.Errorf("could not iterate post mention notification rows: %w", err))
-		return
+		return fmt.Errorf("could not iterate post mention notification rows: %w", err)
 	}
+
+	return nil
 }
 
-func (s *Service) notifyCommentMention(c Comment) {
+// notifyCommentMention notifies the users mentioned in the comment content,
+// adding the commenter to their unread comment mention notification of the post.
+func (s *Service) notifyCommentMention(ctx context.Context, c Comment) error {
 	mentions := collectMentions(c.Content)
 	if len(mentions) == 0 {
-		return
+		return nil
 	}
 
 	actor := c.User.Username
 
-	rows, err := s.Db.Query(`
+	rows, err := s.Db.QueryContext(ctx, `
 		INSERT INTO notifications (user_id, actors, type, post_id)
 		SELECT users.id, $1, 'comment_mention', $2 FROM users
 		WHERE users.id != $3
 			AND username = ANY($4)
-		ON CONFLICT (user_id, type, post_id, read_at) DO UPDATE SET
+			AND `+notificationAllowedSQL("users.id", "comment_mention", "$3")+`
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO UPDATE SET
 			actors = array_prepend($5, array_remove(notifications.actors, $5)),
 			issued_at = now()
 		RETURNING id, user_id, actors, issued_at`,
@@ -356,8 +449,7 @@ func (s *Service) notifyCommentMention(c Comment) {
 		actor,
 	)
 	if err != nil {
-		log.Println("error", fmt.Errorf("could not insert comment mention notifications: %w", err))
-		return
+		return fmt.Errorf("could not insert comment mention notifications: %w", err)
 	}
 
 	defer rows.Close()
@@ -365,8 +457,7 @@ func (s *Service) notifyCommentMention(c Comment) {
 	for rows.Next() {
 		var n Notification
 		if err = rows.Scan(&n.ID, &n.UserID, pq.Array(&n.Actors), &n.IssuedAt); err != nil {
-			log.Println("error", fmt.Errorf("could not scan comment mention notification: %w", err))
-			return
+			return fmt.Errorf("could not scan comment mention notification: %w", err)
 		}
 
 		n.Type = "comment_mention"
@@ -376,9 +467,192 @@ func (s *Service) notifyCommentMention(c Comment) {
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Println("error", fmt.Errorf("could not iterate comment mention notification rows: %w", err))
+		return fmt.Errorf("could not iterate comment mention notification rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) notifyRepost(postID string, reposter User) {
+	actor := reposter.Username
+	rows, err := s.Db.Query(`
+		INSERT INTO notifications (user_id, actors, type, post_id)
+		SELECT user_id, $1, 'repost', id FROM posts
+		WHERE id = $2
+			AND user_id != $3
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO UPDATE SET
+			actors = array_prepend($4, array_remove(notifications.actors, $4)),
+			issued_at = now()
+		RETURNING id, user_id, actors, issued_at`,
+		pq.Array([]string{actor}),
+		postID,
+		reposter.ID,
+		actor,
+	)
+	if err != nil {
+		log.Println("error", fmt.Errorf("could not insert repost notification: %w", err))
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var n Notification
+		if err = rows.Scan(&n.ID, &n.UserID, pq.Array(&n.Actors), &n.IssuedAt); err != nil {
+			log.Println("error", fmt.Errorf("could not scan repost notification: %w", err))
+			return
+		}
+
+		n.Type = "repost"
+		n.PostID = &postID
+
+		go s.broadcastNotification(n)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("error", fmt.Errorf("could not iterate repost notification rows: %w", err))
+		return
+	}
+}
+
+func (s *Service) notifyPollClosed(postID string) {
+	rows, err := s.Db.Query(`
+		INSERT INTO notifications (user_id, actors, type, post_id)
+		SELECT DISTINCT poll_votes.user_id, ARRAY[users.username], 'poll_closed', poll_votes.post_id
+		FROM poll_votes
+		INNER JOIN posts ON poll_votes.post_id = posts.id
+		INNER JOIN users ON posts.user_id = users.id
+		WHERE poll_votes.post_id = $1
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO NOTHING
+		RETURNING id, user_id, actors, issued_at`,
+		postID,
+	)
+	if err != nil {
+		log.Println("error", fmt.Errorf("could not insert poll closed notifications: %w", err))
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var n Notification
+		if err = rows.Scan(&n.ID, &n.UserID, pq.Array(&n.Actors), &n.IssuedAt); err != nil {
+			log.Println("error", fmt.Errorf("could not scan poll closed notification: %w", err))
+			return
+		}
+
+		n.Type = "poll_closed"
+		n.PostID = &postID
+
+		go s.broadcastNotification(n)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("error", fmt.Errorf("could not iterate poll closed notification rows: %w", err))
+		return
+	}
+}
+
+func (s *Service) notifyPostLike(likerID, postID string) {
+	ctx := context.Background()
+	var actor string
+	query := "SELECT username FROM users WHERE id = $1"
+	if err := s.Db.QueryRowContext(ctx, query, likerID).Scan(&actor); err != nil {
+		log.Println("error", fmt.Errorf("could not query select post like notification actor: %w", err))
+		return
+	}
+
+	var n Notification
+	query = `
+		INSERT INTO notifications (user_id, actors, type, post_id)
+		SELECT user_id, $1, 'post_like', id FROM posts
+		WHERE id = $2
+			AND user_id != $3
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO UPDATE SET
+			actors = array_prepend($4, array_remove(notifications.actors, $4)),
+			issued_at = now()
+		RETURNING id, user_id, actors, issued_at`
+	err := s.Db.QueryRowContext(ctx, query, pq.Array([]string{actor}), postID, likerID, actor).
+		Scan(&n.ID, &n.UserID, pq.Array(&n.Actors), &n.IssuedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Liking your own post.
+		return
+	}
+
+	if err != nil {
+		log.Println("error", fmt.Errorf("could not insert post like notification: %w", err))
+		return
+	}
+
+	n.Type = "post_like"
+	n.PostID = &postID
+
+	go s.broadcastNotification(n)
+}
+
+func (s *Service) notifyCommentLike(likerID, commentID string) {
+	ctx := context.Background()
+	var actor string
+	query := "SELECT username FROM users WHERE id = $1"
+	if err := s.Db.QueryRowContext(ctx, query, likerID).Scan(&actor); err != nil {
+		log.Println("error", fmt.Errorf("could not query select comment like notification actor: %w", err))
+		return
+	}
+
+	var n Notification
+	var postID string
+	query = `
+		INSERT INTO notifications (user_id, actors, type, post_id, comment_id)
+		SELECT user_id, $1, 'comment_like', post_id, id FROM comments
+		WHERE id = $2
+			AND user_id != $3
+		ON CONFLICT (user_id, type, post_id, comment_id, read_at) DO UPDATE SET
+			actors = array_prepend($4, array_remove(notifications.actors, $4)),
+			issued_at = now()
+		RETURNING id, user_id, actors, post_id, issued_at`
+	err := s.Db.QueryRowContext(ctx, query, pq.Array([]string{actor}), commentID, likerID, actor).
+		Scan(&n.ID, &n.UserID, pq.Array(&n.Actors), &postID, &n.IssuedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Liking your own comment.
+		return
+	}
+
+	if err != nil {
+		log.Println("error", fmt.Errorf("could not insert comment like notification: %w", err))
 		return
 	}
+
+	n.Type = "comment_like"
+	n.PostID = &postID
+	n.CommentID = &commentID
+
+	go s.broadcastNotification(n)
+}
+
+// removeNotificationActor takes the given actor out of the unread notification of the recipient
+// of the given type about a post, or a comment of it, deleting it once no actors remain.
+// Usernames never change, so the current one of the actor is the one notified with.
+func removeNotificationActor(ctx context.Context, tx *sql.Tx, recipientID, actorID, typ, postID string, commentID *string) error {
+	query := `
+		UPDATE notifications SET actors = array_remove(actors, (SELECT username FROM users WHERE id = $1))
+		WHERE user_id = $2 AND type = $3 AND post_id = $4 AND comment_id IS NOT DISTINCT FROM $5 AND read_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, actorID, recipientID, typ, postID, commentID); err != nil {
+		return fmt.Errorf("could not remove %s notification actor: %w", typ, err)
+	}
+
+	query = `
+		DELETE FROM notifications
+		WHERE user_id = $1
+			AND type = $2
+			AND post_id = $3
+			AND comment_id IS NOT DISTINCT FROM $4
+			AND read_at IS NULL
+			AND cardinality(actors) = 0`
+	if _, err := tx.ExecContext(ctx, query, recipientID, typ, postID, commentID); err != nil {
+		return fmt.Errorf("could not delete empty %s notification: %w", typ, err)
+	}
+
+	return nil
 }
 
 // NotificationStream to receive notifications in realtime.
@@ -401,6 +675,16 @@ func (s *Service) NotificationStream(ctx context.Context) (<-chan Notification,
 	return nn, nil
 }
 
+// broadcastNotification to the connections of its user,
+// or to their push subscriptions when they have none.
 func (s *Service) broadcastNotification(n Notification) {
-	s.BrokerRepository.notificationBroker.Notifier <- n
+	broker := s.BrokerRepository.notificationBroker
+	if broker.connected(n.UserID) {
+		broker.Notifier <- n
+		return
+	}
+
+	if err := s.pushNotification(context.Background(), n); err != nil {
+		log.Println("error", fmt.Errorf("could not push notification: %w", err))
+	}
 }
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErrInvalidNotificationType denotes a notification type that has no settings.
+var ErrInvalidNotificationType = InvalidArgumentError("invalid notification type")
+
+// notificationSettingTypes are the notification types users can turn off.
+var notificationSettingTypes = []string{"follow", "comment", "post_mention", "comment_mention"}
+
+// NotificationSetting of a notification type.
+// Without settings, a type is enabled for everyone.
+type NotificationSetting struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	// OnlyFollowing restricts the notifications to the ones caused by users the recipient follows.
+	OnlyFollowing bool `json:"onlyFollowing"`
+}
+
+// notificationAllowedSQL is the condition for the recipient user placeholder
+// to get a notification of the given type caused by the actor user placeholder,
+// according to the recipient notification settings.
+// Recipients that turned the type off, or restricted it to the users they follow, are left out.
+func notificationAllowedSQL(recipient, typ, actor string) string {
+	return `NOT EXISTS (
+		SELECT 1 FROM notification_settings
+		WHERE notification_settings.user_id = ` + recipient + `
+			AND notification_settings.type = '` + typ + `'
+			AND (
+				NOT notification_settings.enabled
+				OR (notification_settings.only_following AND NOT EXISTS (
+					SELECT 1 FROM follows WHERE follows.follower_id = ` + recipient + ` AND follows.followee_id = ` + actor + `
+				))
+			)
+	)`
+}
+
+// NotificationSettings of the authenticated user, one per notification type.
+func (s *Service) NotificationSettings(ctx context.Context) ([]NotificationSetting, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	query := "SELECT type, enabled, only_following FROM notification_settings WHERE user_id = $1"
+	rows, err := s.Db.QueryContext(ctx, query, uid)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select notification settings: %w", err)
+	}
+
+	defer rows.Close()
+
+	settings := map[string]NotificationSetting{}
+	for rows.Next() {
+		var setting NotificationSetting
+		if err = rows.Scan(&setting.Type, &setting.Enabled, &setting.OnlyFollowing); err != nil {
+			return nil, fmt.Errorf("could not scan notification setting: %w", err)
+		}
+
+		settings[setting.Type] = setting
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate notification setting rows: %w", err)
+	}
+
+	out := make([]NotificationSetting, len(notificationSettingTypes))
+	for i, typ := range notificationSettingTypes {
+		setting, ok := settings[typ]
+		if !ok {
+			setting = NotificationSetting{Type: typ, Enabled: true}
+		}
+		out[i] = setting
+	}
+	return out, nil
+}
+
+// UpdateNotificationSettings of the authenticated user.
+// Types left out keep their settings.
+func (s *Service) UpdateNotificationSettings(ctx context.Context, in []NotificationSetting) ([]NotificationSetting, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	for _, setting := range in {
+		if !validNotificationSettingType(setting.Type) {
+			return nil, ErrInvalidNotificationType
+		}
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	query := `
+		INSERT INTO notification_settings (user_id, type, enabled, only_following) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, type) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			only_following = EXCLUDED.only_following`
+	for _, setting := range in {
+		if _, err = tx.ExecContext(ctx, query, uid, setting.Type, setting.Enabled, setting.OnlyFollowing); err != nil {
+			tx.Rollback()
+			if isForeignKeyViolation(err) {
+				return nil, ErrUserGone
+			}
+			return nil, fmt.Errorf("could not upsert notification setting: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit to update notification settings: %w", err)
+	}
+
+	return s.NotificationSettings(ctx)
+}
+
+func validNotificationSettingType(typ string) bool {
+	for _, t := range notificationSettingTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
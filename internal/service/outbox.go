@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	outboxPostCreated    = "post_created"
+	outboxCommentCreated = "comment_created"
+	outboxFollowed       = "followed"
+	outboxDelivery       = "delivery"
+	outboxPush           = "push"
+)
+
+const (
+	// outboxJobTimeout is how long a job can run before another worker may claim it again.
+	outboxJobTimeout = time.Minute
+	// outboxMaxAttempts before a job is dead-lettered.
+	outboxMaxAttempts = 8
+	// outboxMaxBackoff between attempts of a failing job.
+	outboxMaxBackoff = time.Hour
+)
+
+var (
+	// ErrInvalidOutboxJobID denotes an invalid outbox job ID; that is not uuid.
+	ErrInvalidOutboxJobID = InvalidArgumentError("invalid outbox job ID")
+	// ErrOutboxJobNotFound denotes a not found or not dead outbox job.
+	ErrOutboxJobNotFound = NotFoundError("outbox job not found")
+	// ErrAdminOnly denotes an attempt to use an admin feature by a regular user.
+	ErrAdminOnly = PermissionDeniedError("admin only")
+)
+
+// OutboxJob is a side effect recorded within the transaction of the change that causes it
+// and run by the outbox workers after commit. Jobs are deleted once they succeed.
+type OutboxJob struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError *string         `json:"lastError"`
+	RunAt     time.Time       `json:"runAt"`
+	CreatedAt time.Time       `json:"createdAt"`
+	DeadAt    *time.Time      `json:"deadAt"`
+}
+
+type OutboxJobs []OutboxJob
+
+func (jj OutboxJobs) EndCursor() *string {
+	if len(jj) == 0 {
+		return nil
+	}
+
+	last := jj[len(jj)-1]
+	return ptrString(encodeCursor(last.ID, last.CreatedAt))
+}
+
+type postCreatedJob struct {
+	PostID string `json:"postID"`
+	UserID string `json:"userID"`
+}
+
+type commentCreatedJob struct {
+	CommentID string `json:"commentID"`
+}
+
+type followedJob struct {
+	FollowerID       string `json:"followerID"`
+	FolloweeID       string `json:"followeeID"`
+	FolloweeUsername string `json:"followeeUsername"`
+}
+
+// deliveryJob posts a signed activity to a remote inbox.
+type deliveryJob struct {
+	SenderID string          `json:"senderID"`
+	Inbox    string          `json:"inbox"`
+	Activity json.RawMessage `json:"activity"`
+}
+
+// pushJob sends an encrypted payload to the push service of a push subscription.
+type pushJob struct {
+	SubscriptionID string          `json:"subscriptionID"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// enqueue an outbox job within the given transaction.
+// Call wakeOutbox after commit so it runs right away.
+// The caller is responsible of rolling back on error.
+func enqueue(ctx context.Context, tx *sql.Tx, kind string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not json marshal %s outbox job payload: %w", kind, err)
+	}
+
+	query := "INSERT INTO outbox (kind, payload) VALUES ($1, $2)"
+	if _, err = tx.ExecContext(ctx, query, kind, string(b)); err != nil {
+		return fmt.Errorf("could not insert %s outbox job: %w", kind, err)
+	}
+
+	return nil
+}
+
+// wakeOutbox signals an idle outbox worker that there are new jobs.
+func (s *Service) wakeOutbox() {
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+		// A wake up is already pending.
+	}
+}
+
+// ProcessOutbox runs the given number of outbox workers, forever.
+// Idle workers look for due jobs every interval or when woken up.
+func (s *Service) ProcessOutbox(workers int, interval time.Duration) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.outboxWorker(interval)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Service) outboxWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.outboxWake:
+		}
+
+		for {
+			ran, err := s.runOutboxJob(context.Background())
+			if err != nil {
+				log.Println("error", fmt.Errorf("could not run outbox job: %w", err))
+				break
+			}
+
+			if !ran {
+				break
+			}
+		}
+	}
+}
+
+// runOutboxJob claims the next due job and runs it.
+// The claim lasts outboxJobTimeout, so jobs of a crashed worker are retried.
+// Failing jobs are retried with exponential backoff until dead-lettered.
+// Job handlers must be idempotent.
+func (s *Service) runOutboxJob(ctx context.Context) (bool, error) {
+	var job OutboxJob
+	query := `
+		UPDATE outbox SET attempts = attempts + 1, run_at = now() + $1 * INTERVAL '1 second'
+		WHERE id = (
+			SELECT id FROM outbox
+			WHERE dead_at IS NULL AND run_at <= now()
+			ORDER BY run_at ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts`
+	err := s.Db.QueryRowContext(ctx, query, int(outboxJobTimeout/time.Second)).
+		Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("could not claim outbox job: %w", err)
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, outboxJobTimeout)
+	jobErr := s.handleOutboxJob(jobCtx, job)
+	cancel()
+
+	if jobErr == nil {
+		if _, err = s.Db.ExecContext(ctx, "DELETE FROM outbox WHERE id = $1", job.ID); err != nil {
+			return true, fmt.Errorf("could not delete done outbox job: %w", err)
+		}
+
+		return true, nil
+	}
+
+	log.Println("error", fmt.Errorf("outbox job %s of kind %s failed on attempt %d: %w", job.ID, job.Kind, job.Attempts, jobErr))
+
+	query = `
+		UPDATE outbox SET last_error = $2
+		, run_at = now() + $3 * INTERVAL '1 second'
+		, dead_at = CASE WHEN attempts >= $4 THEN now() END
+		WHERE id = $1`
+	backoff := int(outboxBackoff(job.Attempts) / time.Second)
+	if _, err = s.Db.ExecContext(ctx, query, job.ID, jobErr.Error(), backoff, outboxMaxAttempts); err != nil {
+		return true, fmt.Errorf("could not update failed outbox job: %w", err)
+	}
+
+	return true, nil
+}
+
+// outboxBackoff before the next attempt of a job that failed the given number of times.
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Second * 5
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+
+	return d
+}
+
+func (s *Service) handleOutboxJob(ctx context.Context, job OutboxJob) error {
+	switch job.Kind {
+	case outboxPostCreated:
+		var in postCreatedJob
+		if err := json.Unmarshal(job.Payload, &in); err != nil {
+			return fmt.Errorf("could not json unmarshal payload: %w", err)
+		}
+
+		return s.postCreated(ctx, in.PostID, in.UserID)
+	case outboxCommentCreated:
+		var in commentCreatedJob
+		if err := json.Unmarshal(job.Payload, &in); err != nil {
+			return fmt.Errorf("could not json unmarshal payload: %w", err)
+		}
+
+		return s.commentCreated(ctx, in.CommentID)
+	case outboxFollowed:
+		var in followedJob
+		if err := json.Unmarshal(job.Payload, &in); err != nil {
+			return fmt.Errorf("could not json unmarshal payload: %w", err)
+		}
+
+		if err := s.notifyFollow(ctx, in.FollowerID, in.FolloweeID); err != nil {
+			return err
+		}
+
+		return s.backfillTimeline(ctx, in.FollowerID, in.FolloweeID, in.FolloweeUsername)
+	case outboxDelivery:
+		var in deliveryJob
+		if err := json.Unmarshal(job.Payload, &in); err != nil {
+			return fmt.Errorf("could not json unmarshal payload: %w", err)
+		}
+
+		return s.deliver(ctx, in)
+	case outboxPush:
+		var in pushJob
+		if err := json.Unmarshal(job.Payload, &in); err != nil {
+			return fmt.Errorf("could not json unmarshal payload: %w", err)
+		}
+
+		return s.push(ctx, in)
+	}
+
+	return fmt.Errorf("unknown outbox job kind %q", job.Kind)
+}
+
+// checkAdmin denies access to non admin users.
+func (s *Service) checkAdmin(ctx context.Context) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	var admin bool
+	err := s.Db.QueryRowContext(ctx, "SELECT admin FROM users WHERE id = $1", uid).Scan(&admin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserGone
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select user admin: %w", err)
+	}
+
+	if !admin {
+		return ErrAdminOnly
+	}
+
+	return nil
+}
+
+// StuckOutboxJobs lists the outbox jobs that failed at least once, dead-lettered or not,
+// most recent first and with backward pagination. Admin only.
+func (s *Service) StuckOutboxJobs(ctx context.Context, last uint64, before *string) (OutboxJobs, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	var beforeJobID string
+	var beforeCreatedAt time.Time
+	if before != nil {
+		var err error
+		beforeJobID, beforeCreatedAt, err = decodeCursor(*before)
+		if err != nil || !reUUID.MatchString(beforeJobID) {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	last = normalizePageSize(last)
+	query, args, err := buildQuery(`
+		SELECT id
+		, kind
+		, payload
+		, attempts
+		, last_error
+		, run_at
+		, created_at
+		, dead_at
+		FROM outbox
+		WHERE last_error IS NOT NULL
+		{{ if and .beforeJobID .beforeCreatedAt }}
+			AND created_at <= @beforeCreatedAt
+			AND (id != @beforeJobID OR created_at < @beforeCreatedAt)
+		{{ end }}
+		ORDER BY created_at DESC, id ASC
+		LIMIT @last`, map[string]interface{}{
+		"last":            last,
+		"beforeJobID":     beforeJobID,
+		"beforeCreatedAt": beforeCreatedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build stuck outbox jobs sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select stuck outbox jobs: %w", err)
+	}
+
+	defer rows.Close()
+
+	var jj OutboxJobs
+	for rows.Next() {
+		var j OutboxJob
+		if err = rows.Scan(
+			&j.ID,
+			&j.Kind,
+			&j.Payload,
+			&j.Attempts,
+			&j.LastError,
+			&j.RunAt,
+			&j.CreatedAt,
+			&j.DeadAt,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan outbox job: %w", err)
+		}
+
+		jj = append(jj, j)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate outbox job rows: %w", err)
+	}
+
+	return jj, nil
+}
+
+// RetryOutboxJob brings a dead-lettered outbox job back to life. Admin only.
+func (s *Service) RetryOutboxJob(ctx context.Context, jobID string) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+
+	if !reUUID.MatchString(jobID) {
+		return ErrInvalidOutboxJobID
+	}
+
+	query := "UPDATE outbox SET attempts = 0, run_at = now(), dead_at = NULL WHERE id = $1 AND dead_at IS NOT NULL"
+	res, err := s.Db.ExecContext(ctx, query, jobID)
+	if err != nil {
+		return fmt.Errorf("could not update and retry outbox job: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrOutboxJobNotFound
+	}
+
+	s.wakeOutbox()
+
+	return nil
+}
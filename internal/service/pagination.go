@@ -0,0 +1,83 @@
+package service
+
+// ErrInvalidPage denotes a page asked both before and after a cursor, or with two sizes.
+var ErrInvalidPage = InvalidArgumentError("invalid page")
+
+// PageArgs selects a page of a list.
+// Last and Before page down the list, from the end cursor of the previous page.
+// First and After page up the list, from the start cursor of the previous page,
+// as to get the items newer than the ones already fetched.
+// Either way, items come in list order.
+type PageArgs struct {
+	First  uint64
+	After  *string
+	Last   uint64
+	Before *string
+}
+
+// PageInfo tells whether there are more items around a page.
+// Only the side the page went towards is checked; the other one is null, as unknown.
+type PageInfo struct {
+	// HasNextPage reports more items down the list, past the end cursor.
+	HasNextPage *bool `json:"hasNextPage"`
+	// HasPreviousPage reports more items up the list, before the start cursor.
+	HasPreviousPage *bool `json:"hasPreviousPage"`
+}
+
+func (page PageArgs) validate() error {
+	if (page.After != nil && page.Before != nil) || (page.First != 0 && page.Last != 0) {
+		return ErrInvalidPage
+	}
+
+	return nil
+}
+
+// forward reports whether the page goes up the list.
+func (page PageArgs) forward() bool {
+	return page.After != nil
+}
+
+// cursor the page starts from, if any.
+func (page PageArgs) cursor() *string {
+	if page.forward() {
+		return page.After
+	}
+
+	return page.Before
+}
+
+// limit of the items to query.
+// It is one more than the page size, to tell whether there are more.
+func (page PageArgs) limit() uint64 {
+	size := page.Last
+	if page.First != 0 {
+		size = page.First
+	}
+
+	return normalizePageSize(size) + 1
+}
+
+// paginate trims the items queried with the page limit
+// and puts the ones of a forward page, queried in reverse, back in list order.
+// A first page, without cursor, has nothing before it.
+func paginate[T any](items []T, page PageArgs) ([]T, PageInfo) {
+	size := int(page.limit() - 1)
+	more := len(items) > size
+	if more {
+		items = items[:size]
+	}
+
+	if !page.forward() {
+		info := PageInfo{HasNextPage: &more}
+		if page.Before == nil {
+			info.HasPreviousPage = ptrBool(false)
+		}
+		return items, info
+	}
+
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+
+	return items, PageInfo{HasPreviousPage: &more}
+}
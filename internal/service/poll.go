@@ -0,0 +1,453 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	pollMinOptions          = 2
+	pollMaxOptions          = 4
+	pollOptionTextMaxLength = 64
+	pollMaxDuration         = time.Hour * 24 * 30
+	pollsCloseBatch         = 100
+)
+
+var (
+	// ErrInvalidPoll denotes an invalid poll; with a wrong number of options,
+	// empty or repeated options, or a closing time not in the future.
+	ErrInvalidPoll = InvalidArgumentError("invalid poll")
+	// ErrInvalidPollVote denotes an invalid vote; with no options,
+	// more than one option on a single choice poll, or options not from the poll.
+	ErrInvalidPollVote = InvalidArgumentError("invalid poll vote")
+	// ErrPollNotFound denotes a not found poll.
+	ErrPollNotFound = NotFoundError("poll not found")
+	// ErrPollClosed denotes a vote on an already closed poll.
+	ErrPollClosed = PermissionDeniedError("poll closed")
+	// ErrAlreadyVoted denotes a second vote on the same poll.
+	ErrAlreadyVoted = AlreadyExistsError("already voted")
+)
+
+// Poll model.
+type Poll struct {
+	PostID      string       `json:"postID"`
+	Options     []PollOption `json:"options"`
+	Multiple    bool         `json:"multiple"`
+	HideResults bool         `json:"hideResults"`
+	// VotersCount is nil while the results are hidden.
+	VotersCount *int      `json:"votersCount"`
+	ClosesAt    time.Time `json:"closesAt"`
+	Closed      bool      `json:"closed"`
+	// OwnVotes are the option IDs the authenticated user voted for.
+	OwnVotes []string `json:"ownVotes"`
+}
+
+// PollOption model.
+type PollOption struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	// VotesCount is nil while the results are hidden.
+	VotesCount *int `json:"votesCount"`
+}
+
+// PollInput request to attach a poll to a new post.
+type PollInput struct {
+	Options     []string
+	Multiple    bool
+	HideResults bool
+	ClosesAt    time.Time
+}
+
+func (in *PollInput) normalize(publishAt *time.Time) error {
+	if len(in.Options) < pollMinOptions || len(in.Options) > pollMaxOptions {
+		return ErrInvalidPoll
+	}
+
+	seen := map[string]struct{}{}
+	for i, option := range in.Options {
+		option = smartTrim(option)
+		if option == "" || utf8.RuneCountInString(option) > pollOptionTextMaxLength {
+			return ErrInvalidPoll
+		}
+
+		key := strings.ToLower(option)
+		if _, ok := seen[key]; ok {
+			return ErrInvalidPoll
+		}
+
+		seen[key] = struct{}{}
+		in.Options[i] = option
+	}
+
+	opensAt := time.Now()
+	if publishAt != nil {
+		opensAt = *publishAt
+	}
+
+	if !in.ClosesAt.After(opensAt) || in.ClosesAt.After(opensAt.Add(pollMaxDuration)) {
+		return ErrInvalidPoll
+	}
+
+	return nil
+}
+
+// hideCounts blanks out the vote counts while results are hidden to the viewer.
+func (p *Poll) hideCounts() {
+	if !p.HideResults || p.Closed || len(p.OwnVotes) != 0 {
+		return
+	}
+
+	p.VotersCount = nil
+	for i := range p.Options {
+		p.Options[i].VotesCount = nil
+	}
+}
+
+func insertPoll(ctx context.Context, tx *sql.Tx, postID string, in PollInput) (*Poll, error) {
+	query := `
+		INSERT INTO polls (post_id, multiple, hide_results, closes_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, query, postID, in.Multiple, in.HideResults, in.ClosesAt); err != nil {
+		return nil, fmt.Errorf("could not insert poll: %w", err)
+	}
+
+	poll := &Poll{
+		PostID:      postID,
+		Multiple:    in.Multiple,
+		HideResults: in.HideResults,
+		VotersCount: new(int),
+		ClosesAt:    in.ClosesAt,
+		OwnVotes:    []string{},
+	}
+	query = "INSERT INTO poll_options (post_id, position, text) VALUES ($1, $2, $3) RETURNING id"
+	for i, text := range in.Options {
+		option := PollOption{Text: text, VotesCount: new(int)}
+		if err := tx.QueryRowContext(ctx, query, postID, i, text).Scan(&option.ID); err != nil {
+			return nil, fmt.Errorf("could not insert poll option: %w", err)
+		}
+
+		poll.Options = append(poll.Options, option)
+	}
+
+	return poll, nil
+}
+
+// polls fetches the polls of the given posts, keyed by post ID,
+// as seen by the user with the given ID, if any.
+func (s *Service) polls(ctx context.Context, uid string, postIDs []string) (map[string]*Poll, error) {
+	polls := map[string]*Poll{}
+	if len(postIDs) == 0 {
+		return polls, nil
+	}
+
+	query, args, err := buildQuery(`
+		SELECT polls.post_id
+		, polls.multiple
+		, polls.hide_results
+		, polls.voters_count
+		, polls.closes_at
+		, poll_options.id
+		, poll_options.text
+		, poll_options.votes_count
+		{{ if .uid }}
+		, votes.user_id IS NOT NULL AS voted
+		{{ end }}
+		FROM polls
+		INNER JOIN poll_options ON poll_options.post_id = polls.post_id
+		{{ if .uid }}
+		LEFT JOIN poll_votes AS votes
+			ON votes.option_id = poll_options.id AND votes.user_id = @uid
+		{{ end }}
+		WHERE polls.post_id = ANY(@postIDs)
+		ORDER BY poll_options.position ASC`, map[string]interface{}{
+		"uid":     uid,
+		"postIDs": pq.Array(postIDs),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build polls sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select polls: %w", err)
+	}
+
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		var p Poll
+		var votersCount, votesCount int
+		var option PollOption
+		var voted bool
+		dest := []interface{}{
+			&p.PostID,
+			&p.Multiple,
+			&p.HideResults,
+			&votersCount,
+			&p.ClosesAt,
+			&option.ID,
+			&option.Text,
+			&votesCount,
+		}
+		if uid != "" {
+			dest = append(dest, &voted)
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("could not scan poll: %w", err)
+		}
+
+		poll, ok := polls[p.PostID]
+		if !ok {
+			p.VotersCount = &votersCount
+			p.Closed = !p.ClosesAt.After(now)
+			p.OwnVotes = []string{}
+			poll = &p
+			polls[p.PostID] = poll
+		}
+
+		option.VotesCount = &votesCount
+		poll.Options = append(poll.Options, option)
+		if voted {
+			poll.OwnVotes = append(poll.OwnVotes, option.ID)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate poll rows: %w", err)
+	}
+
+	for _, poll := range polls {
+		poll.hideCounts()
+	}
+
+	return polls, nil
+}
+
+// attachPolls sets the poll of each of the given posts that has one.
+func (s *Service) attachPolls(ctx context.Context, pp ...*Post) error {
+	uid, _ := ctx.Value(KeyAuthUserID).(string)
+	postIDs := make([]string, len(pp))
+	for i, p := range pp {
+		postIDs[i] = p.ID
+	}
+
+	polls, err := s.polls(ctx, uid, postIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pp {
+		p.Poll = polls[p.ID]
+	}
+
+	return nil
+}
+
+// VotePoll casts the authenticated user vote on the poll of a post.
+// Votes are final.
+func (s *Service) VotePoll(ctx context.Context, postID string, optionIDs []string) (Poll, error) {
+	var poll Poll
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return poll, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(postID) {
+		return poll, ErrInvalidPostID
+	}
+
+	if len(optionIDs) == 0 || len(optionIDs) > pollMaxOptions {
+		return poll, ErrInvalidPollVote
+	}
+
+	seen := map[string]struct{}{}
+	for _, id := range optionIDs {
+		if _, ok := seen[id]; ok || !reUUID.MatchString(id) {
+			return poll, ErrInvalidPollVote
+		}
+		seen[id] = struct{}{}
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return poll, fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	var multiple bool
+	var closesAt time.Time
+	query, args, err := buildQuery(`
+		SELECT polls.multiple, polls.closes_at FROM polls
+		INNER JOIN posts ON polls.post_id = posts.id
+		WHERE polls.post_id = @postID AND posts.publish_at IS NULL
+		AND `+visiblePostSQL("posts")+`
+		FOR UPDATE OF polls`, map[string]interface{}{
+		"auth":   true,
+		"uid":    uid,
+		"postID": postID,
+	})
+	if err != nil {
+		tx.Rollback()
+		return poll, fmt.Errorf("could not build poll sql query: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&multiple, &closesAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return poll, ErrPollNotFound
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return poll, fmt.Errorf("could not query select poll: %w", err)
+	}
+
+	if !closesAt.After(time.Now()) {
+		tx.Rollback()
+		return poll, ErrPollClosed
+	}
+
+	if !multiple && len(optionIDs) != 1 {
+		tx.Rollback()
+		return poll, ErrInvalidPollVote
+	}
+
+	var voted bool
+	query = "SELECT EXISTS (SELECT 1 FROM poll_votes WHERE post_id = $1 AND user_id = $2)"
+	if err = tx.QueryRowContext(ctx, query, postID, uid).Scan(&voted); err != nil {
+		tx.Rollback()
+		return poll, fmt.Errorf("could not query select poll vote existence: %w", err)
+	}
+
+	if voted {
+		tx.Rollback()
+		return poll, ErrAlreadyVoted
+	}
+
+	query = `
+		INSERT INTO poll_votes (user_id, option_id, post_id)
+		SELECT $1, id, post_id FROM poll_options
+		WHERE post_id = $2 AND id = ANY($3)`
+	res, err := tx.ExecContext(ctx, query, uid, postID, pq.Array(optionIDs))
+	if err != nil {
+		tx.Rollback()
+		return poll, fmt.Errorf("could not insert poll votes: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n != int64(len(optionIDs)) {
+		tx.Rollback()
+		return poll, ErrInvalidPollVote
+	}
+
+	query = "UPDATE poll_options SET votes_count = votes_count + 1 WHERE post_id = $1 AND id = ANY($2)"
+	if _, err = tx.ExecContext(ctx, query, postID, pq.Array(optionIDs)); err != nil {
+		tx.Rollback()
+		return poll, fmt.Errorf("could not increment poll option votes count: %w", err)
+	}
+
+	query = "UPDATE polls SET voters_count = voters_count + 1 WHERE post_id = $1"
+	if _, err = tx.ExecContext(ctx, query, postID); err != nil {
+		tx.Rollback()
+		return poll, fmt.Errorf("could not increment poll voters count: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return poll, fmt.Errorf("could not commit to vote poll: %w", err)
+	}
+
+	polls, err := s.polls(ctx, uid, []string{postID})
+	if err != nil {
+		return poll, err
+	}
+
+	poll = *polls[postID]
+
+	go s.broadcastPoll(postID, uid)
+
+	return poll, nil
+}
+
+// broadcastPoll pushes the current poll tallies to the post stream.
+// Clients don't identify themselves to the broker by vote,
+// so hidden results stay hidden until the poll closes.
+func (s *Service) broadcastPoll(postID, actorID string) {
+	polls, err := s.polls(context.Background(), "", []string{postID})
+	if err != nil {
+		log.Println("error", fmt.Errorf("could not fetch poll to broadcast: %w", err))
+		return
+	}
+
+	poll, ok := polls[postID]
+	if !ok {
+		return
+	}
+
+	s.broadcastPostEvent(PostEvent{Type: "poll", PostID: postID, ActorID: actorID, Data: poll})
+}
+
+// ClosePolls notifies voters of the polls that closed since the last run, every interval, forever.
+// Polls are claimed with SKIP LOCKED so several server instances can run it at the same time.
+func (s *Service) ClosePolls(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			n, err := s.closeDuePolls(context.Background())
+			if err != nil {
+				log.Println("error", fmt.Errorf("could not close polls: %w", err))
+				break
+			}
+
+			if n < pollsCloseBatch {
+				break
+			}
+		}
+	}
+}
+
+func (s *Service) closeDuePolls(ctx context.Context) (int, error) {
+	query := `
+		UPDATE polls SET closed_at = now()
+		WHERE post_id IN (
+			SELECT post_id FROM polls
+			WHERE closed_at IS NULL AND closes_at <= now()
+			ORDER BY closes_at ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING post_id`
+	rows, err := s.Db.QueryContext(ctx, query, pollsCloseBatch)
+	if err != nil {
+		return 0, fmt.Errorf("could not update and close polls: %w", err)
+	}
+
+	defer rows.Close()
+
+	var postIDs []string
+	for rows.Next() {
+		var postID string
+		if err = rows.Scan(&postID); err != nil {
+			return 0, fmt.Errorf("could not scan closed poll: %w", err)
+		}
+
+		postIDs = append(postIDs, postID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not iterate closed poll rows: %w", err)
+	}
+
+	for _, postID := range postIDs {
+		go s.notifyPollClosed(postID)
+		go s.broadcastPoll(postID, "")
+	}
+
+	return len(postIDs), nil
+}
@@ -31,25 +31,150 @@ var (
 	// not a valid emoji, or invalid reaction image URL.
 	ErrInvalidReaction  = InvalidArgumentError("invalid reaction")
 	ErrUpdatePostDenied = PermissionDeniedError("update post denied")
+	// ErrInvalidQuoteID denotes an invalid quoted post ID; that is not uuid.
+	ErrInvalidQuoteID = InvalidArgumentError("invalid quote ID")
+	// ErrInvalidReplyPolicy denotes an unknown reply policy.
+	ErrInvalidReplyPolicy = InvalidArgumentError("invalid reply policy")
+	// ErrInvalidVisibility denotes an unknown post visibility.
+	ErrInvalidVisibility = InvalidArgumentError("invalid visibility")
 )
 
+// Visibility of a post; that is who may read it besides its author.
+type Visibility string
+
+const (
+	VisibilityPublic    Visibility = "public"
+	VisibilityFollowers Visibility = "followers"
+	VisibilityMentioned Visibility = "mentioned"
+)
+
+func (v Visibility) valid() bool {
+	switch v {
+	case VisibilityPublic, VisibilityFollowers, VisibilityMentioned:
+		return true
+	}
+	return false
+}
+
+// visiblePostSQL is the condition for the post with the given table alias
+// to be readable by the @uid user, or by anyone when not .auth.
+// It is meant to be embedded in buildQuery templates.
+// Mentioned users can read the post whatever its visibility.
+func visiblePostSQL(alias string) string {
+	return `({{ if .auth }}` + postVisibleToSQL(alias, "@uid") + `{{ else }}` + alias + `.visibility = 'public'{{ end }})`
+}
+
+// postVisibleToSQL is the condition for the post with the given table alias
+// to be readable by the user of the given SQL expression, like a column.
+func postVisibleToSQL(alias, viewer string) string {
+	cond := strings.ReplaceAll(`(posts.visibility = 'public'
+		OR posts.user_id = :viewer
+		OR (posts.visibility = 'followers' AND EXISTS (
+			SELECT 1 FROM follows WHERE follows.follower_id = :viewer AND follows.followee_id = posts.user_id
+		))
+		OR EXISTS (
+			SELECT 1 FROM post_mentions WHERE post_mentions.post_id = posts.id AND post_mentions.user_id = :viewer
+		))`, "posts.", alias+".")
+	return strings.ReplaceAll(cond, ":viewer", viewer)
+}
+
+// ReplyPolicy of a post; that is who may comment on it besides its author.
+type ReplyPolicy string
+
+const (
+	ReplyPolicyEveryone  ReplyPolicy = "everyone"
+	ReplyPolicyFollowers ReplyPolicy = "followers"
+	ReplyPolicyFollowing ReplyPolicy = "following"
+	ReplyPolicyMentioned ReplyPolicy = "mentioned"
+	ReplyPolicyNobody    ReplyPolicy = "nobody"
+)
+
+func (p ReplyPolicy) valid() bool {
+	switch p {
+	case ReplyPolicyEveryone, ReplyPolicyFollowers, ReplyPolicyFollowing, ReplyPolicyMentioned, ReplyPolicyNobody:
+		return true
+	}
+	return false
+}
+
+// UpdatePostInput request. Nil fields are left untouched.
+type UpdatePostInput struct {
+	ReplyPolicy *ReplyPolicy
+}
+
 type Post struct {
-	ID            string    `json:"id"`
-	UserID        string    `json:"-"`
-	Content       string    `json:"content"`
-	SpoilerOf     *string   `json:"spoilerOf"`
-	NSFW          bool      `json:"nsfw"`
-	LikesCount    int       `json:"likesCount"`
-	CommentsCount int       `json:"commentsCount"`
-	CreatedAt     time.Time `json:"createdAt"`
-	User          *User     `json:"user,omitempty"`
-	Mine          bool      `json:"mine"`
-	Liked         bool      `json:"liked"`
-	Subscribed    bool      `json:"subscribed"`
+	ID            string       `json:"id"`
+	UserID        string       `json:"-"`
+	Content       string       `json:"content"`
+	ContentHTML   string       `json:"contentHTML"`
+	Entities      []Entity     `json:"entities"`
+	SpoilerOf     *string      `json:"spoilerOf"`
+	NSFW          bool         `json:"nsfw"`
+	LikesCount    int          `json:"likesCount"`
+	CommentsCount int          `json:"commentsCount"`
+	RepostsCount  int          `json:"repostsCount"`
+	QuotesCount   int          `json:"quotesCount"`
+	ReplyPolicy   ReplyPolicy  `json:"replyPolicy"`
+	Visibility    Visibility   `json:"visibility"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	PublishAt     *time.Time   `json:"publishAt,omitempty"`
+	QuoteID       *string      `json:"-"`
+	Quote         *Post        `json:"quote,omitempty"`
+	Poll          *Poll        `json:"poll,omitempty"`
+	LinkPreview   *LinkPreview `json:"linkPreview,omitempty"`
+	User          *User        `json:"user,omitempty"`
+	Mine          bool         `json:"mine"`
+	Liked         bool         `json:"liked"`
+	Subscribed    bool         `json:"subscribed"`
+	Reposted      bool         `json:"reposted"`
+	Bookmarked    bool         `json:"bookmarked"`
+}
+
+// quoteScanner holds the nullable columns of a quoted post
+// selected through a LEFT JOIN on posts.quote_id.
+type quoteScanner struct {
+	id        sql.NullString
+	content   sql.NullString
+	spoilerOf *string
+	nsfw      sql.NullBool
+	createdAt sql.NullTime
+	username  sql.NullString
+	avatar    sql.NullString
+}
+
+func (q *quoteScanner) dest() []interface{} {
+	return []interface{}{&q.id, &q.content, &q.spoilerOf, &q.nsfw, &q.createdAt, &q.username, &q.avatar}
+}
+
+func (s *Service) quotedPost(q quoteScanner) *Post {
+	if !q.id.Valid {
+		return nil
+	}
+
+	return &Post{
+		ID:        q.id.String,
+		Content:   q.content.String,
+		SpoilerOf: q.spoilerOf,
+		NSFW:      q.nsfw.Bool,
+		CreatedAt: q.createdAt.Time,
+		User: &User{
+			Username:  q.username.String,
+			AvatarURL: s.avatarURL(q.avatar),
+		},
+	}
 }
 
 type Posts []Post
 
+func (pp Posts) StartCursor() *string {
+	if len(pp) == 0 {
+		return nil
+	}
+
+	first := pp[0]
+	return ptrString(encodeCursor(first.ID, first.CreatedAt))
+}
+
 func (pp Posts) EndCursor() *string {
 	if len(pp) == 0 {
 		return nil
@@ -59,22 +184,49 @@ func (pp Posts) EndCursor() *string {
 	return ptrString(encodeCursor(last.ID, last.CreatedAt))
 }
 
-func (s *Service) Posts(ctx context.Context, username string, last uint64, before *string) (Posts, error) {
+func (pp Posts) ptrs() []*Post {
+	out := make([]*Post, len(pp))
+	for i := range pp {
+		out[i] = &pp[i]
+	}
+	return out
+}
+
+// hydratePosts loads the data of the given posts that lives outside the posts table.
+func (s *Service) hydratePosts(ctx context.Context, pp ...*Post) error {
+	if err := s.attachPolls(ctx, pp...); err != nil {
+		return err
+	}
+
+	if err := s.attachPostRichText(ctx, pp...); err != nil {
+		return err
+	}
+
+	return s.attachPostLinkPreviews(ctx, pp...)
+}
+
+// Posts of a user in descending order and with pagination in both directions.
+func (s *Service) Posts(ctx context.Context, username string, page PageArgs) (Posts, PageInfo, error) {
+	var info PageInfo
 	username = strings.TrimSpace(username)
-	if !ValidUsername(username) {
-		return nil, ErrInvalidUsername
+	if !validUserHandle(username) {
+		return nil, info, ErrInvalidUsername
+	}
+
+	if err := page.validate(); err != nil {
+		return nil, info, err
 	}
-	var beforePostID string
-	var beforeCreatedAt time.Time
-	if before != nil {
+
+	var cursorPostID string
+	var cursorCreatedAt time.Time
+	if cursor := page.cursor(); cursor != nil {
 		var err error
-		beforePostID, beforeCreatedAt, err = decodeCursor(*before)
-		if err != nil || !reUUID.MatchString(beforePostID) {
-			return nil, ErrInvalidCursor
+		cursorPostID, cursorCreatedAt, err = decodeCursor(*cursor)
+		if err != nil || !reUUID.MatchString(cursorPostID) {
+			return nil, info, ErrInvalidCursor
 		}
 	}
 	uid, auth := ctx.Value(KeyAuthUserID).(string)
-	last = normalizePageSize(last)
 	query, args, err := buildQuery(`
 		SELECT posts.id
 		, posts.content
@@ -82,40 +234,69 @@ func (s *Service) Posts(ctx context.Context, username string, last uint64, befor
 		, posts.nsfw
 		, posts.likes_count
 		, posts.comments_count
+		, posts.reposts_count
+		, posts.quotes_count
+		, posts.reply_policy
+		, posts.visibility
 		, posts.created_at
+		, quotes.id
+		, quotes.content
+		, quotes.spoiler_of
+		, quotes.nsfw
+		, quotes.created_at
+		, quote_users.username
+		, quote_users.avatar
 		{{ if .auth }}
 		, posts.user_id = @uid AS post_mine
 		, likes.user_id IS NOT NULL AS liked
 		, subscriptions.user_id IS NOT NULL AS subscribed
+		, reposts.user_id IS NOT NULL AS reposted
+		, bookmarks.user_id IS NOT NULL AS bookmarked
 		{{ end }}
 		FROM posts
+		LEFT JOIN posts AS quotes ON posts.quote_id = quotes.id AND `+visiblePostSQL("quotes")+`
+		LEFT JOIN users AS quote_users ON quotes.user_id = quote_users.id
 		{{if .auth}}
 		LEFT JOIN post_likes AS likes
 			ON likes.user_id = @uid AND likes.post_id = posts.id
 		LEFT JOIN post_subscriptions AS subscriptions
 			ON subscriptions.user_id = @uid AND subscriptions.post_id = posts.id
+		LEFT JOIN reposts
+			ON reposts.user_id = @uid AND reposts.post_id = posts.id
+		LEFT JOIN bookmarks
+			ON bookmarks.user_id = @uid AND bookmarks.post_id = posts.id
 		{{end}}
 		WHERE posts.user_id = (SELECT id FROM users WHERE username = @username)
-		{{ if and .beforePostID .beforeCreatedAt }}
-		AND posts.created_at <= @beforeCreatedAt
-		AND (posts.id != @beforePostID OR posts.created_at < @beforeCreatedAt)
+		AND posts.publish_at IS NULL
+		AND `+visiblePostSQL("posts")+`
+		{{ if and .cursorPostID .forward }}
+		AND posts.created_at >= @cursorCreatedAt
+		AND (posts.id != @cursorPostID OR posts.created_at > @cursorCreatedAt)
+		{{ else if .cursorPostID }}
+		AND posts.created_at <= @cursorCreatedAt
+		AND (posts.id != @cursorPostID OR posts.created_at < @cursorCreatedAt)
 		{{end}}
+		{{ if .forward }}
+		ORDER BY posts.created_at ASC, posts.id DESC
+		{{ else }}
 		ORDER BY posts.created_at DESC, posts.id ASC
-		LIMIT @last`, map[string]interface{}{
+		{{ end }}
+		LIMIT @limit`, map[string]interface{}{
 		"auth":            auth,
 		"uid":             uid,
 		"username":        username,
-		"last":            last,
-		"beforePostID":    beforePostID,
-		"beforeCreatedAt": beforeCreatedAt,
+		"forward":         page.forward(),
+		"limit":           page.limit(),
+		"cursorPostID":    cursorPostID,
+		"cursorCreatedAt": cursorCreatedAt,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not build posts sql query: %w", err)
+		return nil, info, fmt.Errorf("could not build posts sql query: %w", err)
 	}
 
 	rows, err := s.Db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("could not query select posts: %w", err)
+		return nil, info, fmt.Errorf("could not query select posts: %w", err)
 	}
 
 	defer rows.Close()
@@ -123,6 +304,7 @@ func (s *Service) Posts(ctx context.Context, username string, last uint64, befor
 	var pp Posts
 	for rows.Next() {
 		var p Post
+		var q quoteScanner
 		dest := []interface{}{
 			&p.ID,
 			&p.Content,
@@ -130,24 +312,35 @@ func (s *Service) Posts(ctx context.Context, username string, last uint64, befor
 			&p.NSFW,
 			&p.LikesCount,
 			&p.CommentsCount,
+			&p.RepostsCount,
+			&p.QuotesCount,
+			&p.ReplyPolicy,
+			&p.Visibility,
 			&p.CreatedAt,
 		}
+		dest = append(dest, q.dest()...)
 		if auth {
-			dest = append(dest, &p.Mine, &p.Liked, &p.Subscribed)
+			dest = append(dest, &p.Mine, &p.Liked, &p.Subscribed, &p.Reposted, &p.Bookmarked)
 		}
 
 		if err = rows.Scan(dest...); err != nil {
-			return nil, fmt.Errorf("could not scan post: %w", err)
+			return nil, info, fmt.Errorf("could not scan post: %w", err)
 		}
 
+		p.Quote = s.quotedPost(q)
 		pp = append(pp, p)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("could not iterate posts rows: %w", err)
+		return nil, info, fmt.Errorf("could not iterate posts rows: %w", err)
+	}
+
+	pp, info = paginate(pp, page)
+	if err = s.hydratePosts(ctx, pp.ptrs()...); err != nil {
+		return nil, info, err
 	}
 
-	return pp, nil
+	return pp, info, nil
 }
 
 func (s *Service) Post(ctx context.Context, postID string) (Post, error) {
@@ -164,24 +357,45 @@ func (s *Service) Post(ctx context.Context, postID string) (Post, error) {
 		, posts.nsfw
 		, posts.likes_count
 		, posts.comments_count
+		, posts.reposts_count
+		, posts.quotes_count
+		, posts.reply_policy
+		, posts.visibility
 		, posts.created_at
 		, users.username
 		, users.avatar
+		, quotes.id
+		, quotes.content
+		, quotes.spoiler_of
+		, quotes.nsfw
+		, quotes.created_at
+		, quote_users.username
+		, quote_users.avatar
 		{{ if .auth }}
 		, posts.user_id = @uid AS post_mine
 		, likes.user_id IS NOT NULL AS liked
 		, subscriptions.user_id IS NOT NULL AS subscribed
+		, reposts.user_id IS NOT NULL AS reposted
+		, bookmarks.user_id IS NOT NULL AS bookmarked
 		{{ end }}
 		FROM posts
 		INNER JOIN users 
 			ON posts.user_id = users.id
+		LEFT JOIN posts AS quotes ON posts.quote_id = quotes.id AND `+visiblePostSQL("quotes")+`
+		LEFT JOIN users AS quote_users ON quotes.user_id = quote_users.id
 		{{if .auth}}
 		LEFT JOIN post_likes AS likes
 			ON likes.user_id = @uid AND likes.post_id = posts.id
 		LEFT JOIN post_subscriptions AS subscriptions
 			ON subscriptions.user_id = @uid AND subscriptions.post_id = posts.id
+		LEFT JOIN reposts
+			ON reposts.user_id = @uid AND reposts.post_id = posts.id
+		LEFT JOIN bookmarks
+			ON bookmarks.user_id = @uid AND bookmarks.post_id = posts.id
 		{{end}}
-		WHERE posts.id = @post_id`, map[string]interface{}{
+		WHERE posts.id = @post_id
+		AND (posts.publish_at IS NULL{{ if .auth }} OR posts.user_id = @uid{{ end }})
+		AND `+visiblePostSQL("posts"), map[string]interface{}{
 		"auth":    auth,
 		"uid":     uid,
 		"post_id": postID,
@@ -192,6 +406,7 @@ func (s *Service) Post(ctx context.Context, postID string) (Post, error) {
 
 	var u User
 	var avatar sql.NullString
+	var q quoteScanner
 	dest := []interface{}{
 		&p.ID,
 		&p.Content,
@@ -199,12 +414,17 @@ func (s *Service) Post(ctx context.Context, postID string) (Post, error) {
 		&p.NSFW,
 		&p.LikesCount,
 		&p.CommentsCount,
+		&p.RepostsCount,
+		&p.QuotesCount,
+		&p.ReplyPolicy,
+		&p.Visibility,
 		&p.CreatedAt,
 		&u.Username,
 		&avatar,
 	}
+	dest = append(dest, q.dest()...)
 	if auth {
-		dest = append(dest, &p.Mine, &p.Liked, &p.Subscribed)
+		dest = append(dest, &p.Mine, &p.Liked, &p.Subscribed, &p.Reposted, &p.Bookmarked)
 	}
 	err = s.Db.QueryRowContext(ctx, query, args...).Scan(dest...)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -217,10 +437,83 @@ func (s *Service) Post(ctx context.Context, postID string) (Post, error) {
 
 	u.AvatarURL = s.avatarURL(avatar)
 	p.User = &u
+	p.Quote = s.quotedPost(q)
+
+	if err = s.hydratePosts(ctx, &p); err != nil {
+		return p, err
+	}
 
 	return p, nil
 }
 
+// UpdatePost settings of a published post of the authenticated user.
+func (s *Service) UpdatePost(ctx context.Context, postID string, in UpdatePostInput) (Post, error) {
+	var p Post
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return p, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(postID) {
+		return p, ErrInvalidPostID
+	}
+
+	if in.ReplyPolicy == nil {
+		return p, ErrInvalidUpdatePostParams
+	}
+
+	if !in.ReplyPolicy.valid() {
+		return p, ErrInvalidReplyPolicy
+	}
+
+	var authorID string
+	query := "SELECT user_id FROM posts WHERE id = $1 AND publish_at IS NULL"
+	err := s.Db.QueryRowContext(ctx, query, postID).Scan(&authorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrPostNotFound
+	}
+
+	if err != nil {
+		return p, fmt.Errorf("could not query select post author: %w", err)
+	}
+
+	if authorID != uid {
+		return p, ErrUpdatePostDenied
+	}
+
+	query = "UPDATE posts SET reply_policy = $1 WHERE id = $2"
+	if _, err = s.Db.ExecContext(ctx, query, *in.ReplyPolicy, postID); err != nil {
+		return p, fmt.Errorf("could not update post: %w", err)
+	}
+
+	return s.Post(ctx, postID)
+}
+
+// postVisible tells whether the published post exists and can be read by the authenticated user, if any.
+func (s *Service) postVisible(ctx context.Context, postID string) (bool, error) {
+	uid, auth := ctx.Value(KeyAuthUserID).(string)
+	query, args, err := buildQuery(`
+		SELECT EXISTS (
+			SELECT 1 FROM posts
+			WHERE id = @postID AND publish_at IS NULL
+			AND `+visiblePostSQL("posts")+`
+		)`, map[string]interface{}{
+		"auth":   auth,
+		"uid":    uid,
+		"postID": postID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not build post visibility sql query: %w", err)
+	}
+
+	var visible bool
+	if err = s.Db.QueryRowContext(ctx, query, args...).Scan(&visible); err != nil {
+		return false, fmt.Errorf("could not query select post visibility: %w", err)
+	}
+
+	return visible, nil
+}
+
 type ToggleLikeOutput struct {
 	Liked      bool `json:"liked"`
 	LikesCount int  `json:"likesCount"`
@@ -237,11 +530,20 @@ func (s *Service) TogglePostLike(ctx context.Context, postID string) (ToggleLike
 		return out, ErrInvalidPostID
 	}
 
+	visible, err := s.postVisible(ctx, postID)
+	if err != nil {
+		return out, err
+	}
+
+	if !visible {
+		return out, ErrPostNotFound
+	}
+
 	query := `
 		SELECT EXISTS (
 			SELECT 1 FROM post_likes WHERE user_id = $1 AND post_id = $2
 		)`
-	if err := s.Db.QueryRowContext(ctx, query, uid, postID).Scan(&out.Liked); err != nil {
+	if err = s.Db.QueryRowContext(ctx, query, uid, postID).Scan(&out.Liked); err != nil {
 		return out, fmt.Errorf("could not query select post like existence")
 	}
 
@@ -257,11 +559,17 @@ func (s *Service) TogglePostLike(ctx context.Context, postID string) (ToggleLike
 			return out, fmt.Errorf("could not delete post like: %w", err)
 		}
 
-		query = "UPDATE posts SET likes_count = likes_count - 1 WHERE id = $1 RETURNING likes_count"
-		if err = tx.QueryRowContext(ctx, query, postID).Scan(&out.LikesCount); err != nil {
+		var authorID string
+		query = "UPDATE posts SET likes_count = likes_count - 1 WHERE id = $1 RETURNING likes_count, user_id"
+		if err = tx.QueryRowContext(ctx, query, postID).Scan(&out.LikesCount, &authorID); err != nil {
 			tx.Rollback()
 			return out, fmt.Errorf("could not decrement post likes count: %w", err)
 		}
+
+		if err = removeNotificationActor(ctx, tx, authorID, uid, "post_like", postID, nil); err != nil {
+			tx.Rollback()
+			return out, err
+		}
 	} else {
 		query = "INSERT INTO post_likes (user_id, post_id) VALUES ($1, $2)"
 		if _, err = tx.ExecContext(ctx, query, uid, postID); err != nil {
@@ -285,6 +593,10 @@ func (s *Service) TogglePostLike(ctx context.Context, postID string) (ToggleLike
 
 	out.Liked = !out.Liked
 
+	if out.Liked {
+		go s.notifyPostLike(uid, postID)
+	}
+
 	return out, nil
 }
 
@@ -305,10 +617,19 @@ func (s *Service) TogglePostSubscription(ctx context.Context, postID string) (To
 		return out, ErrInvalidPostID
 	}
 
+	visible, err := s.postVisible(ctx, postID)
+	if err != nil {
+		return out, err
+	}
+
+	if !visible {
+		return out, ErrPostNotFound
+	}
+
 	query := `SELECT EXISTS (
 			SELECT 1 FROM post_subscriptions WHERE user_id = $1 AND post_id = $2
 		)`
-	err := s.Db.QueryRowContext(ctx, query, uid, postID).Scan(&out.Subscribed)
+	err = s.Db.QueryRowContext(ctx, query, uid, postID).Scan(&out.Subscribed)
 	if err != nil {
 		return out, fmt.Errorf("could not query select post subscription existence: %w", err)
 	}
@@ -333,3 +654,94 @@ func (s *Service) TogglePostSubscription(ctx context.Context, postID string) (To
 
 	return out, nil
 }
+
+// ToggleRepostOutput response.
+type ToggleRepostOutput struct {
+	Reposted     bool `json:"reposted"`
+	RepostsCount int  `json:"repostsCount"`
+}
+
+// ToggleRepost shares a post to the authenticated user followers timelines,
+// or undoes a previous repost removing it from them.
+func (s *Service) ToggleRepost(ctx context.Context, postID string) (ToggleRepostOutput, error) {
+	var out ToggleRepostOutput
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return out, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(postID) {
+		return out, ErrInvalidPostID
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM reposts WHERE user_id = $1 AND post_id = $2
+		)`
+	if err := s.Db.QueryRowContext(ctx, query, uid, postID).Scan(&out.Reposted); err != nil {
+		return out, fmt.Errorf("could not query select repost existence: %w", err)
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return out, fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	if out.Reposted {
+		query = "DELETE FROM reposts WHERE user_id = $1 AND post_id = $2"
+		if _, err = tx.ExecContext(ctx, query, uid, postID); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not delete repost: %w", err)
+		}
+
+		var authorID string
+		query = "UPDATE posts SET reposts_count = reposts_count - 1 WHERE id = $1 RETURNING reposts_count, user_id"
+		if err = tx.QueryRowContext(ctx, query, postID).Scan(&out.RepostsCount, &authorID); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not decrement post reposts count: %w", err)
+		}
+
+		query = "DELETE FROM timeline WHERE post_id = $1 AND reposted_by = $2"
+		if _, err = tx.ExecContext(ctx, query, postID, uid); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not delete reposted timeline items: %w", err)
+		}
+
+		if err = removeNotificationActor(ctx, tx, authorID, uid, "repost", postID, nil); err != nil {
+			tx.Rollback()
+			return out, err
+		}
+	} else {
+		query = `
+			INSERT INTO reposts (user_id, post_id)
+			SELECT $1, id FROM posts WHERE id = $2 AND publish_at IS NULL AND visibility = 'public'`
+		res, err := tx.ExecContext(ctx, query, uid, postID)
+		if err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not insert repost: %w", err)
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			tx.Rollback()
+			return out, ErrPostNotFound
+		}
+
+		query = "UPDATE posts SET reposts_count = reposts_count + 1 WHERE id = $1 RETURNING reposts_count"
+		if err = tx.QueryRowContext(ctx, query, postID).Scan(&out.RepostsCount); err != nil {
+			tx.Rollback()
+			return out, fmt.Errorf("could not increment post reposts count: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return out, fmt.Errorf("could not commit to toggle repost: %w", err)
+	}
+
+	out.Reposted = !out.Reposted
+
+	if out.Reposted {
+		go s.repostCreated(uid, postID)
+	}
+
+	return out, nil
+}
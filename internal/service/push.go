@@ -0,0 +1,538 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/pascaldekloe/jwt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+const (
+	// pushTTL is how long push services keep a message for an offline browser.
+	pushTTL = time.Hour * 24
+	// pushRecordSize of the aes128gcm content coding; a payload fits in a single record.
+	pushRecordSize = 4096
+	// pushMaxBodySize is the body size push services are bound to accept, as of RFC 8291.
+	pushMaxBodySize = 4096
+	// pushHeaderSize of the aes128gcm content coding;
+	// the salt, the record size, the key ID length and the P-256 public key as key ID.
+	pushHeaderSize = 16 + 4 + 1 + 65
+	// pushMaxPayloadSize is the maximum plaintext size fitting the body;
+	// the body size minus the header, the authentication tag and the padding delimiter.
+	pushMaxPayloadSize = pushMaxBodySize - pushHeaderSize - 16 - 1
+	// vapidTokenTTL is how long the VAPID token of a push request is valid.
+	vapidTokenTTL = time.Hour * 12
+)
+
+var (
+	// ErrPushUnavailable denotes that the instance has no VAPID key to send push notifications with.
+	ErrPushUnavailable = UnimplementedError("push notifications unavailable")
+	// ErrInvalidPushSubscription denotes a push subscription with an invalid endpoint or keys.
+	ErrInvalidPushSubscription = InvalidArgumentError("invalid push subscription")
+	// ErrInvalidPushSubscriptionID denotes an invalid push subscription ID; that is not uuid.
+	ErrInvalidPushSubscriptionID = InvalidArgumentError("invalid push subscription ID")
+	// ErrPushSubscriptionNotFound denotes a not found push subscription.
+	ErrPushSubscriptionNotFound = NotFoundError("push subscription not found")
+)
+
+// PushSubscription of a browser, as given by PushManager.subscribe().
+type PushSubscription struct {
+	ID        string    `json:"id"`
+	Endpoint  string    `json:"endpoint"`
+	Keys      PushKeys  `json:"keys"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PushKeys of a push subscription, base64url encoded.
+type PushKeys struct {
+	// P256DH is the public key of the browser, an uncompressed P-256 point.
+	P256DH string `json:"p256dh"`
+	// Auth is the authentication secret of the browser.
+	Auth string `json:"auth"`
+}
+
+type PushSubscriptions []PushSubscription
+
+// GenerateVAPIDKey returns a new VAPID private key, base64url encoded.
+func GenerateVAPIDKey() (string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("could not generate vapid key: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(key.D.FillBytes(make([]byte, 32))), nil
+}
+
+// ParseVAPIDKey from its base64url encoded P-256 private scalar.
+func ParseVAPIDKey(s string) (*ecdsa.PrivateKey, error) {
+	b, err := decodeBase64URL(s)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("vapid key must be a base64url encoded 32 bytes P-256 private key")
+	}
+
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(b)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("vapid key out of the P-256 range")
+	}
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(b)
+	return key, nil
+}
+
+// decodeBase64URL with or without padding, as browsers may give either.
+func decodeBase64URL(s string) ([]byte, error) {
+	if len(s)%4 == 0 {
+		if b, err := base64.URLEncoding.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// VAPIDPublicKey is the application server key browsers subscribe with, base64url encoded.
+func (s *Service) VAPIDPublicKey() (string, error) {
+	if s.VAPIDKey == nil {
+		return "", ErrPushUnavailable
+	}
+
+	pub := elliptic.Marshal(elliptic.P256(), s.VAPIDKey.X, s.VAPIDKey.Y)
+	return base64.RawURLEncoding.EncodeToString(pub), nil
+}
+
+// pushAddrAllowed denies private, loopback and otherwise non public addresses,
+// unless they are part of Service.PushAllowlist.
+func (s *Service) pushAddrAllowed(addr netip.Addr) bool {
+	return publicAddrAllowed(s.PushAllowlist, addr)
+}
+
+// CreatePushSubscription for the authenticated user.
+// A browser subscribing again with the same endpoint replaces its previous subscription.
+func (s *Service) CreatePushSubscription(ctx context.Context, in PushSubscription) (PushSubscription, error) {
+	var out PushSubscription
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return out, ErrUnauthenticated
+	}
+
+	if s.VAPIDKey == nil {
+		return out, ErrPushUnavailable
+	}
+
+	u, err := url.Parse(in.Endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return out, ErrInvalidPushSubscription
+	}
+
+	if _, err = parsePushKeys(in.Keys); err != nil {
+		return out, ErrInvalidPushSubscription
+	}
+
+	// The key the browser subscribed with, as the push service binds the subscription to it.
+	vapidPublicKey, err := s.VAPIDPublicKey()
+	if err != nil {
+		return out, err
+	}
+
+	query := `
+		INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, vapid_public_key) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (endpoint) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			p256dh = EXCLUDED.p256dh,
+			auth = EXCLUDED.auth,
+			vapid_public_key = EXCLUDED.vapid_public_key
+		RETURNING id, created_at`
+	err = s.Db.QueryRowContext(ctx, query, uid, in.Endpoint, in.Keys.P256DH, in.Keys.Auth, vapidPublicKey).Scan(&out.ID, &out.CreatedAt)
+	if isForeignKeyViolation(err) {
+		return out, ErrUserGone
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("could not upsert push subscription: %w", err)
+	}
+
+	out.Endpoint = in.Endpoint
+	out.Keys = in.Keys
+	return out, nil
+}
+
+// PushSubscriptions of the authenticated user.
+func (s *Service) PushSubscriptions(ctx context.Context) (PushSubscriptions, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	query := `
+		SELECT id, endpoint, p256dh, auth, created_at FROM push_subscriptions
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+	rows, err := s.Db.QueryContext(ctx, query, uid)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select push subscriptions: %w", err)
+	}
+
+	defer rows.Close()
+
+	var ss PushSubscriptions
+	for rows.Next() {
+		var sub PushSubscription
+		if err = rows.Scan(&sub.ID, &sub.Endpoint, &sub.Keys.P256DH, &sub.Keys.Auth, &sub.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan push subscription: %w", err)
+		}
+
+		ss = append(ss, sub)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate push subscription rows: %w", err)
+	}
+
+	return ss, nil
+}
+
+// DeletePushSubscription of the authenticated user.
+func (s *Service) DeletePushSubscription(ctx context.Context, subscriptionID string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(subscriptionID) {
+		return ErrInvalidPushSubscriptionID
+	}
+
+	query := "DELETE FROM push_subscriptions WHERE id = $1 AND user_id = $2"
+	res, err := s.Db.ExecContext(ctx, query, subscriptionID, uid)
+	if err != nil {
+		return fmt.Errorf("could not delete push subscription: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPushSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// pushNotification enqueues the push of the notification
+// to every push subscription of its user.
+// It is pushed once per set of actors, as retried outbox jobs upsert
+// the same notification again with a new issue time but the same actors.
+func (s *Service) pushNotification(ctx context.Context, n Notification) error {
+	if s.VAPIDKey == nil {
+		return nil
+	}
+
+	b, err := pushPayload(n)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	query := `
+		UPDATE notifications SET pushed_actors = $2
+		WHERE id = $1 AND pushed_actors IS DISTINCT FROM $2`
+	res, err := tx.ExecContext(ctx, query, n.ID, pq.Array(n.Actors))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not update notification pushed actors: %w", err)
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		// Already pushed, or deleted in the meantime.
+		tx.Rollback()
+		return nil
+	}
+
+	query = "SELECT id FROM push_subscriptions WHERE user_id = $1"
+	rows, err := tx.QueryContext(ctx, query, n.UserID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not query select push subscription ids: %w", err)
+	}
+
+	var subscriptionIDs []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return fmt.Errorf("could not scan push subscription id: %w", err)
+		}
+
+		subscriptionIDs = append(subscriptionIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not iterate push subscription id rows: %w", err)
+	}
+
+	for _, id := range subscriptionIDs {
+		if err = enqueue(ctx, tx, outboxPush, pushJob{SubscriptionID: id, Payload: b}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to push notification: %w", err)
+	}
+
+	if len(subscriptionIDs) != 0 {
+		s.wakeOutbox()
+	}
+
+	return nil
+}
+
+// pushPayload of the notification, with its oldest actors left out
+// as long as it doesn't fit a push message.
+func pushPayload(n Notification) ([]byte, error) {
+	for {
+		b, err := json.Marshal(n)
+		if err != nil {
+			return nil, fmt.Errorf("could not json marshal push notification: %w", err)
+		}
+
+		if len(b) <= pushMaxPayloadSize {
+			return b, nil
+		}
+
+		if len(n.Actors) <= 1 {
+			return nil, fmt.Errorf("push payload of %d bytes too large", len(b))
+		}
+
+		n.Actors = n.Actors[:len(n.Actors)-1]
+	}
+}
+
+// push sends the encrypted payload to the push service of the subscription.
+// It runs as an outbox job. Subscriptions the push service reports as gone,
+// or made with another VAPID key than the current one, are deleted
+// and messages it refuses are dropped.
+func (s *Service) push(ctx context.Context, in pushJob) error {
+	if s.VAPIDKey == nil {
+		return nil
+	}
+
+	publicKey, err := s.VAPIDPublicKey()
+	if err != nil {
+		return err
+	}
+
+	var sub PushSubscription
+	var subscribedKey sql.NullString
+	query := "SELECT endpoint, p256dh, auth, vapid_public_key FROM push_subscriptions WHERE id = $1"
+	err = s.Db.QueryRowContext(ctx, query, in.SubscriptionID).Scan(&sub.Endpoint, &sub.Keys.P256DH, &sub.Keys.Auth, &subscribedKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Unsubscribed in the meantime.
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not query select push subscription: %w", err)
+	}
+
+	// The push service would refuse every message of a subscription bound to another key.
+	if subscribedKey.Valid && subscribedKey.String != publicKey {
+		return s.deleteStalePushSubscription(ctx, in.SubscriptionID)
+	}
+
+	keys, err := parsePushKeys(sub.Keys)
+	if err != nil {
+		return nil
+	}
+
+	if len(in.Payload) > pushMaxPayloadSize {
+		log.Println("error", fmt.Errorf("push payload of %d bytes too large", len(in.Payload)))
+		return nil
+	}
+
+	body, err := encryptPushPayload(keys, in.Payload)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.vapidToken(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "vapid t="+token+", k="+publicKey)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(int(pushTTL/time.Second)))
+	req.Header.Set("Urgency", "normal")
+
+	resp, err := s.pushClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return s.deleteStalePushSubscription(ctx, in.SubscriptionID)
+	case resp.StatusCode == http.StatusForbidden && !subscribedKey.Valid:
+		// Subscribed before the key was recorded, and so most likely with a key since replaced.
+		return s.deleteStalePushSubscription(ctx, in.SubscriptionID)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		log.Println("error", fmt.Errorf("push to subscription %s refused with status code %d", in.SubscriptionID, resp.StatusCode))
+		return nil
+	}
+
+	return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
+
+// deleteStalePushSubscription the push service won't deliver to anymore.
+func (s *Service) deleteStalePushSubscription(ctx context.Context, subscriptionID string) error {
+	query := "DELETE FROM push_subscriptions WHERE id = $1"
+	if _, err := s.Db.ExecContext(ctx, query, subscriptionID); err != nil {
+		return fmt.Errorf("could not delete push subscription: %w", err)
+	}
+
+	return nil
+}
+
+// vapidToken for a push request to the given endpoint, as of RFC 8292.
+func (s *Service) vapidToken(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("could not parse push endpoint: %w", err)
+	}
+
+	var claims jwt.Claims
+	// Set, so the audience is a single string, as push services expect.
+	claims.Set = map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenTTL).Unix(),
+		"sub": s.VAPIDSubject,
+	}
+	token, err := claims.ECDSASign(jwt.ES256, s.VAPIDKey, json.RawMessage(`{"typ":"JWT"}`))
+	if err != nil {
+		return "", fmt.Errorf("could not sign vapid token: %w", err)
+	}
+
+	return string(token), nil
+}
+
+type pushKeys struct {
+	x, y    *big.Int
+	p256dh  []byte
+	authKey []byte
+}
+
+func parsePushKeys(in PushKeys) (pushKeys, error) {
+	var keys pushKeys
+	var err error
+	if keys.p256dh, err = decodeBase64URL(in.P256DH); err != nil {
+		return keys, fmt.Errorf("could not base64 decode p256dh: %w", err)
+	}
+
+	keys.x, keys.y = elliptic.Unmarshal(elliptic.P256(), keys.p256dh)
+	if keys.x == nil {
+		return keys, errors.New("p256dh is not an uncompressed P-256 point")
+	}
+
+	if keys.authKey, err = decodeBase64URL(in.Auth); err != nil || len(keys.authKey) != 16 {
+		return keys, errors.New("auth must be a base64url encoded 16 bytes secret")
+	}
+
+	return keys, nil
+}
+
+// encryptPushPayload with the aes128gcm content coding of RFC 8188,
+// keyed as of RFC 8291 from a new ephemeral key pair and the subscription keys.
+func encryptPushPayload(keys pushKeys, payload []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	asKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate ephemeral key: %w", err)
+	}
+
+	asPublic := elliptic.Marshal(curve, asKey.X, asKey.Y)
+	sharedX, _ := curve.ScalarMult(keys.x, keys.y, asKey.D.FillBytes(make([]byte, 32)))
+	ecdhSecret := sharedX.FillBytes(make([]byte, 32))
+
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %w", err)
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), keys.p256dh...), asPublic...)
+	ikm := hkdf(keys.authKey, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, fmt.Errorf("could not create aes cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create gcm: %w", err)
+	}
+
+	// A single and so last record, delimited by 0x02 and not padded.
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// hkdf extracts a pseudorandom key from the salt and input keying material
+// and expands it with the info to length bytes, at most 32.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	reURLPrefix          = regexp.MustCompile(`^(?:` + reURL.String() + `)`)
+	reMentionPrefix      = regexp.MustCompile(`^@([a-zA-Z][a-zA-Z0-9_-]{0,17})`)
+	reMarkdownLinkPrefix = regexp.MustCompile(`^\[([^\[\]\n]+)\]\((https?://[^\s()<>"'` + "`" + `]+)\)`)
+)
+
+// Entity found in a post or comment content.
+// Offset and Length are in characters (unicode code points) of the raw content.
+type Entity struct {
+	// Type is either "mention", "hashtag" or "url".
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	// Value is the mentioned username, the tag without "#" or the URL.
+	Value string `json:"value"`
+}
+
+// richText of a single content.
+type richText struct {
+	html     string
+	entities []Entity
+}
+
+// renderRichTexts renders each of the given contents as sanitized HTML
+// along with its entities. Only mentions of existing users are kept.
+func (s *Service) renderRichTexts(ctx context.Context, contents ...string) ([]richText, error) {
+	var mentions []string
+	for _, content := range contents {
+		mentions = append(mentions, collectMentions(content)...)
+	}
+
+	users := map[string]struct{}{}
+	if len(mentions) != 0 {
+		query := "SELECT username FROM users WHERE username = ANY($1)"
+		rows, err := s.Db.QueryContext(ctx, query, pq.Array(mentions))
+		if err != nil {
+			return nil, fmt.Errorf("could not query select mentioned users: %w", err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var username string
+			if err = rows.Scan(&username); err != nil {
+				return nil, fmt.Errorf("could not scan mentioned username: %w", err)
+			}
+
+			users[username] = struct{}{}
+		}
+
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("could not iterate mentioned user rows: %w", err)
+		}
+	}
+
+	out := make([]richText, len(contents))
+	for i, content := range contents {
+		out[i] = richText{
+			html:     renderMarkdown(content, users),
+			entities: textEntities(content, users),
+		}
+	}
+	return out, nil
+}
+
+// attachPostRichText sets the HTML and entities of the given posts and their quotes.
+func (s *Service) attachPostRichText(ctx context.Context, pp ...*Post) error {
+	var all []*Post
+	for _, p := range pp {
+		all = append(all, p)
+		if p.Quote != nil {
+			all = append(all, p.Quote)
+		}
+	}
+
+	contents := make([]string, len(all))
+	for i, p := range all {
+		contents[i] = p.Content
+	}
+
+	rr, err := s.renderRichTexts(ctx, contents...)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range all {
+		p.ContentHTML = rr[i].html
+		p.Entities = rr[i].entities
+	}
+
+	return nil
+}
+
+// attachCommentRichText sets the HTML and entities of the given comments.
+func (s *Service) attachCommentRichText(ctx context.Context, cc ...*Comment) error {
+	contents := make([]string, len(cc))
+	for i, c := range cc {
+		contents[i] = c.Content
+	}
+
+	rr, err := s.renderRichTexts(ctx, contents...)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range cc {
+		c.ContentHTML = rr[i].html
+		c.Entities = rr[i].entities
+	}
+
+	return nil
+}
+
+// textEntities finds URLs, hashtags and mentions of the given users, in order.
+// Hashtags and mentions within a URL are not entities by themselves.
+func textEntities(s string, users map[string]struct{}) []Entity {
+	ee := []Entity{}
+	var urls [][]int
+	for _, m := range reURL.FindAllStringIndex(s, -1) {
+		urls = append(urls, m)
+		ee = append(ee, newEntity(s, "url", m[0], m[1], s[m[0]:m[1]]))
+	}
+
+	withinURL := func(i int) bool {
+		for _, m := range urls {
+			if i >= m[0] && i < m[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, m := range reMentions.FindAllStringSubmatchIndex(s, -1) {
+		name := s[m[2]:m[3]]
+		if _, ok := users[name]; !ok || withinURL(m[2]) {
+			continue
+		}
+
+		ee = append(ee, newEntity(s, "mention", m[2]-1, m[3], name))
+	}
+
+	for _, m := range reTags.FindAllStringSubmatchIndex(s, -1) {
+		if withinURL(m[2]) {
+			continue
+		}
+
+		ee = append(ee, newEntity(s, "hashtag", m[2]-1, m[3], s[m[2]:m[3]]))
+	}
+
+	sort.Slice(ee, func(i, j int) bool {
+		return ee[i].Offset < ee[j].Offset
+	})
+	return ee
+}
+
+// newEntity from byte indexes of s.
+func newEntity(s, typ string, start, end int, value string) Entity {
+	return Entity{
+		Type:   typ,
+		Offset: utf8.RuneCountInString(s[:start]),
+		Length: utf8.RuneCountInString(s[start:end]),
+		Value:  value,
+	}
+}
+
+// renderMarkdown renders a safe subset of Markdown as HTML:
+// paragraphs, line breaks, quotes, bold, italic, inline code and links.
+// Anything else is escaped, so the output is safe to insert as is.
+func renderMarkdown(s string, users map[string]struct{}) string {
+	var b strings.Builder
+	lines := strings.Split(s, "\n")
+	for i := 0; i < len(lines); {
+		if isQuoteLine(lines[i]) {
+			var quoted []string
+			for ; i < len(lines) && isQuoteLine(lines[i]); i++ {
+				quoted = append(quoted, strings.TrimPrefix(strings.TrimPrefix(lines[i], ">"), " "))
+			}
+
+			b.WriteString("<blockquote>")
+			writeParagraph(&b, quoted, users)
+			b.WriteString("</blockquote>")
+			continue
+		}
+
+		var paragraph []string
+		for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !isQuoteLine(lines[i]); i++ {
+			paragraph = append(paragraph, lines[i])
+		}
+
+		if len(paragraph) == 0 {
+			i++
+			continue
+		}
+
+		writeParagraph(&b, paragraph, users)
+	}
+	return b.String()
+}
+
+func isQuoteLine(line string) bool {
+	return strings.HasPrefix(line, ">")
+}
+
+func writeParagraph(b *strings.Builder, lines []string, users map[string]struct{}) {
+	b.WriteString("<p>")
+	for i, line := range lines {
+		if i != 0 {
+			b.WriteString("<br>")
+		}
+		b.WriteString(renderInline(line, users, true))
+	}
+	b.WriteString("</p>")
+}
+
+// renderInline renders the inline Markdown of a single line.
+// Links, including mentions, are not rendered within links.
+func renderInline(s string, users map[string]struct{}, links bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		atWordStart := i == 0 || !isWordRune(lastRune(s[:i]))
+
+		switch {
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(rest[1:1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**"):
+			if inner, ok := delimited(rest, "**"); ok {
+				b.WriteString("<strong>" + renderInline(inner, users, links) + "</strong>")
+				i += len(inner) + 4
+				continue
+			}
+		case rest[0] == '*' || (rest[0] == '_' && atWordStart):
+			delim := rest[:1]
+			if inner, ok := delimited(rest, delim); ok {
+				after := rest[len(inner)+2:]
+				if delim == "*" || after == "" || !isWordRune(firstRune(after)) {
+					b.WriteString("<em>" + renderInline(inner, users, links) + "</em>")
+					i += len(inner) + 2
+					continue
+				}
+			}
+		case rest[0] == '[' && links:
+			if m := reMarkdownLinkPrefix.FindStringSubmatch(rest); m != nil {
+				b.WriteString(`<a href="` + html.EscapeString(m[2]) + `" rel="nofollow noopener noreferrer" target="_blank">` +
+					renderInline(m[1], users, false) + "</a>")
+				i += len(m[0])
+				continue
+			}
+		case rest[0] == 'h' && links && atWordStart:
+			if u := reURLPrefix.FindString(rest); u != "" {
+				b.WriteString(`<a href="` + html.EscapeString(u) + `" rel="nofollow noopener noreferrer" target="_blank">` +
+					html.EscapeString(u) + "</a>")
+				i += len(u)
+				continue
+			}
+		case rest[0] == '@' && links && atWordStart:
+			if m := reMentionPrefix.FindStringSubmatch(rest); m != nil {
+				if _, ok := users[m[1]]; ok {
+					b.WriteString(`<a href="/users/` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[0]) + "</a>")
+					i += len(m[0])
+					continue
+				}
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(html.EscapeString(rest[:size]))
+		i += size
+	}
+	return b.String()
+}
+
+// delimited returns the non blank text of s enclosed by delim at both ends.
+func delimited(s, delim string) (string, bool) {
+	end := strings.Index(s[len(delim):], delim)
+	if end <= 0 {
+		return "", false
+	}
+
+	inner := s[len(delim) : len(delim)+end]
+	if strings.TrimSpace(inner) != inner {
+		return "", false
+	}
+
+	return inner, true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
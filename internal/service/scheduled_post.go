@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxPostScheduleAhead       = time.Hour * 24 * 365
+	scheduledPostsPublishBatch = 100
+)
+
+var (
+	// ErrInvalidPublishAt denotes an invalid publish time; that is not in the future or too far ahead.
+	ErrInvalidPublishAt = InvalidArgumentError("invalid publish at")
+	// ErrScheduledPostNotFound denotes a not found or already published scheduled post.
+	ErrScheduledPostNotFound = NotFoundError("scheduled post not found")
+)
+
+// UpdateScheduledPostInput request. Nil fields are left untouched.
+type UpdateScheduledPostInput struct {
+	Content   *string
+	SpoilerOf *string
+	NSFW      *bool
+	PublishAt *time.Time
+}
+
+func validPublishAt(t time.Time) bool {
+	now := time.Now()
+	return t.After(now) && t.Before(now.Add(maxPostScheduleAhead))
+}
+
+// ScheduledPosts of the authenticated user in ascending publish order.
+func (s *Service) ScheduledPosts(ctx context.Context) (Posts, error) {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	query := `
+		SELECT id
+		, content
+		, spoiler_of
+		, nsfw
+		, quote_id
+		, reply_policy
+		, visibility
+		, created_at
+		, publish_at
+		FROM posts
+		WHERE user_id = $1 AND publish_at IS NOT NULL
+		ORDER BY publish_at ASC, id ASC`
+	rows, err := s.Db.QueryContext(ctx, query, uid)
+	if err != nil {
+		return nil, fmt.Errorf("could not query select scheduled posts: %w", err)
+	}
+
+	defer rows.Close()
+
+	var pp Posts
+	for rows.Next() {
+		var p Post
+		if err = rows.Scan(
+			&p.ID,
+			&p.Content,
+			&p.SpoilerOf,
+			&p.NSFW,
+			&p.QuoteID,
+			&p.ReplyPolicy,
+			&p.Visibility,
+			&p.CreatedAt,
+			&p.PublishAt,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan scheduled post: %w", err)
+		}
+
+		p.UserID = uid
+		p.Mine = true
+		pp = append(pp, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate scheduled post rows: %w", err)
+	}
+
+	return pp, nil
+}
+
+// UpdateScheduledPost edits a not yet published post of the authenticated user.
+func (s *Service) UpdateScheduledPost(ctx context.Context, postID string, in UpdateScheduledPostInput) (Post, error) {
+	var p Post
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return p, ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(postID) {
+		return p, ErrInvalidPostID
+	}
+
+	if in.Content == nil && in.SpoilerOf == nil && in.NSFW == nil && in.PublishAt == nil {
+		return p, ErrInvalidUpdatePostParams
+	}
+
+	if in.Content != nil {
+		*in.Content = smartTrim(*in.Content)
+		if *in.Content == "" || utf8.RuneCountInString(*in.Content) > postContentMaxLength {
+			return p, ErrInvalidContent
+		}
+	}
+
+	if in.SpoilerOf != nil {
+		*in.SpoilerOf = smartTrim(*in.SpoilerOf)
+		if *in.SpoilerOf == "" || utf8.RuneCountInString(*in.SpoilerOf) > postSpoilerMaxLength {
+			return p, ErrInvalidSpoiler
+		}
+	}
+
+	if in.PublishAt != nil && !validPublishAt(*in.PublishAt) {
+		return p, ErrInvalidPublishAt
+	}
+
+	query, args, err := buildQuery(`
+		UPDATE posts SET
+		{{ if .content }}content = @content,{{ end }}
+		{{ if .spoilerOf }}spoiler_of = @spoilerOf,{{ end }}
+		{{ if .nsfw }}nsfw = @nsfw,{{ end }}
+		{{ if .publishAt }}publish_at = @publishAt,{{ end }}
+		id = id
+		WHERE id = @postID AND user_id = @uid AND publish_at IS NOT NULL
+		RETURNING content, spoiler_of, nsfw, quote_id, reply_policy, visibility, created_at, publish_at`, map[string]interface{}{
+		"content":   in.Content,
+		"spoilerOf": in.SpoilerOf,
+		"nsfw":      in.NSFW,
+		"publishAt": in.PublishAt,
+		"postID":    postID,
+		"uid":       uid,
+	})
+	if err != nil {
+		return p, fmt.Errorf("could not build update scheduled post sql query: %w", err)
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&p.Content,
+		&p.SpoilerOf,
+		&p.NSFW,
+		&p.QuoteID,
+		&p.ReplyPolicy,
+		&p.Visibility,
+		&p.CreatedAt,
+		&p.PublishAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return p, ErrScheduledPostNotFound
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return p, fmt.Errorf("could not update scheduled post: %w", err)
+	}
+
+	p.ID = postID
+	p.UserID = uid
+	p.Mine = true
+
+	if in.Content != nil {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM post_mentions WHERE post_id = $1", postID); err != nil {
+			tx.Rollback()
+			return p, fmt.Errorf("could not delete scheduled post mentions: %w", err)
+		}
+
+		if err = insertPostMentions(ctx, tx, p); err != nil {
+			tx.Rollback()
+			return p, err
+		}
+
+		if _, err = tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = $1", postID); err != nil {
+			tx.Rollback()
+			return p, fmt.Errorf("could not delete scheduled post tags: %w", err)
+		}
+
+		if err = insertPostTags(ctx, tx, p); err != nil {
+			tx.Rollback()
+			return p, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return p, fmt.Errorf("could not commit to update scheduled post: %w", err)
+	}
+
+	return p, nil
+}
+
+// CancelScheduledPost deletes a not yet published post of the authenticated user.
+func (s *Service) CancelScheduledPost(ctx context.Context, postID string) error {
+	uid, ok := ctx.Value(KeyAuthUserID).(string)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !reUUID.MatchString(postID) {
+		return ErrInvalidPostID
+	}
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	var quoteID *string
+	query := `
+		DELETE FROM posts WHERE id = $1 AND user_id = $2 AND publish_at IS NOT NULL
+		RETURNING quote_id`
+	err = tx.QueryRowContext(ctx, query, postID, uid).Scan(&quoteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrScheduledPostNotFound
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not delete scheduled post: %w", err)
+	}
+
+	if quoteID != nil {
+		query = "UPDATE posts SET quotes_count = quotes_count - 1 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, query, *quoteID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not decrement post quotes count: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to cancel scheduled post: %w", err)
+	}
+
+	return nil
+}
+
+// PublishScheduledPosts publishes due scheduled posts every interval, forever.
+// Due posts are claimed with SKIP LOCKED so several server instances
+// can run it at the same time without publishing a post twice.
+func (s *Service) PublishScheduledPosts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			n, err := s.publishDuePosts(context.Background())
+			if err != nil {
+				log.Println("error", fmt.Errorf("could not publish scheduled posts: %w", err))
+				break
+			}
+
+			if n < scheduledPostsPublishBatch {
+				break
+			}
+		}
+	}
+}
+
+func (s *Service) publishDuePosts(ctx context.Context) (int, error) {
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin tx: %w", err)
+	}
+
+	query := `
+		UPDATE posts SET publish_at = NULL, created_at = now()
+		WHERE id IN (
+			SELECT id FROM posts
+			WHERE publish_at <= now()
+			ORDER BY publish_at ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, content, spoiler_of, nsfw, quote_id, reply_policy, visibility, created_at`
+	rows, err := tx.QueryContext(ctx, query, scheduledPostsPublishBatch)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("could not update and publish scheduled posts: %w", err)
+	}
+
+	var pp Posts
+	for rows.Next() {
+		var p Post
+		if err = rows.Scan(
+			&p.ID,
+			&p.UserID,
+			&p.Content,
+			&p.SpoilerOf,
+			&p.NSFW,
+			&p.QuoteID,
+			&p.ReplyPolicy,
+			&p.Visibility,
+			&p.CreatedAt,
+		); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, fmt.Errorf("could not scan published post: %w", err)
+		}
+
+		pp = append(pp, p)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("could not iterate published post rows: %w", err)
+	}
+
+	for _, p := range pp {
+		query = `
+			INSERT INTO timeline (user_id, post_id) VALUES ($1, $2)
+			ON CONFLICT (user_id, post_id) DO NOTHING`
+		if _, err = tx.ExecContext(ctx, query, p.UserID, p.ID); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("could not insert published timeline item: %w", err)
+		}
+
+		if err = enqueue(ctx, tx, outboxPostCreated, postCreatedJob{PostID: p.ID, UserID: p.UserID}); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit to publish scheduled posts: %w", err)
+	}
+
+	if len(pp) != 0 {
+		s.wakeOutbox()
+	}
+
+	return len(pp), nil
+}
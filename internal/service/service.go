@@ -1,7 +1,10 @@
 package service
 
 import (
+	"crypto/ecdsa"
 	"database/sql"
+	"net/http"
+	"net/netip"
 )
 
 type Service struct {
@@ -9,13 +12,53 @@ type Service struct {
 	JWTSecret        string
 	AvatarURLPrefix  string
 	BrokerRepository *BrokerRepository
+	// LinkPreviewAllowlist holds the otherwise forbidden networks link previews may be fetched from.
+	LinkPreviewAllowlist []netip.Prefix
+	// TimelineBackfillSize is how many of the latest posts of a followee
+	// are added to the follower timeline.
+	TimelineBackfillSize int
+	// KeepInteractedOnUnfollow keeps the posts the user liked, commented or bookmarked
+	// in their timeline after unfollowing the author.
+	KeepInteractedOnUnfollow bool
+	// FanoutFollowersThreshold is the followers count above which posts
+	// are merged into the followers timeline at read time instead of fanned out.
+	// Zero fans out every post.
+	FanoutFollowersThreshold int
+	// Origin is the public URL of this instance, without trailing slash.
+	// ActivityPub IDs are built from it.
+	Origin string
+	// FederationAllowlist holds the otherwise forbidden networks remote instances may be reached in.
+	FederationAllowlist []netip.Prefix
+	// Mailer sends the email digests. Without one, none is sent.
+	Mailer Mailer
+	// VAPIDKey signs the push notifications. Without one, none is sent.
+	VAPIDKey *ecdsa.PrivateKey
+	// VAPIDSubject is the contact push services may reach the instance operator at,
+	// a mailto: or https: URL.
+	VAPIDSubject string
+	// PushAllowlist holds the otherwise forbidden networks push services may be reached in.
+	PushAllowlist []netip.Prefix
+
+	linkPreviewClient *http.Client
+	federationClient  *http.Client
+	pushClient        *http.Client
+	outboxWake        chan struct{}
 }
 
 func New(db *sql.DB, jwtSecret string, avatarURLPrefix string) *Service {
-	return &Service{
+	s := &Service{
 		Db:               db,
 		JWTSecret:        jwtSecret,
 		AvatarURLPrefix:  avatarURLPrefix,
 		BrokerRepository: newBrokerRepository(),
+
+		TimelineBackfillSize:     20,
+		KeepInteractedOnUnfollow: true,
+		FanoutFollowersThreshold: 10000,
 	}
+	s.linkPreviewClient = newRestrictedClient(s.linkPreviewAddrAllowed)
+	s.federationClient = newRestrictedClient(s.federationAddrAllowed)
+	s.pushClient = newRestrictedClient(s.pushAddrAllowed)
+	s.outboxWake = make(chan struct{}, 1)
+	return s
 }
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var reTag = regexp.MustCompile(`^(?:\p{L}|\p{N}|_)+$`)
+
+// ErrInvalidTag denotes an invalid hashtag.
+var ErrInvalidTag = InvalidArgumentError("invalid tag")
+
+// collectTags returns the distinct hashtags of the content, without "#" and lower cased.
+func collectTags(s string) []string {
+	m := map[string]struct{}{}
+	var tt []string
+	for _, submatch := range reTags.FindAllStringSubmatch(s, -1) {
+		val := strings.ToLower(submatch[1])
+		if _, ok := m[val]; !ok {
+			m[val] = struct{}{}
+			tt = append(tt, val)
+		}
+	}
+	return tt
+}
+
+// insertPostTags records the hashtags of the post content.
+func insertPostTags(ctx context.Context, tx *sql.Tx, p Post) error {
+	tags := collectTags(p.Content)
+	if len(tags) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO post_tags (post_id, tag)
+		SELECT $1, unnest($2::varchar[])
+		ON CONFLICT (post_id, tag) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, p.ID, pq.Array(tags)); err != nil {
+		return fmt.Errorf("could not insert post tags: %w", err)
+	}
+
+	return nil
+}
+
+// TagPosts are the posts with the given hashtag readable by the authenticated user,
+// most recent first and with pagination in both directions.
+// The tag is case insensitive and may start with "#".
+func (s *Service) TagPosts(ctx context.Context, tag string, page PageArgs) (Posts, PageInfo, error) {
+	var info PageInfo
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	if !reTag.MatchString(tag) {
+		return nil, info, ErrInvalidTag
+	}
+
+	if err := page.validate(); err != nil {
+		return nil, info, err
+	}
+
+	var cursorPostID string
+	var cursorCreatedAt time.Time
+	if cursor := page.cursor(); cursor != nil {
+		var err error
+		cursorPostID, cursorCreatedAt, err = decodeCursor(*cursor)
+		if err != nil || !reUUID.MatchString(cursorPostID) {
+			return nil, info, ErrInvalidCursor
+		}
+	}
+
+	uid, auth := ctx.Value(KeyAuthUserID).(string)
+	query, args, err := buildQuery(`
+		SELECT posts.id
+		, posts.content
+		, posts.spoiler_of
+		, posts.nsfw
+		, posts.likes_count
+		, posts.comments_count
+		, posts.reposts_count
+		, posts.quotes_count
+		, posts.reply_policy
+		, posts.visibility
+		, posts.created_at
+		, users.username
+		, users.avatar
+		, quotes.id
+		, quotes.content
+		, quotes.spoiler_of
+		, quotes.nsfw
+		, quotes.created_at
+		, quote_users.username
+		, quote_users.avatar
+		{{ if .auth }}
+		, posts.user_id = @uid AS post_mine
+		, likes.user_id IS NOT NULL AS liked
+		, subscriptions.user_id IS NOT NULL AS subscribed
+		, reposts.user_id IS NOT NULL AS reposted
+		, bookmarks.user_id IS NOT NULL AS bookmarked
+		{{ end }}
+		FROM post_tags
+		INNER JOIN posts ON post_tags.post_id = posts.id
+		INNER JOIN users ON posts.user_id = users.id
+		LEFT JOIN posts AS quotes ON posts.quote_id = quotes.id AND `+visiblePostSQL("quotes")+`
+		LEFT JOIN users AS quote_users ON quotes.user_id = quote_users.id
+		{{ if .auth }}
+		LEFT JOIN post_likes AS likes
+			ON likes.user_id = @uid AND likes.post_id = posts.id
+		LEFT JOIN post_subscriptions AS subscriptions
+			ON subscriptions.user_id = @uid AND subscriptions.post_id = posts.id
+		LEFT JOIN reposts
+			ON reposts.user_id = @uid AND reposts.post_id = posts.id
+		LEFT JOIN bookmarks
+			ON bookmarks.user_id = @uid AND bookmarks.post_id = posts.id
+		{{ end }}
+		WHERE post_tags.tag = @tag
+		AND posts.publish_at IS NULL
+		AND `+visiblePostSQL("posts")+`
+		{{ if and .cursorPostID .forward }}
+		AND posts.created_at >= @cursorCreatedAt
+		AND (posts.id != @cursorPostID OR posts.created_at > @cursorCreatedAt)
+		{{ else if .cursorPostID }}
+		AND posts.created_at <= @cursorCreatedAt
+		AND (posts.id != @cursorPostID OR posts.created_at < @cursorCreatedAt)
+		{{ end }}
+		{{ if .forward }}
+		ORDER BY posts.created_at ASC, posts.id DESC
+		{{ else }}
+		ORDER BY posts.created_at DESC, posts.id ASC
+		{{ end }}
+		LIMIT @limit`, map[string]interface{}{
+		"auth":            auth,
+		"uid":             uid,
+		"tag":             tag,
+		"forward":         page.forward(),
+		"limit":           page.limit(),
+		"cursorPostID":    cursorPostID,
+		"cursorCreatedAt": cursorCreatedAt,
+	})
+	if err != nil {
+		return nil, info, fmt.Errorf("could not build tag posts sql query: %w", err)
+	}
+
+	rows, err := s.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, info, fmt.Errorf("could not query select tag posts: %w", err)
+	}
+
+	defer rows.Close()
+
+	var pp Posts
+	for rows.Next() {
+		var p Post
+		var u User
+		var avatar sql.NullString
+		var q quoteScanner
+		dest := []interface{}{
+			&p.ID,
+			&p.Content,
+			&p.SpoilerOf,
+			&p.NSFW,
+			&p.LikesCount,
+			&p.CommentsCount,
+			&p.RepostsCount,
+			&p.QuotesCount,
+			&p.ReplyPolicy,
+			&p.Visibility,
+			&p.CreatedAt,
+			&u.Username,
+			&avatar,
+		}
+		dest = append(dest, q.dest()...)
+		if auth {
+			dest = append(dest, &p.Mine, &p.Liked, &p.Subscribed, &p.Reposted, &p.Bookmarked)
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return nil, info, fmt.Errorf("could not scan tag post: %w", err)
+		}
+
+		u.AvatarURL = s.avatarURL(avatar)
+		p.User = &u
+		p.Quote = s.quotedPost(q)
+		pp = append(pp, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, info, fmt.Errorf("could not iterate tag post rows: %w", err)
+	}
+
+	pp, info = paginate(pp, page)
+	if err = s.hydratePosts(ctx, pp.ptrs()...); err != nil {
+		return nil, info, err
+	}
+
+	return pp, info, nil
+}
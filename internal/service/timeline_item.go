@@ -3,7 +3,9 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"log"
 	"time"
 	"unicode/utf8"
@@ -24,10 +26,39 @@ var (
 )
 
 type TimelineItem struct {
-	ID     string `json:"timelineItemID"`
-	UserID string `json:"-"`
-	PostID string `json:"-"`
-	*Post  `json:"post"`
+	ID         string `json:"timelineItemID"`
+	UserID     string `json:"-"`
+	PostID     string `json:"-"`
+	*Post      `json:"post"`
+	RepostedBy *User `json:"repostedBy,omitempty"`
+
+	// rank is set when listed in ranked mode.
+	rank *timelineRank
+}
+
+// CreateTimelineItemInput request.
+type CreateTimelineItemInput struct {
+	Content   string
+	SpoilerOf *string
+	NSFW      bool
+	// QuoteID is the ID of the post being quoted, if any.
+	QuoteID *string
+	// PublishAt schedules the post to be published later, if any.
+	PublishAt *time.Time
+	// Poll attached to the post, if any.
+	Poll *PollInput
+	// ReplyPolicy defaults to everyone.
+	ReplyPolicy ReplyPolicy
+	// Visibility defaults to public.
+	Visibility Visibility
+}
+
+func (tt Timeline) StartCursor() *string {
+	if len(tt) == 0 {
+		return nil
+	}
+
+	return tt[0].cursor()
 }
 
 func (tt Timeline) EndCursor() *string {
@@ -35,32 +66,71 @@ func (tt Timeline) EndCursor() *string {
 		return nil
 	}
 
-	last := tt[len(tt)-1]
-	if last.Post == nil {
+	return tt[len(tt)-1].cursor()
+}
+
+func (ti TimelineItem) cursor() *string {
+	if ti.rank != nil {
+		return ptrString(encodeRankedCursor(*ti.rank))
+	}
+
+	if ti.Post == nil {
 		return nil
 	}
 
-	return ptrString(encodeCursor(last.Post.ID, last.Post.CreatedAt))
+	return ptrString(encodeCursor(ti.Post.ID, ti.Post.CreatedAt))
 }
 
-// Timeline of the authenticated user in descending order and with backward pagination.
-func (s *Service) Timeline(ctx context.Context, last uint64, before *string) (Timeline, error) {
+// Timeline of the authenticated user with pagination in both directions.
+// Chronological mode lists most recent items first.
+// Ranked mode lists the items in the order of a ranking made on the first page.
+func (s *Service) Timeline(ctx context.Context, mode TimelineMode, page PageArgs) (Timeline, PageInfo, error) {
+	var info PageInfo
 	uid, ok := ctx.Value(KeyAuthUserID).(string)
 	if !ok {
-		return nil, ErrUnauthenticated
+		return nil, info, ErrUnauthenticated
 	}
 
-	var beforePostID string
-	var beforeCreatedAt time.Time
+	mode, err := mode.normalize()
+	if err != nil {
+		return nil, info, err
+	}
 
-	if before != nil {
-		var err error
-		beforePostID, beforeCreatedAt, err = decodeCursor(*before)
-		if err != nil || !reUUID.MatchString(beforePostID) {
-			return nil, ErrInvalidCursor
+	if err = page.validate(); err != nil {
+		return nil, info, err
+	}
+
+	var cursorPostID string
+	var cursorCreatedAt time.Time
+	var rank timelineRank
+
+	if cursor := page.cursor(); cursor != nil {
+		if mode == TimelineRanked {
+			rank, err = decodeRankedCursor(*cursor)
+			if err != nil || !reUUID.MatchString(rank.rankingID) {
+				return nil, info, ErrInvalidCursor
+			}
+
+			// An expired ranking is no end of the timeline; the client must start over.
+			alive, err := s.rankingAlive(ctx, uid, rank.rankingID)
+			if err != nil {
+				return nil, info, err
+			}
+
+			if !alive {
+				return nil, info, ErrInvalidCursor
+			}
+		} else {
+			cursorPostID, cursorCreatedAt, err = decodeCursor(*cursor)
+			if err != nil || !reUUID.MatchString(cursorPostID) {
+				return nil, info, ErrInvalidCursor
+			}
+		}
+	} else if mode == TimelineRanked {
+		if rank.rankingID, err = s.rankTimeline(ctx, uid); err != nil {
+			return nil, info, err
 		}
 	}
-	last = normalizePageSize(last)
 
 	query, args, err := buildQuery(`
 		SELECT timeline.id
@@ -70,41 +140,86 @@ func (s *Service) Timeline(ctx context.Context, last uint64, before *string) (Ti
 		, posts.nsfw
 		, posts.likes_count
 		, posts.comments_count
+		, posts.reposts_count
+		, posts.quotes_count
+		, posts.reply_policy
+		, posts.visibility
 		, posts.created_at
 		, posts.user_id = @uid AS post_mine
 		, likes.user_id IS NOT NULL AS liked
 		, subscriptions.user_id IS NOT NULL AS subscribed
+		, reposts.user_id IS NOT NULL AS reposted
+		, bookmarks.user_id IS NOT NULL AS bookmarked
 		, users.username
 		, users.avatar
-		FROM timeline
+		, reposters.username
+		, reposters.avatar
+		, quotes.id
+		, quotes.content
+		, quotes.spoiler_of
+		, quotes.nsfw
+		, quotes.created_at
+		, quote_users.username
+		, quote_users.avatar
+		{{ if .rankingID }}
+		, ranked.position
+		FROM timeline_rankings
+		CROSS JOIN LATERAL unnest(timeline_rankings.post_ids) WITH ORDINALITY AS ranked (post_id, position)
+		INNER JOIN `+homeTimelineSQL("@uid", `
+			AND posts.id IN (
+				SELECT unnest(post_ids) FROM timeline_rankings WHERE id = @rankingID AND user_id = @uid
+			)`)+` AS timeline ON timeline.post_id = ranked.post_id
+		{{ else }}
+		FROM `+homeTimelineSQL("@uid", `
+			AND `+visiblePostSQL("posts")+`
+			`+timelinePageSQL+`
+			LIMIT @limit`)+` AS timeline
+		{{ end }}
 		INNER JOIN posts ON timeline.post_id = posts.id
 		INNER JOIN users ON posts.user_id = users.id
+		LEFT JOIN users AS reposters ON timeline.reposted_by = reposters.id
+		LEFT JOIN posts AS quotes ON posts.quote_id = quotes.id AND `+visiblePostSQL("quotes")+`
+		LEFT JOIN users AS quote_users ON quotes.user_id = quote_users.id
 		LEFT JOIN post_likes AS likes
 			ON likes.user_id = @uid AND likes.post_id = posts.id
 		LEFT JOIN post_subscriptions AS subscriptions
 			ON subscriptions.user_id = @uid AND subscriptions.post_id = posts.id
+		LEFT JOIN reposts
+			ON reposts.user_id = @uid AND reposts.post_id = posts.id
+		LEFT JOIN bookmarks
+			ON bookmarks.user_id = @uid AND bookmarks.post_id = posts.id
 		WHERE timeline.user_id = @uid
-		{{ if and .beforePostID .beforeCreatedAt }}
-			AND posts.created_at <= @beforeCreatedAt
-			AND (
-				posts.id != @beforePostID
-					OR posts.created_at < @beforeCreatedAt
-			)
+		AND `+visiblePostSQL("posts")+`
+		{{ if .rankingID }}
+			AND timeline_rankings.id = @rankingID
+			AND timeline_rankings.user_id = @uid
+			{{ if .forward }}
+			AND ranked.position < @cursorPosition
+			ORDER BY ranked.position DESC
+			{{ else }}
+			AND ranked.position > @cursorPosition
+			ORDER BY ranked.position ASC
+			{{ end }}
+		{{ else }}
+			`+timelinePageSQL+`
 		{{ end }}
-		ORDER BY posts.created_at DESC, posts.id ASC
-		LIMIT @last`, map[string]interface{}{
+		LIMIT @limit`, map[string]interface{}{
+		"auth":            true,
 		"uid":             uid,
-		"last":            last,
-		"beforePostID":    beforePostID,
-		"beforeCreatedAt": beforeCreatedAt,
+		"forward":         page.forward(),
+		"limit":           page.limit(),
+		"cursorPostID":    cursorPostID,
+		"cursorCreatedAt": cursorCreatedAt,
+		"rankingID":       rank.rankingID,
+		"cursorPosition":  rank.position,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not build timeline sql query: %w", err)
+		return nil, info, fmt.Errorf("could not build timeline sql query: %w", err)
 	}
 
 	rows, err := s.Db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("could not query select timeline: %w", err)
+		return nil, info, fmt.Errorf("could not query select timeline: %w", err)
 	}
 
 	defer rows.Close()
@@ -115,7 +230,9 @@ func (s *Service) Timeline(ctx context.Context, last uint64, before *string) (Ti
 		var p Post
 		var u User
 		var avatar sql.NullString
-		if err = rows.Scan(
+		var reposter, reposterAvatar sql.NullString
+		var q quoteScanner
+		dest := []interface{}{
 			&ti.ID,
 			&p.ID,
 			&p.Content,
@@ -123,46 +240,66 @@ func (s *Service) Timeline(ctx context.Context, last uint64, before *string) (Ti
 			&p.NSFW,
 			&p.LikesCount,
 			&p.CommentsCount,
+			&p.RepostsCount,
+			&p.QuotesCount,
+			&p.ReplyPolicy,
+			&p.Visibility,
 			&p.CreatedAt,
 			&p.Mine,
 			&p.Liked,
 			&p.Subscribed,
+			&p.Reposted,
+			&p.Bookmarked,
 			&u.Username,
 			&avatar,
-		); err != nil {
-			return nil, fmt.Errorf("could not scan timeline item: %w", err)
+			&reposter,
+			&reposterAvatar,
+		}
+		dest = append(dest, q.dest()...)
+		if mode == TimelineRanked {
+			ti.rank = &timelineRank{rankingID: rank.rankingID}
+			dest = append(dest, &ti.rank.position)
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return nil, info, fmt.Errorf("could not scan timeline item: %w", err)
 		}
 		u.AvatarURL = s.avatarURL(avatar)
 		p.User = &u
+		p.Quote = s.quotedPost(q)
 		ti.Post = &p
+		if reposter.Valid {
+			ti.RepostedBy = &User{Username: reposter.String, AvatarURL: s.avatarURL(reposterAvatar)}
+		}
 		tt = append(tt, ti)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("could not iterate timeline rows: %w", err)
+		return nil, info, fmt.Errorf("could not iterate timeline rows: %w", err)
 	}
 
-	return tt, nil
+	tt, info = paginate(tt, page)
+	pp := make([]*Post, len(tt))
+	for i, ti := range tt {
+		pp[i] = ti.Post
+	}
+	if err = s.hydratePosts(ctx, pp...); err != nil {
+		return nil, info, err
+	}
+
+	return tt, info, nil
 }
 
 // CreateTimelineItem publishes a post to the user timeline and fan-outs it to his followers.
-func (s *Service) CreateTimelineItem(ctx context.Context, content string, spoilerOf *string, nsfw bool) (TimelineItem, error) {
+func (s *Service) CreateTimelineItem(ctx context.Context, in CreateTimelineItemInput) (TimelineItem, error) {
 	var ti TimelineItem
 	uid, ok := ctx.Value(KeyAuthUserID).(string)
 	if !ok {
 		return ti, ErrUnauthenticated
 	}
 
-	content = smartTrim(content)
-	if content == "" || utf8.RuneCountInString(content) > postContentMaxLength {
-		return ti, ErrInvalidContent
-	}
-
-	if spoilerOf != nil {
-		*spoilerOf = smartTrim(*spoilerOf)
-		if *spoilerOf == "" || utf8.RuneCountInString(*spoilerOf) > postSpoilerMaxLength {
-			return ti, ErrInvalidSpoiler
-		}
+	if err := in.normalize(); err != nil {
+		return ti, err
 	}
 
 	tx, err := s.Db.BeginTx(ctx, nil)
@@ -170,14 +307,100 @@ func (s *Service) CreateTimelineItem(ctx context.Context, content string, spoile
 		return ti, fmt.Errorf("could not begin tx: %w", err)
 	}
 
+	ti, err = s.insertTimelineItem(ctx, tx, uid, in)
+	if err != nil {
+		tx.Rollback()
+		return ti, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return ti, fmt.Errorf("could not insert timeline item: %w", err)
+	}
+
+	s.timelineItemCreated(ctx, ti)
+
+	return ti, nil
+}
+
+// normalize trims and validates the input in place.
+func (in *CreateTimelineItemInput) normalize() error {
+	in.Content = smartTrim(in.Content)
+	if in.Content == "" || utf8.RuneCountInString(in.Content) > postContentMaxLength {
+		return ErrInvalidContent
+	}
+
+	if in.SpoilerOf != nil {
+		*in.SpoilerOf = smartTrim(*in.SpoilerOf)
+		if *in.SpoilerOf == "" || utf8.RuneCountInString(*in.SpoilerOf) > postSpoilerMaxLength {
+			return ErrInvalidSpoiler
+		}
+	}
+
+	if in.QuoteID != nil && !reUUID.MatchString(*in.QuoteID) {
+		return ErrInvalidQuoteID
+	}
+
+	if in.PublishAt != nil && !validPublishAt(*in.PublishAt) {
+		return ErrInvalidPublishAt
+	}
+
+	if in.ReplyPolicy == "" {
+		in.ReplyPolicy = ReplyPolicyEveryone
+	} else if !in.ReplyPolicy.valid() {
+		return ErrInvalidReplyPolicy
+	}
+
+	if in.Visibility == "" {
+		in.Visibility = VisibilityPublic
+	} else if !in.Visibility.valid() {
+		return ErrInvalidVisibility
+	}
+
+	if in.Poll != nil {
+		if err := in.Poll.normalize(in.PublishAt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertTimelineItem inserts an already normalized post within the given transaction.
+// The caller is responsible of rolling back on error.
+func (s *Service) insertTimelineItem(ctx context.Context, tx *sql.Tx, uid string, in CreateTimelineItemInput) (TimelineItem, error) {
+	var ti TimelineItem
+	if in.QuoteID != nil {
+		query, args, err := buildQuery(`
+			UPDATE posts SET quotes_count = quotes_count + 1
+			WHERE id = @quoteID AND publish_at IS NULL
+			AND `+visiblePostSQL("posts"), map[string]interface{}{
+			"auth":    true,
+			"uid":     uid,
+			"quoteID": *in.QuoteID,
+		})
+		if err != nil {
+			return ti, fmt.Errorf("could not build quote sql query: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return ti, fmt.Errorf("could not increment post quotes count: %w", err)
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ti, ErrPostNotFound
+		}
+	}
+
 	var p Post
 	query := `
-			INSERT INTO posts (user_id, content, spoiler_of, nsfw) VALUES ($1, $2, $3, $4)
+			INSERT INTO posts (user_id, content, spoiler_of, nsfw, quote_id, publish_at, reply_policy, visibility)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING id, created_at`
-	err = tx.QueryRowContext(ctx, query, uid, content, spoilerOf, nsfw).Scan(&p.ID, &p.CreatedAt)
+	err := tx.QueryRowContext(ctx, query, uid, in.Content, in.SpoilerOf, in.NSFW, in.QuoteID, in.PublishAt, in.ReplyPolicy, in.Visibility).
+		Scan(&p.ID, &p.CreatedAt)
 
 	if err != nil {
-		tx.Rollback()
 		if isForeignKeyViolation(err) {
 			return ti, ErrUserGone
 		}
@@ -185,11 +408,23 @@ func (s *Service) CreateTimelineItem(ctx context.Context, content string, spoile
 	}
 
 	p.UserID = uid
-	p.Content = content
-	p.SpoilerOf = spoilerOf
-	p.NSFW = nsfw
+	p.Content = in.Content
+	p.SpoilerOf = in.SpoilerOf
+	p.NSFW = in.NSFW
+	p.QuoteID = in.QuoteID
+	p.PublishAt = in.PublishAt
+	p.ReplyPolicy = in.ReplyPolicy
+	p.Visibility = in.Visibility
 	p.Mine = true
 
+	if err = insertPostMentions(ctx, tx, p); err != nil {
+		return ti, err
+	}
+
+	if err = insertPostTags(ctx, tx, p); err != nil {
+		return ti, err
+	}
+
 	query = "INSERT INTO post_subscriptions (user_id, post_id) VALUES ($1, $2)"
 	if _, err = tx.ExecContext(ctx, query, uid, p.ID); err != nil {
 		return ti, fmt.Errorf("could not insert post subscription: %w", err)
@@ -197,51 +432,306 @@ func (s *Service) CreateTimelineItem(ctx context.Context, content string, spoile
 
 	p.Subscribed = true
 
-	query = "INSERT INTO timeline (user_id, post_id) VALUES ($1, $2) RETURNING id"
-	err = tx.QueryRowContext(ctx, query, uid, p.ID).Scan(&ti.ID)
-	if err != nil {
-		tx.Rollback()
-		return ti, fmt.Errorf("could not insert timeline item: %w", err)
+	if in.Poll != nil {
+		if p.Poll, err = insertPoll(ctx, tx, p.ID, *in.Poll); err != nil {
+			return ti, err
+		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		return ti, fmt.Errorf("could not insert timeline item: %w", err)
+	// Scheduled posts reach the timeline once published.
+	if p.PublishAt == nil {
+		query = "INSERT INTO timeline (user_id, post_id) VALUES ($1, $2) RETURNING id"
+		err = tx.QueryRowContext(ctx, query, uid, p.ID).Scan(&ti.ID)
+		if err != nil {
+			return ti, fmt.Errorf("could not insert timeline item: %w", err)
+		}
+
+		if err = enqueue(ctx, tx, outboxPostCreated, postCreatedJob{PostID: p.ID, UserID: uid}); err != nil {
+			return ti, err
+		}
 	}
 
 	ti.UserID = uid
 	ti.PostID = p.ID
 	ti.Post = &p
 
-	go s.postCreated(p)
-
 	return ti, nil
 }
 
-func (s *Service) postCreated(p Post) {
-	u, err := s.userByID(context.Background(), p.UserID)
+// insertPostMentions records the existing users mentioned in the post content,
+// which can read it whatever its visibility.
+func insertPostMentions(ctx context.Context, tx *sql.Tx, p Post) error {
+	mentions := collectMentions(p.Content)
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO post_mentions (post_id, user_id)
+		SELECT $1, id FROM users WHERE username = ANY($2) AND id != $3
+		ON CONFLICT (post_id, user_id) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, p.ID, pq.Array(mentions), p.UserID); err != nil {
+		return fmt.Errorf("could not insert post mentions: %w", err)
+	}
+
+	return nil
+}
+
+// timelineItemCreated runs the side effects of a committed timeline item.
+func (s *Service) timelineItemCreated(ctx context.Context, ti TimelineItem) {
+	p := ti.Post
+	if p.QuoteID != nil {
+		if quote, err := s.Post(ctx, *p.QuoteID); err == nil {
+			p.Quote = &quote
+		}
+	}
+
+	if err := s.attachPostRichText(ctx, p); err != nil {
+		log.Println("error", err)
+	}
+
+	if p.PublishAt == nil {
+		s.wakeOutbox()
+	}
+}
+
+// postCreated fan-outs a just published post and notifies the mentioned users.
+// It runs as an outbox job.
+func (s *Service) postCreated(ctx context.Context, postID, authorID string) error {
+	// The author can see the post whatever its visibility.
+	p, err := s.Post(context.WithValue(ctx, KeyAuthUserID, authorID), postID)
+	if errors.Is(err, ErrPostNotFound) {
+		// Deleted in the meantime.
+		return nil
+	}
+
 	if err != nil {
-		log.Println(err)
-		return
+		return err
+	}
+
+	u, err := s.userByID(ctx, authorID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
 	}
 
+	if err != nil {
+		return err
+	}
+
+	p.UserID = authorID
 	p.User = &u
 	p.Mine = false
+	p.Liked = false
 	p.Subscribed = false
+	p.Reposted = false
+	p.Bookmarked = false
+
+	// The quote was loaded as the author sees it, but the post goes out to others.
+	// Only a public one is sent along; the others fetch the post to see it.
+	if p.Quote != nil {
+		public, err := s.postVisible(ctx, p.Quote.ID)
+		if err != nil {
+			return err
+		}
+
+		if !public {
+			p.Quote = nil
+		}
+	}
+
+	if err = s.fanoutPost(ctx, p); err != nil {
+		return err
+	}
+
+	if err = s.notifyPostMention(ctx, p); err != nil {
+		return err
+	}
 
-	go s.fanoutPost(p)
-	go s.notifyPostMention(p)
+	if err = s.streamPostToLists(ctx, p); err != nil {
+		return err
+	}
+
+	if err = s.deliverPost(ctx, p); err != nil {
+		return err
+	}
+
+	go s.unfurlLink(p.Content)
+
+	return nil
 }
 
 type Timeline []TimelineItem
 
-func (s *Service) fanoutPost(p Post) {
+// homeTimelineSQL selects the timeline items of the given user placeholder:
+// the materialized ones plus the posts of followed users that were not fanned out.
+// The latter have no timeline item of their own, so their ID is the post ID.
+// They are bounded by the given SQL; more conditions on posts, and an order and limit if any,
+// so only the ones needed are read instead of the whole history of the followees.
+func homeTimelineSQL(uid, bound string) string {
+	return `(
+		SELECT timeline.id, timeline.user_id, timeline.post_id, timeline.reposted_by
+		FROM timeline
+		WHERE timeline.user_id = ` + uid + `
+		UNION ALL
+		(
+			SELECT posts.id, ` + uid + `::uuid, posts.id, NULL::uuid
+			FROM posts
+			INNER JOIN follows ON follows.followee_id = posts.user_id AND follows.follower_id = ` + uid + `
+			WHERE NOT posts.fanned_out
+				AND posts.publish_at IS NULL
+				AND NOT EXISTS (
+					SELECT 1 FROM timeline WHERE timeline.user_id = ` + uid + ` AND timeline.post_id = posts.id
+				)
+				` + bound + `
+		)
+	)`
+}
+
+// timelinePageSQL is the cursor condition and order of a chronological timeline page.
+const timelinePageSQL = `
+	{{ if and .cursorPostID .forward }}
+		AND posts.created_at >= @cursorCreatedAt
+		AND (
+			posts.id != @cursorPostID
+				OR posts.created_at > @cursorCreatedAt
+		)
+	{{ else if .cursorPostID }}
+		AND posts.created_at <= @cursorCreatedAt
+		AND (
+			posts.id != @cursorPostID
+				OR posts.created_at < @cursorCreatedAt
+		)
+	{{ end }}
+	{{ if .forward }}
+	ORDER BY posts.created_at ASC, posts.id DESC
+	{{ else }}
+	ORDER BY posts.created_at DESC, posts.id ASC
+	{{ end }}`
+
+// fanoutPost distributes a post to the author local followers,
+// or only to the mentioned users if that is its visibility.
+// Posts of authors with more followers than FanoutFollowersThreshold
+// are not written to their followers timeline but merged at read time.
+func (s *Service) fanoutPost(ctx context.Context, p Post) error {
+	if p.Visibility != VisibilityMentioned && s.FanoutFollowersThreshold > 0 {
+		var skip bool
+		query := `
+			UPDATE posts SET fanned_out = false
+			WHERE id = $1 AND (SELECT followers_count FROM users WHERE id = $2) > $3
+			RETURNING true`
+		err := s.Db.QueryRowContext(ctx, query, p.ID, p.UserID, s.FanoutFollowersThreshold).Scan(&skip)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("could not update post fanned out: %w", err)
+		}
+
+		if skip {
+			return s.streamPostToFollowers(ctx, p)
+		}
+	}
+
 	query := `
 		INSERT INTO timeline (user_id, post_id)
-		SELECT follower_id, $1 FROM follows WHERE followee_id = $2
+		SELECT follows.follower_id, $1 FROM follows
+		INNER JOIN users ON follows.follower_id = users.id AND users.actor_id IS NULL
+		WHERE follows.followee_id = $2
+		ON CONFLICT (user_id, post_id) DO NOTHING
 		RETURNING id, user_id`
-	rows, err := s.Db.Query(query, p.ID, p.UserID)
+	if p.Visibility == VisibilityMentioned {
+		query = `
+			INSERT INTO timeline (user_id, post_id)
+			SELECT user_id, $1 FROM post_mentions WHERE post_id = $1 AND user_id != $2
+			ON CONFLICT (user_id, post_id) DO NOTHING
+			RETURNING id, user_id`
+	}
+	rows, err := s.Db.QueryContext(ctx, query, p.ID, p.UserID)
 	if err != nil {
-		log.Println(fmt.Errorf("could not insert timeline: %w", err))
+		return fmt.Errorf("could not insert timeline: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var ti TimelineItem
+		if err = rows.Scan(&ti.ID, &ti.UserID); err != nil {
+			return fmt.Errorf("could not scan timeline item: %w", err)
+		}
+
+		ti.PostID = p.ID
+		ti.Post = &p
+
+		go s.broadcastTimelineItem(ti)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate timeline rows: %w", err)
+	}
+
+	return nil
+}
+
+// streamPostToFollowers delivers a post that was not fanned out
+// to the followers of its author currently streaming their timeline.
+func (s *Service) streamPostToFollowers(ctx context.Context, p Post) error {
+	uids := s.BrokerRepository.timelineItemBroker.connectedUsers()
+	if len(uids) == 0 {
+		return nil
+	}
+
+	query := "SELECT follower_id FROM follows WHERE followee_id = $1 AND follower_id = ANY($2)"
+	rows, err := s.Db.QueryContext(ctx, query, p.UserID, pq.Array(uids))
+	if err != nil {
+		return fmt.Errorf("could not query select streaming followers: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		ti := TimelineItem{ID: p.ID, PostID: p.ID, Post: &p}
+		if err = rows.Scan(&ti.UserID); err != nil {
+			return fmt.Errorf("could not scan streaming follower: %w", err)
+		}
+
+		go s.broadcastTimelineItem(ti)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate streaming follower rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) repostCreated(reposterID, postID string) {
+	ctx := context.Background()
+	reposter, err := s.userByID(ctx, reposterID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	p, err := s.Post(ctx, postID)
+	if err != nil {
+		log.Println(fmt.Errorf("could not fetch reposted post: %w", err))
+		return
+	}
+
+	go s.fanoutRepost(p, reposter)
+	go s.notifyRepost(p.ID, reposter)
+}
+
+// fanoutRepost distributes a reposted post to the reposter local followers.
+// Followers that already have the post in their timeline are skipped.
+func (s *Service) fanoutRepost(p Post, reposter User) {
+	query := `
+		INSERT INTO timeline (user_id, post_id, reposted_by)
+		SELECT follows.follower_id, $1, $2 FROM follows
+		INNER JOIN users ON follows.follower_id = users.id AND users.actor_id IS NULL
+		WHERE follows.followee_id = $2
+		ON CONFLICT (user_id, post_id) DO NOTHING
+		RETURNING id, user_id`
+	rows, err := s.Db.Query(query, p.ID, reposter.ID)
+	if err != nil {
+		log.Println(fmt.Errorf("could not insert reposted timeline: %w", err))
 		return
 	}
 
@@ -250,29 +740,150 @@ func (s *Service) fanoutPost(p Post) {
 	for rows.Next() {
 		var ti TimelineItem
 		if err = rows.Scan(&ti.ID, &ti.UserID); err != nil {
-			log.Println(fmt.Errorf("could not scan timeline item: %w", err))
+			log.Println(fmt.Errorf("could not scan reposted timeline item: %w", err))
 			return
 		}
 
 		ti.PostID = p.ID
 		ti.Post = &p
+		ti.RepostedBy = &reposter
 
 		go s.broadcastTimelineItem(ti)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Println(fmt.Errorf("could not iterate timeline rows: %w", err))
+		log.Println(fmt.Errorf("could not iterate reposted timeline rows: %w", err))
 		return
 	}
 }
 
-func (s *Service) TimelineItemStream(ctx context.Context) (<-chan TimelineItem, error) {
+// TimelineChange lists the timeline items added or removed at once
+// after following or unfollowing a user.
+type TimelineChange struct {
+	Username        string   `json:"username"`
+	TimelineItemIDs []string `json:"timelineItemIDs"`
+}
+
+// backfillTimeline adds the latest posts of a just followed user to the follower timeline.
+// Nothing is added if the follow was undone in the meantime, or to remote followers.
+func (s *Service) backfillTimeline(ctx context.Context, followerID, followeeID, followeeUsername string) error {
+	if s.TimelineBackfillSize <= 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO timeline (user_id, post_id)
+		SELECT $1, posts.id FROM posts
+		WHERE posts.user_id = $2
+			AND posts.publish_at IS NULL
+			AND (
+				posts.visibility != 'mentioned'
+				OR EXISTS (SELECT 1 FROM post_mentions WHERE post_id = posts.id AND user_id = $1)
+			)
+			AND EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2)
+			AND EXISTS (SELECT 1 FROM users WHERE id = $1 AND actor_id IS NULL)
+		ORDER BY posts.created_at DESC
+		LIMIT $3
+		ON CONFLICT (user_id, post_id) DO NOTHING
+		RETURNING id`
+	rows, err := s.Db.QueryContext(ctx, query, followerID, followeeID, s.TimelineBackfillSize)
+	if err != nil {
+		return fmt.Errorf("could not insert backfilled timeline: %w", err)
+	}
+
+	defer rows.Close()
+
+	change := TimelineChange{Username: followeeUsername}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("could not scan backfilled timeline item: %w", err)
+		}
+
+		change.TimelineItemIDs = append(change.TimelineItemIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate backfilled timeline rows: %w", err)
+	}
+
+	if len(change.TimelineItemIDs) != 0 {
+		go s.broadcastTimelineEvent(TimelineEvent{Type: "timeline_backfilled", UserID: followerID, Data: change})
+	}
+
+	return nil
+}
+
+// purgeTimeline removes the posts and reposts of an unfollowed user from the follower timeline
+// within the given transaction. Posts the follower was mentioned in are kept,
+// and so are the ones they interacted with if keepInteracted.
+// The caller is responsible of rolling back on error.
+func purgeTimeline(ctx context.Context, tx *sql.Tx, followerID, followeeID string, keepInteracted bool) ([]string, error) {
+	query, args, err := buildQuery(`
+		DELETE FROM timeline
+		WHERE timeline.user_id = @followerID
+			AND (
+				timeline.reposted_by = @followeeID
+				OR (
+					timeline.reposted_by IS NULL
+					AND timeline.post_id IN (SELECT id FROM posts WHERE user_id = @followeeID)
+				)
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM post_mentions WHERE post_id = timeline.post_id AND user_id = @followerID
+			)
+			{{ if .keepInteracted }}
+			AND NOT EXISTS (
+				SELECT 1 FROM post_likes WHERE post_id = timeline.post_id AND user_id = @followerID
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM comments WHERE post_id = timeline.post_id AND user_id = @followerID
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM bookmarks WHERE post_id = timeline.post_id AND user_id = @followerID
+			)
+			{{ end }}
+		RETURNING timeline.id`, map[string]interface{}{
+		"followerID":     followerID,
+		"followeeID":     followeeID,
+		"keepInteracted": keepInteracted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build purge timeline sql query: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not delete unfollowed timeline items: %w", err)
+	}
+
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not scan purged timeline item: %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate purged timeline rows: %w", err)
+	}
+
+	return ids, nil
+}
+
+// TimelineItemStream to receive new timeline items and other timeline events in realtime.
+func (s *Service) TimelineItemStream(ctx context.Context) (<-chan TimelineEvent, error) {
 	uid, ok := ctx.Value(KeyAuthUserID).(string)
 	if !ok {
 		return nil, ErrUnauthenticated
 	}
 
-	tt := make(chan TimelineItem)
+	tt := make(chan TimelineEvent)
 	c := &timelineItemClient{timelines: tt, userID: uid, ctx: ctx}
 	// Signal the broker that we have a new connection
 	s.BrokerRepository.timelineItemBroker.NewClients <- c
@@ -286,5 +897,9 @@ func (s *Service) TimelineItemStream(ctx context.Context) (<-chan TimelineItem,
 }
 
 func (s *Service) broadcastTimelineItem(ti TimelineItem) {
-	s.BrokerRepository.timelineItemBroker.Notifier <- ti
+	s.broadcastTimelineEvent(TimelineEvent{UserID: ti.UserID, Data: ti})
+}
+
+func (s *Service) broadcastTimelineEvent(e TimelineEvent) {
+	s.BrokerRepository.timelineItemBroker.Notifier <- e
 }
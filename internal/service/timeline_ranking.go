@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimelineMode of the home timeline.
+type TimelineMode string
+
+const (
+	// TimelineChronological lists most recent timeline items first. It is the default.
+	TimelineChronological TimelineMode = "chronological"
+	// TimelineRanked lists the recent timeline items ranked by how likely
+	// the user is to care about them; see rankTimeline.
+	TimelineRanked TimelineMode = "ranked"
+)
+
+const (
+	// timelineRankingWindow limits ranked candidates to the recent posts.
+	timelineRankingWindow = time.Hour * 24 * 7
+	// timelineRankingCandidates is the maximum number of ranked timeline items.
+	timelineRankingCandidates = 500
+	// timelineRankingMaxAge is how long a ranking can be paginated.
+	timelineRankingMaxAge = time.Hour
+)
+
+// ErrInvalidTimelineMode denotes an unknown timeline mode.
+var ErrInvalidTimelineMode = InvalidArgumentError("invalid timeline mode")
+
+func (mode TimelineMode) normalize() (TimelineMode, error) {
+	switch mode {
+	case "":
+		return TimelineChronological, nil
+	case TimelineChronological, TimelineRanked:
+		return mode, nil
+	}
+	return "", ErrInvalidTimelineMode
+}
+
+// timelineRank is the position of a timeline item within a ranking.
+type timelineRank struct {
+	rankingID string
+	position  int64
+}
+
+func encodeRankedCursor(r timelineRank) string {
+	s := fmt.Sprintf("%s,%s,%d", TimelineRanked, r.rankingID, r.position)
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func decodeRankedCursor(s string) (timelineRank, error) {
+	var r timelineRank
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return r, fmt.Errorf("could not base64 decode cursor: %w", err)
+	}
+
+	parts := strings.Split(string(b), ",")
+	if len(parts) != 3 {
+		return r, errors.New("expected cursor to have three items split by comma")
+	}
+
+	if TimelineMode(parts[0]) != TimelineRanked {
+		return r, errors.New("cursor mode mismatch")
+	}
+
+	r.rankingID = parts[1]
+	if r.position, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+		return r, fmt.Errorf("could not parse cursor position: %w", err)
+	}
+
+	return r, nil
+}
+
+// rankTimeline scores the recent posts of the user timeline and saves their order,
+// so it stays the same while paginating even if the scores change.
+// A post scores higher the more the user interacted with its author,
+// the more engagement it got and the newer it is.
+// Rankings older than timelineRankingMaxAge are deleted.
+func (s *Service) rankTimeline(ctx context.Context, uid string) (string, error) {
+	query := "DELETE FROM timeline_rankings WHERE user_id = $1 AND created_at < $2"
+	if _, err := s.Db.ExecContext(ctx, query, uid, time.Now().Add(-timelineRankingMaxAge)); err != nil {
+		return "", fmt.Errorf("could not delete expired timeline rankings: %w", err)
+	}
+
+	query = `
+		WITH affinities AS (
+			SELECT author_id, sum(n) AS n FROM (
+				SELECT posts.user_id AS author_id, count(*) AS n
+				FROM post_likes
+				INNER JOIN posts ON post_likes.post_id = posts.id
+				WHERE post_likes.user_id = $1 AND posts.user_id != $1
+				GROUP BY posts.user_id
+				UNION ALL
+				SELECT posts.user_id AS author_id, 2 * count(*) AS n
+				FROM comments
+				INNER JOIN posts ON comments.post_id = posts.id
+				WHERE comments.user_id = $1 AND posts.user_id != $1
+				GROUP BY posts.user_id
+			) AS interactions
+			GROUP BY author_id
+		), candidates AS (
+			SELECT timeline.post_id
+			, (1 + ln(1 + COALESCE(affinities.n, 0)::float8))
+				* (1 + ln(1 + (posts.likes_count + 2 * posts.comments_count + 3 * posts.reposts_count)::float8))
+				/ power(extract(epoch FROM now() - posts.created_at)::float8 / 3600 + 2, 1.5) AS score
+			FROM ` + homeTimelineSQL("$1", "AND posts.created_at > $2") + ` AS timeline
+			INNER JOIN posts ON timeline.post_id = posts.id
+			LEFT JOIN affinities ON affinities.author_id = posts.user_id
+			WHERE timeline.user_id = $1
+				AND posts.created_at > $2
+			ORDER BY score DESC, timeline.post_id DESC
+			LIMIT $3
+		)
+		INSERT INTO timeline_rankings (user_id, post_ids)
+		SELECT $1, COALESCE(array_agg(post_id ORDER BY score DESC, post_id DESC), '{}') FROM candidates
+		RETURNING id`
+	var rankingID string
+	err := s.Db.QueryRowContext(ctx, query, uid, time.Now().Add(-timelineRankingWindow), timelineRankingCandidates).
+		Scan(&rankingID)
+	if isForeignKeyViolation(err) {
+		return "", ErrUserGone
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not insert timeline ranking: %w", err)
+	}
+
+	return rankingID, nil
+}
+
+// rankingAlive tells whether the ranking of the user can still be paginated.
+func (s *Service) rankingAlive(ctx context.Context, uid, rankingID string) (bool, error) {
+	var alive bool
+	query := "SELECT EXISTS (SELECT 1 FROM timeline_rankings WHERE id = $1 AND user_id = $2 AND created_at >= $3)"
+	err := s.Db.QueryRowContext(ctx, query, rankingID, uid, time.Now().Add(-timelineRankingMaxAge)).Scan(&alive)
+	if err != nil {
+		return false, fmt.Errorf("could not query select timeline ranking existence: %w", err)
+	}
+
+	return alive, nil
+}
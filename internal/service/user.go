@@ -26,7 +26,9 @@ const (
 var (
 	reEmail    = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
 	reUsername = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,17}$`)
-	avatarsDir = path.Join("static", "img", "avatars")
+	// reRemoteUsername matches the "username@host" remote actors are stored with.
+	reRemoteUsername = regexp.MustCompile(`^[^@/\s]+@[a-zA-Z0-9.-]+(:[0-9]+)?$`)
+	avatarsDir       = path.Join("static", "img", "avatars")
 )
 
 var (
@@ -62,6 +64,15 @@ var (
 
 type UserProfiles []UserProfile
 
+// EndCursor of users listed by username; the username to list the next ones after.
+func (uu UserProfiles) EndCursor() *string {
+	if len(uu) == 0 {
+		return nil
+	}
+
+	return ptrString(uu[len(uu)-1].Username)
+}
+
 type User struct {
 	ID        string  `json:"id,omitempty"`
 	Username  string  `json:"username"`
@@ -132,6 +143,7 @@ type ToggleFollowOutput struct {
 }
 
 // ToggleFollow between two users.
+// Users of remote instances are given as "username@host".
 func (s *Service) ToggleFollow(ctx context.Context, username string) (ToggleFollowOutput, error) {
 	var out ToggleFollowOutput
 	followerID, ok := ctx.Value(KeyAuthUserID).(string)
@@ -140,6 +152,14 @@ func (s *Service) ToggleFollow(ctx context.Context, username string) (ToggleFoll
 	}
 
 	username = strings.TrimSpace(username)
+	if name, host, ok := strings.Cut(username, "@"); ok {
+		if !strings.EqualFold(host, s.originHost()) {
+			return s.toggleRemoteFollow(ctx, followerID, name, host)
+		}
+
+		username = name
+	}
+
 	if !ValidUsername(username) {
 		return out, ErrInvalidUsername
 	}
@@ -165,6 +185,8 @@ func (s *Service) ToggleFollow(ctx context.Context, username string) (ToggleFoll
 	if err != nil {
 		return out, fmt.Errorf("could not query select existence of follow: %w", err)
 	}
+	// timeline items removed on unfollow
+	var purged []string
 	// database transaction starts from here
 	tx, err := s.Db.BeginTx(ctx, nil)
 	if err != nil {
@@ -191,6 +213,11 @@ func (s *Service) ToggleFollow(ctx context.Context, username string) (ToggleFoll
 			tx.Rollback()
 			return out, fmt.Errorf("could not decrement followers count: %w", err)
 		}
+
+		if purged, err = purgeTimeline(ctx, tx, followerID, followeeID, s.KeepInteractedOnUnfollow); err != nil {
+			tx.Rollback()
+			return out, err
+		}
 	} else {
 		query = "INSERT INTO follows (follower_id, followee_id) VALUES ($1, $2)"
 		_, err = tx.ExecContext(ctx, query, followerID, followeeID)
@@ -213,6 +240,16 @@ func (s *Service) ToggleFollow(ctx context.Context, username string) (ToggleFoll
 			tx.Rollback()
 			return out, fmt.Errorf("could not increment followers count: %w", err)
 		}
+
+		err = enqueue(ctx, tx, outboxFollowed, followedJob{
+			FollowerID:       followerID,
+			FolloweeID:       followeeID,
+			FolloweeUsername: username,
+		})
+		if err != nil {
+			tx.Rollback()
+			return out, err
+		}
 	}
 	if err := tx.Commit(); err != nil {
 		return out, err
@@ -220,7 +257,13 @@ func (s *Service) ToggleFollow(ctx context.Context, username string) (ToggleFoll
 	out.Following = !out.Following
 
 	if out.Following {
-		go s.notifyFollow(followerID, followeeID)
+		s.wakeOutbox()
+	} else if len(purged) != 0 {
+		go s.broadcastTimelineEvent(TimelineEvent{
+			Type:   "timeline_purged",
+			UserID: followerID,
+			Data:   TimelineChange{Username: username, TimelineItemIDs: purged},
+		})
 	}
 	return out, nil
 }
@@ -230,13 +273,13 @@ func (s *Service) User(ctx context.Context, username string) (UserProfile, error
 	var u UserProfile
 
 	username = strings.TrimSpace(username)
-	if !ValidUsername(username) {
+	if !validUserHandle(username) {
 		return u, ErrInvalidUsername
 	}
 
 	uid, auth := ctx.Value(KeyAuthUserID).(string)
 	query, args, err := buildQuery(`
-		SELECT id, email, avatar, followers_count, followees_count
+		SELECT id, COALESCE(email, '') AS email, avatar, followers_count, followees_count
 		{{if .auth}}
 		, followers.follower_id IS NOT NULL AS following
 		, followees.followee_id IS NOT NULL AS followeed
@@ -288,7 +331,7 @@ func (s *Service) Users(ctx context.Context, search string, first uint64, after
 
 	uid, auth := ctx.Value(KeyAuthUserID).(string)
 	query, args, err := buildQuery(`
-		SELECT id, email, username, avatar, followers_count, followees_count
+		SELECT id, COALESCE(email, '') AS email, username, avatar, followers_count, followees_count
 		{{ if .auth }}
 		, followers.follower_id IS NOT NULL AS following
 		, followees.followee_id IS NOT NULL AS followeed
@@ -361,7 +404,7 @@ func (s *Service) Users(ctx context.Context, search string, first uint64, after
 // Followers in ascending order with forward pagination.
 func (s *Service) Followers(ctx context.Context, username string, first uint64, after string) (UserProfiles, error) {
 	username = strings.TrimSpace(username)
-	if !ValidUsername(username) {
+	if !validUserHandle(username) {
 		return nil, ErrInvalidUsername
 	}
 
@@ -369,7 +412,7 @@ func (s *Service) Followers(ctx context.Context, username string, first uint64,
 	uid, auth := ctx.Value(KeyAuthUserID).(string)
 	query, args, err := buildQuery(`
 		SELECT users.id
-		, users.email
+		, COALESCE(users.email, '') AS email
 		, users.username
 		, users.avatar
 		, users.followers_count
@@ -445,7 +488,7 @@ func (s *Service) Followers(ctx context.Context, username string, first uint64,
 // Followees in ascending order with forward pagination.
 func (s *Service) Followees(ctx context.Context, username string, first uint64, after string) (UserProfiles, error) {
 	username = strings.TrimSpace(username)
-	if !ValidUsername(username) {
+	if !validUserHandle(username) {
 		return nil, ErrInvalidUsername
 	}
 
@@ -453,7 +496,7 @@ func (s *Service) Followees(ctx context.Context, username string, first uint64,
 	uid, auth := ctx.Value(KeyAuthUserID).(string)
 	query, args, err := buildQuery(`
 		SELECT users.id
-		, users.email
+		, COALESCE(users.email, '') AS email
 		, users.username
 		, users.avatar
 		, users.followers_count
@@ -601,3 +644,9 @@ func (s *Service) avatarURL(avatar sql.NullString) *string {
 func ValidUsername(s string) bool {
 	return reUsername.MatchString(s)
 }
+
+// validUserHandle reports whether s is the username of either a local user
+// or a remote one, as "username@host".
+func validUserHandle(s string) bool {
+	return ValidUsername(s) || reRemoteUsername.MatchString(s)
+}
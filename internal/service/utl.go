@@ -154,8 +154,12 @@ func ptrString(v string) *string {
 	return &v
 }
 
+func ptrBool(v bool) *bool {
+	return &v
+}
+
 type client interface {
-	*timelineItemClient | *commentClient | *notificationClient
+	*timelineItemClient | *commentClient | *notificationClient | *listClient
 }
 
 type Set[C client] map[C]struct{}
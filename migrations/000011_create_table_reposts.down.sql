@@ -0,0 +1,8 @@
+ALTER TABLE timeline DROP COLUMN IF EXISTS reposted_by;
+
+ALTER TABLE posts
+    DROP COLUMN IF EXISTS quotes_count,
+    DROP COLUMN IF EXISTS reposts_count,
+    DROP COLUMN IF EXISTS quote_id;
+
+DROP TABLE IF EXISTS reposts;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE reposts (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES posts ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, post_id)
+);
+
+ALTER TABLE posts
+    ADD COLUMN quote_id UUID REFERENCES posts ON DELETE SET NULL,
+    ADD COLUMN reposts_count INT NOT NULL DEFAULT 0 CHECK (reposts_count >= 0),
+    ADD COLUMN quotes_count INT NOT NULL DEFAULT 0 CHECK (quotes_count >= 0);
+
+ALTER TABLE timeline ADD COLUMN reposted_by UUID REFERENCES users ON DELETE CASCADE;
\ No newline at end of file
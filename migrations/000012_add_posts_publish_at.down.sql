@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS scheduled_posts;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS publish_at;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE posts ADD COLUMN publish_at TIMESTAMPTZ;
+
+CREATE INDEX scheduled_posts ON posts (publish_at) WHERE publish_at IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE drafts (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    content VARCHAR NOT NULL DEFAULT '',
+    spoiler_of VARCHAR,
+    nsfw BOOLEAN NOT NULL DEFAULT false,
+    quote_id UUID REFERENCES posts ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX sorted_drafts ON drafts (user_id, updated_at DESC, id);
\ No newline at end of file
@@ -0,0 +1,28 @@
+CREATE TABLE polls (
+    post_id UUID NOT NULL PRIMARY KEY REFERENCES posts ON DELETE CASCADE,
+    multiple BOOLEAN NOT NULL DEFAULT false,
+    hide_results BOOLEAN NOT NULL DEFAULT false,
+    voters_count INT NOT NULL DEFAULT 0 CHECK (voters_count >= 0),
+    closes_at TIMESTAMPTZ NOT NULL,
+    closed_at TIMESTAMPTZ
+);
+
+CREATE INDEX closing_polls ON polls (closes_at) WHERE closed_at IS NULL;
+
+CREATE TABLE poll_options (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    post_id UUID NOT NULL REFERENCES polls ON DELETE CASCADE,
+    position INT NOT NULL,
+    text VARCHAR NOT NULL,
+    votes_count INT NOT NULL DEFAULT 0 CHECK (votes_count >= 0),
+    UNIQUE (post_id, position)
+);
+
+CREATE TABLE poll_votes (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    option_id UUID NOT NULL REFERENCES poll_options ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES polls ON DELETE CASCADE,
+    PRIMARY KEY (user_id, option_id)
+);
+
+CREATE INDEX poll_voters ON poll_votes (post_id, user_id);
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE link_previews (
+    url VARCHAR NOT NULL PRIMARY KEY,
+    title VARCHAR,
+    description VARCHAR,
+    image_url VARCHAR,
+    site_name VARCHAR,
+    failed BOOLEAN NOT NULL DEFAULT false,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
\ No newline at end of file
@@ -0,0 +1,12 @@
+DELETE FROM notifications WHERE comment_id IS NOT NULL;
+
+DROP INDEX IF EXISTS unique_notifications;
+CREATE UNIQUE INDEX unique_notifications ON notifications(user_id, type, post_id, read_at) NULLS NOT DISTINCT;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS comment_id;
+
+DROP INDEX IF EXISTS sorted_comment_replies;
+
+ALTER TABLE comments
+    DROP COLUMN IF EXISTS replies_count,
+    DROP COLUMN IF EXISTS parent_id;
\ No newline at end of file
@@ -0,0 +1,10 @@
+ALTER TABLE comments
+    ADD COLUMN parent_id UUID REFERENCES comments ON DELETE CASCADE,
+    ADD COLUMN replies_count INT NOT NULL DEFAULT 0 CHECK (replies_count >= 0);
+
+CREATE INDEX sorted_comment_replies ON comments (parent_id, created_at DESC, id) WHERE parent_id IS NOT NULL;
+
+ALTER TABLE notifications ADD COLUMN comment_id UUID REFERENCES comments ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS unique_notifications;
+CREATE UNIQUE INDEX unique_notifications ON notifications(user_id, type, post_id, comment_id, read_at) NULLS NOT DISTINCT;
\ No newline at end of file
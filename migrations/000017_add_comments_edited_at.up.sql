@@ -0,0 +1 @@
+ALTER TABLE comments ADD COLUMN edited_at TIMESTAMPTZ;
\ No newline at end of file
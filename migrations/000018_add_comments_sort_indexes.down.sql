@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS top_comment_replies;
+DROP INDEX IF EXISTS oldest_comment_replies;
+DROP INDEX IF EXISTS top_comments;
+DROP INDEX IF EXISTS oldest_comments;
\ No newline at end of file
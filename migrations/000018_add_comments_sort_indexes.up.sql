@@ -0,0 +1,4 @@
+CREATE INDEX oldest_comments ON comments (post_id, created_at, id) WHERE parent_id IS NULL;
+CREATE INDEX top_comments ON comments (post_id, likes_count DESC, created_at DESC, id DESC) WHERE parent_id IS NULL;
+CREATE INDEX oldest_comment_replies ON comments (parent_id, created_at, id) WHERE parent_id IS NOT NULL;
+CREATE INDEX top_comment_replies ON comments (parent_id, likes_count DESC, created_at DESC, id DESC) WHERE parent_id IS NOT NULL;
\ No newline at end of file
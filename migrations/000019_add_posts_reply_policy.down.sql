@@ -0,0 +1,3 @@
+ALTER TABLE posts
+    DROP COLUMN IF EXISTS pinned_comment_id,
+    DROP COLUMN IF EXISTS reply_policy;
\ No newline at end of file
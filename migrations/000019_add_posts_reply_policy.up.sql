@@ -0,0 +1,4 @@
+ALTER TABLE posts
+    ADD COLUMN reply_policy VARCHAR NOT NULL DEFAULT 'everyone'
+        CHECK (reply_policy IN ('everyone', 'followers', 'following', 'mentioned', 'nobody')),
+    ADD COLUMN pinned_comment_id UUID REFERENCES comments ON DELETE SET NULL;
\ No newline at end of file
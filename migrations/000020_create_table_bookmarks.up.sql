@@ -0,0 +1,17 @@
+CREATE TABLE bookmark_collections (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE bookmarks (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES posts ON DELETE CASCADE,
+    collection_id UUID REFERENCES bookmark_collections ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX sorted_bookmarks ON bookmarks (user_id, created_at DESC, post_id);
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS post_mentions;
+ALTER TABLE posts DROP COLUMN IF EXISTS visibility;
\ No newline at end of file
@@ -0,0 +1,10 @@
+ALTER TABLE posts ADD COLUMN visibility VARCHAR NOT NULL DEFAULT 'public'
+    CHECK (visibility IN ('public', 'followers', 'mentioned'));
+
+CREATE TABLE post_mentions (
+    post_id UUID NOT NULL REFERENCES posts ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    PRIMARY KEY (post_id, user_id)
+);
+
+CREATE INDEX user_post_mentions ON post_mentions (user_id, post_id);
\ No newline at end of file
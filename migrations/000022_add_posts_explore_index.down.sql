@@ -0,0 +1 @@
+DROP INDEX IF EXISTS explore_posts;
\ No newline at end of file
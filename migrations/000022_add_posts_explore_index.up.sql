@@ -0,0 +1 @@
+CREATE INDEX explore_posts ON posts (created_at DESC, id) WHERE visibility = 'public' AND publish_at IS NULL;
\ No newline at end of file
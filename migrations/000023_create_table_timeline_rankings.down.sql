@@ -0,0 +1 @@
+DROP TABLE IF EXISTS timeline_rankings;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE timeline_rankings (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_ids UUID[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_timeline_rankings ON timeline_rankings (user_id, created_at);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS unfanned_posts;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS fanned_out;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE posts ADD COLUMN fanned_out BOOLEAN NOT NULL DEFAULT true;
+
+CREATE INDEX unfanned_posts ON posts (user_id, created_at DESC) WHERE NOT fanned_out;
\ No newline at end of file
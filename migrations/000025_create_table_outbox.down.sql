@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS admin;
+
+DROP TABLE IF EXISTS outbox;
\ No newline at end of file
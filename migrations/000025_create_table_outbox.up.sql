@@ -0,0 +1,15 @@
+CREATE TABLE outbox (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    kind VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error VARCHAR,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    dead_at TIMESTAMPTZ
+);
+
+CREATE INDEX due_outbox ON outbox (run_at) WHERE dead_at IS NULL;
+CREATE INDEX stuck_outbox ON outbox (created_at DESC, id) WHERE last_error IS NOT NULL;
+
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT false;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE post_tags (
+    post_id UUID NOT NULL REFERENCES posts ON DELETE CASCADE,
+    tag VARCHAR NOT NULL,
+    PRIMARY KEY (post_id, tag)
+);
+
+CREATE INDEX tag_post_tags ON post_tags (tag, post_id);
+
+-- Tags of the existing posts, matched like reTags does.
+INSERT INTO post_tags (post_id, tag)
+SELECT DISTINCT posts.id, lower(m[1])
+FROM posts, regexp_matches(posts.content, '\Y#([[:alnum:]_]+)(?:\y[^#]|$)', 'g') AS m
+ON CONFLICT (post_id, tag) DO NOTHING;
\ No newline at end of file
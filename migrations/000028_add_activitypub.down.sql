@@ -0,0 +1,10 @@
+ALTER TABLE comments DROP COLUMN IF EXISTS ap_id;
+
+DELETE FROM users WHERE actor_id IS NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS private_key;
+ALTER TABLE users DROP COLUMN IF EXISTS public_key;
+ALTER TABLE users DROP COLUMN IF EXISTS public_key_id;
+ALTER TABLE users DROP COLUMN IF EXISTS shared_inbox;
+ALTER TABLE users DROP COLUMN IF EXISTS inbox;
+ALTER TABLE users DROP COLUMN IF EXISTS actor_id;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE users ADD COLUMN actor_id VARCHAR UNIQUE;
+ALTER TABLE users ADD COLUMN inbox VARCHAR;
+ALTER TABLE users ADD COLUMN shared_inbox VARCHAR;
+ALTER TABLE users ADD COLUMN public_key_id VARCHAR;
+ALTER TABLE users ADD COLUMN public_key VARCHAR;
+ALTER TABLE users ADD COLUMN private_key VARCHAR;
+
+ALTER TABLE comments ADD COLUMN ap_id VARCHAR UNIQUE;
\ No newline at end of file
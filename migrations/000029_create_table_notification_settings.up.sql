@@ -0,0 +1,7 @@
+CREATE TABLE notification_settings (
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    type VARCHAR NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    only_following BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (user_id, type)
+);
\ No newline at end of file
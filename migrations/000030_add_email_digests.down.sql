@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS due_digest_users;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS digested_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS digest_sent_at;
+ALTER TABLE users DROP COLUMN IF EXISTS digest_frequency;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN digest_frequency VARCHAR NOT NULL DEFAULT 'off' CHECK (digest_frequency IN ('off', 'daily', 'weekly'));
+ALTER TABLE users ADD COLUMN digest_sent_at TIMESTAMPTZ;
+
+ALTER TABLE notifications ADD COLUMN digested_at TIMESTAMPTZ;
+
+CREATE INDEX due_digest_users ON users (digest_frequency, digest_sent_at) WHERE digest_frequency != 'off';
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE push_subscriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+    endpoint VARCHAR NOT NULL UNIQUE,
+    p256dh VARCHAR NOT NULL,
+    auth VARCHAR NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_push_subscriptions ON push_subscriptions (user_id, created_at DESC);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS user_posts;
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS user_posts ON posts (user_id, created_at DESC, id);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE push_subscriptions DROP COLUMN IF EXISTS vapid_public_key;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE push_subscriptions ADD COLUMN vapid_public_key VARCHAR;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE notifications DROP COLUMN IF EXISTS pushed_actors;
\ No newline at end of file
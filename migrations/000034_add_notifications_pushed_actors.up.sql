@@ -0,0 +1 @@
+ALTER TABLE notifications ADD COLUMN pushed_actors VARCHAR[];
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE drafts
+    DROP COLUMN IF EXISTS reply_policy,
+    DROP COLUMN IF EXISTS visibility;
\ No newline at end of file
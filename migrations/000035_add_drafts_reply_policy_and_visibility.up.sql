@@ -0,0 +1,5 @@
+ALTER TABLE drafts
+    ADD COLUMN reply_policy VARCHAR NOT NULL DEFAULT 'everyone'
+        CHECK (reply_policy IN ('everyone', 'followers', 'following', 'mentioned', 'nobody')),
+    ADD COLUMN visibility VARCHAR NOT NULL DEFAULT 'public'
+        CHECK (visibility IN ('public', 'followers', 'mentioned'));
\ No newline at end of file
@@ -96,6 +96,16 @@ function renderNotification(notification) {
         notification.postID
       )}">comment(s)</a> of a post`;
       break;
+    case "post_like":
+      content += ` liked your <a href="/posts/${encodeURIComponent(
+        notification.postID
+      )}">post</a>`;
+      break;
+    case "comment_like":
+      content += ` liked your <a href="/posts/${encodeURIComponent(
+        notification.postID
+      )}">comment</a>`;
+      break;
     default:
       content += " did something";
   }
@@ -89,6 +89,10 @@ function getNotificationBody(notification) {
       return actorsText + " mentioned you on a post";
     case "comment_mention":
       return actorsText + " mentioned you on comment(s) of a post";
+    case "post_like":
+      return actorsText + " liked your post";
+    case "comment_like":
+      return actorsText + " liked your comment";
     default:
       return actorsText + " did something";
   }